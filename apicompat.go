@@ -0,0 +1,186 @@
+package main
+
+// apicompat.go — gating releases on OpenAPI compatibility.
+//
+// The baseline is the spec of the last successful Build
+// (openapi/api.released.json), or else the committed
+// openapi/api.swagger.json at git HEAD. The current spec is diffed against
+// it (apidiff.go), the report is written to openapi/api.changes.json, and
+// breaking changes are only allowed with a major version bump of the
+// service. The baseline's version is its info.version, which
+// src/openapi.py fills with the service version.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	apiReleasedPath = "openapi/api.released.json"
+	apiReportPath   = "openapi/api.changes.json"
+)
+
+// API compatibility modes (api-compatibility setting).
+const (
+	APICompatibilityFail = "fail"
+	APICompatibilityWarn = "warn"
+	APICompatibilityOff  = "off"
+)
+
+// CompatibilityMode is the api-compatibility setting; empty means fail.
+func (s *Settings) CompatibilityMode() (string, error) {
+	switch s.APICompatibility {
+	case "":
+		return APICompatibilityFail, nil
+	case APICompatibilityFail, APICompatibilityWarn, APICompatibilityOff:
+		return s.APICompatibility, nil
+	default:
+		return "", fmt.Errorf("unknown api-compatibility %q (expected %s, %s or %s)",
+			s.APICompatibility, APICompatibilityFail, APICompatibilityWarn, APICompatibilityOff)
+	}
+}
+
+// APIReport is the machine-readable result written to api.changes.json.
+type APIReport struct {
+	// Baseline is where the previous spec came from: "released",
+	// "git:HEAD", or empty when there was none to compare against.
+	Baseline        string      `json:"baseline"`
+	BaselineVersion string      `json:"baseline_version,omitempty"`
+	Version         string      `json:"version"`
+	MajorBump       bool        `json:"major_bump"`
+	Breaking        []APIChange `json:"breaking"`
+	NonBreaking     []APIChange `json:"non_breaking"`
+}
+
+// Blocking reports breaking changes not covered by a major version bump.
+func (r *APIReport) Blocking() bool {
+	return len(r.Breaking) > 0 && !r.MajorBump
+}
+
+// CheckAPICompatibility diffs the current spec against the baseline and
+// writes the report. It returns nil when there is no spec yet.
+func (s *Service) CheckAPICompatibility(ctx context.Context) (*APIReport, error) {
+	current, err := os.ReadFile(s.Local(standards.OpenAPIPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read openapi spec")
+	}
+
+	report := &APIReport{Version: s.Base.Service.Version, Breaking: []APIChange{}, NonBreaking: []APIChange{}}
+	if baseline, source := s.apiBaseline(ctx); baseline != nil {
+		changes, err := diffOpenAPI(baseline, current)
+		if err != nil {
+			return nil, s.Wool.Wrapf(err, "cannot diff openapi spec against %s", source)
+		}
+		doc, _ := parseOpenAPIDoc(baseline)
+		report.Baseline = source
+		report.BaselineVersion = doc.Version()
+		report.MajorBump = isMajorBump(report.BaselineVersion, report.Version)
+		for _, change := range changes {
+			if change.Breaking {
+				report.Breaking = append(report.Breaking, change)
+			} else {
+				report.NonBreaking = append(report.NonBreaking, change)
+			}
+		}
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.Local(apiReportPath), content, 0o644); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write api report")
+	}
+	return report, nil
+}
+
+// apiBaseline returns the released spec, or the one committed at HEAD.
+func (s *Service) apiBaseline(ctx context.Context) ([]byte, string) {
+	if content, err := os.ReadFile(s.Local(apiReleasedPath)); err == nil {
+		return content, "released"
+	}
+	// "./" makes the path relative to -C, wherever the repository root is.
+	content, err := exec.CommandContext(ctx, "git", "-C", s.Location, "show", "HEAD:./"+standards.OpenAPIPath).Output()
+	if err != nil {
+		s.Wool.Debug("no committed openapi spec to compare against", wool.ErrField(err))
+		return nil, ""
+	}
+	return content, "git:HEAD"
+}
+
+// ReleaseAPI records the current spec as the baseline of the next check.
+func (s *Service) ReleaseAPI(ctx context.Context) error {
+	current := s.Local(standards.OpenAPIPath)
+	exists, err := shared.FileExists(ctx, current)
+	if err != nil || !exists {
+		return err
+	}
+	return shared.CopyFile(ctx, current, s.Local(apiReleasedPath))
+}
+
+// isMajorBump reports whether version is a major bump over baseline. Below
+// 1.0.0 a minor bump counts, as semver allows breaking changes there.
+func isMajorBump(baseline, version string) bool {
+	oldMajor, oldMinor, ok := majorMinor(baseline)
+	if !ok {
+		return false
+	}
+	newMajor, newMinor, ok := majorMinor(version)
+	if !ok {
+		return false
+	}
+	if newMajor != oldMajor {
+		return newMajor > oldMajor
+	}
+	return newMajor == 0 && newMinor > oldMinor
+}
+
+func majorMinor(version string) (int, int, bool) {
+	version, _, _ = strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// checkAPI is the Build gate: it logs the breaking changes and fails when
+// they are not covered by a major bump (api-compatibility: fail).
+func (s *Builder) checkAPI(ctx context.Context) error {
+	mode, err := s.FastAPI.Settings.CompatibilityMode()
+	if err != nil || mode == APICompatibilityOff {
+		return err
+	}
+	report, err := s.FastAPI.CheckAPICompatibility(ctx)
+	if err != nil || report == nil {
+		return err
+	}
+	for _, change := range report.Breaking {
+		s.Wool.Warn("breaking API change", wool.Field("change", change.String()))
+	}
+	if report.Blocking() && mode == APICompatibilityFail {
+		return fmt.Errorf("%d breaking API change(s) since %s (version %s) without a major version bump; see %s",
+			len(report.Breaking), report.Baseline, report.BaselineVersion, apiReportPath)
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+package main
+
+// apidiff.go — classifying the differences between two OpenAPI documents.
+//
+// The diff is consumer-centric: a change is breaking when a client written
+// against the old spec can fail against the new one. Requests and responses
+// go in opposite directions, so the same edit can be breaking on one side
+// and harmless on the other: narrowing what a request accepts breaks
+// callers, narrowing what a response returns does not.
+//
+// Specs are read as plain JSON (FastAPI emits OpenAPI 3.1) and local $refs
+// are resolved against the document's own components.
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// APIChange is one difference between two specs.
+type APIChange struct {
+	Kind     string `json:"kind"`
+	Breaking bool   `json:"breaking"`
+	Path     string `json:"path"`
+	Method   string `json:"method,omitempty"`
+	// Location points inside the operation, e.g. "query.limit",
+	// "request.body.name" or "response.200.items[].id".
+	Location string `json:"location,omitempty"`
+	Detail   string `json:"detail"`
+}
+
+func (c APIChange) String() string {
+	where := c.Path
+	if c.Method != "" {
+		where = strings.ToUpper(c.Method) + " " + where
+	}
+	if c.Location != "" {
+		where += " " + c.Location
+	}
+	return fmt.Sprintf("%s: %s (%s)", where, c.Detail, c.Kind)
+}
+
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// maxSchemaDepth stops the walk on recursive schemas the ref guard misses.
+const maxSchemaDepth = 32
+
+type openapiDoc struct {
+	root map[string]any
+}
+
+func parseOpenAPIDoc(content []byte) (*openapiDoc, error) {
+	var root map[string]any
+	if err := json.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	return &openapiDoc{root: root}, nil
+}
+
+// Version is info.version: the service version the spec was generated for.
+func (d *openapiDoc) Version() string {
+	info, _ := d.root["info"].(map[string]any)
+	v, _ := info["version"].(string)
+	return v
+}
+
+// resolve follows local $refs ("#/components/schemas/X").
+func (d *openapiDoc) resolve(node map[string]any) (map[string]any, string) {
+	ref := ""
+	for i := 0; node != nil && i < maxSchemaDepth; i++ {
+		r, ok := node["$ref"].(string)
+		if !ok {
+			return node, ref
+		}
+		ref = r
+		var cur any = d.root
+		for _, part := range strings.Split(strings.TrimPrefix(r, "#/"), "/") {
+			m, _ := cur.(map[string]any)
+			cur = m[part]
+		}
+		node, _ = cur.(map[string]any)
+	}
+	return node, ref
+}
+
+func (d *openapiDoc) operations() map[string]map[string]map[string]any {
+	out := map[string]map[string]map[string]any{}
+	paths, _ := d.root["paths"].(map[string]any)
+	for p, item := range paths {
+		pathItem, _ := item.(map[string]any)
+		ops := map[string]map[string]any{}
+		for _, method := range httpMethods {
+			if op, ok := pathItem[method].(map[string]any); ok {
+				ops[method] = withPathParameters(op, pathItem)
+			}
+		}
+		out[p] = ops
+	}
+	return out
+}
+
+// withPathParameters merges the path-level parameters into an operation.
+func withPathParameters(op, pathItem map[string]any) map[string]any {
+	shared, _ := pathItem["parameters"].([]any)
+	if len(shared) == 0 {
+		return op
+	}
+	merged := map[string]any{}
+	for k, v := range op {
+		merged[k] = v
+	}
+	own, _ := op["parameters"].([]any)
+	merged["parameters"] = append(slices.Clone(shared), own...)
+	return merged
+}
+
+// diffOpenAPI lists the changes from old to new, breaking ones first.
+func diffOpenAPI(oldSpec, newSpec []byte) ([]APIChange, error) {
+	oldDoc, err := parseOpenAPIDoc(oldSpec)
+	if err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+	newDoc, err := parseOpenAPIDoc(newSpec)
+	if err != nil {
+		return nil, err
+	}
+	d := &differ{old: oldDoc, new: newDoc, visiting: map[string]bool{}}
+	d.diff()
+	sort.SliceStable(d.changes, func(i, j int) bool {
+		return d.changes[i].Breaking && !d.changes[j].Breaking
+	})
+	return d.changes, nil
+}
+
+type differ struct {
+	old, new *openapiDoc
+	changes  []APIChange
+
+	// current operation, stamped on every change
+	path, method string
+	visiting     map[string]bool
+}
+
+func (d *differ) add(kind string, breaking bool, location, format string, args ...any) {
+	d.changes = append(d.changes, APIChange{
+		Kind: kind, Breaking: breaking, Path: d.path, Method: d.method,
+		Location: location, Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+func (d *differ) diff() {
+	oldOps, newOps := d.old.operations(), d.new.operations()
+	for _, p := range sortedKeys(oldOps) {
+		d.path, d.method = p, ""
+		newPath, ok := newOps[p]
+		if !ok {
+			d.add("path-removed", true, "", "path removed")
+			continue
+		}
+		for _, method := range sortedKeys(oldOps[p]) {
+			d.method = method
+			newOp, ok := newPath[method]
+			if !ok {
+				d.add("operation-removed", true, "", "operation removed")
+				continue
+			}
+			d.diffOperation(oldOps[p][method], newOp)
+		}
+		for _, method := range sortedKeys(newPath) {
+			if _, ok := oldOps[p][method]; !ok {
+				d.method = method
+				d.add("operation-added", false, "", "operation added")
+			}
+		}
+	}
+	for _, p := range sortedKeys(newOps) {
+		if _, ok := oldOps[p]; !ok {
+			d.path, d.method = p, ""
+			d.add("path-added", false, "", "path added")
+		}
+	}
+}
+
+func (d *differ) diffOperation(oldOp, newOp map[string]any) {
+	d.diffParameters(oldOp, newOp)
+	d.diffRequestBody(oldOp, newOp)
+	d.diffResponses(oldOp, newOp)
+}
+
+func parameters(doc *openapiDoc, op map[string]any) map[string]map[string]any {
+	out := map[string]map[string]any{}
+	list, _ := op["parameters"].([]any)
+	for _, p := range list {
+		param, _ := p.(map[string]any)
+		param, _ = doc.resolve(param)
+		if param == nil {
+			continue
+		}
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		out[in+"."+name] = param
+	}
+	return out
+}
+
+func required(node map[string]any) bool {
+	r, _ := node["required"].(bool)
+	return r
+}
+
+func (d *differ) diffParameters(oldOp, newOp map[string]any) {
+	oldParams, newParams := parameters(d.old, oldOp), parameters(d.new, newOp)
+	for _, key := range sortedKeys(newParams) {
+		newParam := newParams[key]
+		oldParam, ok := oldParams[key]
+		switch {
+		case !ok && required(newParam):
+			d.add("required-parameter-added", true, key, "new required parameter")
+		case !ok:
+			d.add("parameter-added", false, key, "new optional parameter")
+		case required(newParam) && !required(oldParam):
+			d.add("parameter-now-required", true, key, "parameter became required")
+		}
+		if ok {
+			d.diffSchema(schemaOf(oldParam), schemaOf(newParam), true, key, 0)
+		}
+	}
+	for _, key := range sortedKeys(oldParams) {
+		if _, ok := newParams[key]; !ok {
+			// Servers ignore parameters they no longer read.
+			d.add("parameter-removed", false, key, "parameter removed")
+		}
+	}
+}
+
+func schemaOf(node map[string]any) map[string]any {
+	s, _ := node["schema"].(map[string]any)
+	return s
+}
+
+// jsonSchema picks the JSON media type of a request body or response.
+func jsonSchema(doc *openapiDoc, node map[string]any) map[string]any {
+	node, _ = doc.resolve(node)
+	content, _ := node["content"].(map[string]any)
+	for _, media := range []string{"application/json", "*/*"} {
+		if m, ok := content[media].(map[string]any); ok {
+			return schemaOf(m)
+		}
+	}
+	return nil
+}
+
+func (d *differ) diffRequestBody(oldOp, newOp map[string]any) {
+	oldBody, _ := oldOp["requestBody"].(map[string]any)
+	newBody, _ := newOp["requestBody"].(map[string]any)
+	oldBody, _ = d.old.resolve(oldBody)
+	newBody, _ = d.new.resolve(newBody)
+	switch {
+	case newBody == nil:
+		return
+	case oldBody == nil && required(newBody):
+		d.add("required-body-added", true, "request.body", "request body is now required")
+		return
+	case oldBody == nil:
+		return
+	case required(newBody) && !required(oldBody):
+		d.add("body-now-required", true, "request.body", "request body became required")
+	}
+	d.diffSchema(jsonSchema(d.old, oldBody), jsonSchema(d.new, newBody), true, "request.body", 0)
+}
+
+func (d *differ) diffResponses(oldOp, newOp map[string]any) {
+	oldResponses, _ := oldOp["responses"].(map[string]any)
+	newResponses, _ := newOp["responses"].(map[string]any)
+	for _, code := range sortedKeys(oldResponses) {
+		location := "response." + code
+		newResponse, ok := newResponses[code].(map[string]any)
+		if !ok {
+			d.add("response-status-removed", true, location, "response status %s removed", code)
+			continue
+		}
+		oldResponse, _ := oldResponses[code].(map[string]any)
+		d.diffSchema(jsonSchema(d.old, oldResponse), jsonSchema(d.new, newResponse), false, location, 0)
+	}
+	for _, code := range sortedKeys(newResponses) {
+		if _, ok := oldResponses[code]; !ok {
+			d.add("response-status-added", false, "response."+code, "response status %s added", code)
+		}
+	}
+}
+
+// diffSchema compares two schemas in the request (clients send) or the
+// response (clients receive) direction.
+func (d *differ) diffSchema(oldSchema, newSchema map[string]any, request bool, location string, depth int) {
+	if oldSchema == nil || newSchema == nil || depth > maxSchemaDepth {
+		return
+	}
+	oldSchema, oldRef := d.old.resolve(oldSchema)
+	newSchema, newRef := d.new.resolve(newSchema)
+	if oldSchema == nil || newSchema == nil {
+		return
+	}
+	if oldRef != "" || newRef != "" {
+		// Recursive models (a Node with children []Node) revisit the same
+		// pair of refs: compare each pair once per direction.
+		key := fmt.Sprintf("%s|%s|%v", oldRef, newRef, request)
+		if d.visiting[key] {
+			return
+		}
+		d.visiting[key] = true
+		defer delete(d.visiting, key)
+	}
+
+	d.diffTypes(schemaTypes(d.old, oldSchema), schemaTypes(d.new, newSchema), request, location)
+	d.diffEnum(oldSchema, newSchema, request, location)
+
+	oldProps, _ := oldSchema["properties"].(map[string]any)
+	newProps, _ := newSchema["properties"].(map[string]any)
+	oldRequired, newRequired := requiredSet(oldSchema), requiredSet(newSchema)
+	for _, name := range sortedKeys(newProps) {
+		at := location + "." + name
+		if _, ok := oldProps[name]; !ok {
+			if request && newRequired[name] {
+				d.add("required-property-added", true, at, "new required field")
+			} else {
+				d.add("property-added", false, at, "new field")
+			}
+			continue
+		}
+		switch {
+		case request && newRequired[name] && !oldRequired[name]:
+			d.add("property-now-required", true, at, "field became required")
+		case !request && oldRequired[name] && !newRequired[name]:
+			d.add("property-now-optional", true, at, "field may now be missing")
+		}
+		oldProp, _ := oldProps[name].(map[string]any)
+		newProp, _ := newProps[name].(map[string]any)
+		d.diffSchema(oldProp, newProp, request, at, depth+1)
+	}
+	for _, name := range sortedKeys(oldProps) {
+		if _, ok := newProps[name]; !ok {
+			// Clients still reading the field break; FastAPI ignores an
+			// extra field sent in a request.
+			d.add("property-removed", !request, location+"."+name, "field removed")
+		}
+	}
+
+	oldItems, _ := oldSchema["items"].(map[string]any)
+	newItems, _ := newSchema["items"].(map[string]any)
+	d.diffSchema(oldItems, newItems, request, location+"[]", depth+1)
+}
+
+// schemaTypes is the set of JSON types a schema admits, looking through
+// the anyOf/oneOf FastAPI uses for Optional and Union.
+func schemaTypes(doc *openapiDoc, schema map[string]any) []string {
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = append(types, t)
+	case []any:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		variants, _ := schema[key].([]any)
+		for _, v := range variants {
+			variant, _ := v.(map[string]any)
+			variant, _ = doc.resolve(variant)
+			if variant == nil {
+				continue
+			}
+			variantTypes := schemaTypes(doc, variant)
+			if len(variantTypes) == 0 {
+				// A variant with no type (e.g. a $ref'd object without one)
+				// admits anything: give up on the comparison.
+				return nil
+			}
+			types = append(types, variantTypes...)
+		}
+	}
+	if _, ok := schema["properties"]; ok && len(types) == 0 {
+		types = append(types, "object")
+	}
+	slices.Sort(types)
+	return slices.Compact(types)
+}
+
+// admits reports whether a value of type t is valid for a set of types.
+func admits(types []string, t string) bool {
+	return slices.Contains(types, t) || (t == "integer" && slices.Contains(types, "number"))
+}
+
+func (d *differ) diffTypes(oldTypes, newTypes []string, request bool, location string) {
+	if len(oldTypes) == 0 || len(newTypes) == 0 {
+		return
+	}
+	// Requests: everything clients sent must still be accepted.
+	// Responses: everything the server returns must have been expected.
+	from, to := oldTypes, newTypes
+	if !request {
+		from, to = newTypes, oldTypes
+	}
+	for _, t := range from {
+		if !admits(to, t) {
+			kind := "type-narrowed"
+			if !request {
+				kind = "type-changed"
+			}
+			d.add(kind, true, location, "type %s became %s", strings.Join(oldTypes, "|"), strings.Join(newTypes, "|"))
+			return
+		}
+	}
+	if !slices.Equal(oldTypes, newTypes) {
+		d.add("type-widened", false, location, "type %s became %s", strings.Join(oldTypes, "|"), strings.Join(newTypes, "|"))
+	}
+}
+
+func (d *differ) diffEnum(oldSchema, newSchema map[string]any, request bool, location string) {
+	oldEnum, oldOK := oldSchema["enum"].([]any)
+	newEnum, newOK := newSchema["enum"].([]any)
+	if !oldOK && !newOK {
+		return
+	}
+	values := func(list []any) map[string]bool {
+		out := map[string]bool{}
+		for _, v := range list {
+			out[fmt.Sprint(v)] = true
+		}
+		return out
+	}
+	oldValues, newValues := values(oldEnum), values(newEnum)
+	if !oldOK {
+		if request {
+			d.add("enum-added", true, location, "values restricted to an enum")
+		}
+		return
+	}
+	if !newOK {
+		if !request {
+			d.add("enum-removed", true, location, "values no longer restricted to an enum")
+		}
+		return
+	}
+	for _, v := range sortedKeys(oldValues) {
+		if !newValues[v] && request {
+			d.add("enum-value-removed", true, location, "value %q no longer accepted", v)
+		}
+	}
+	for _, v := range sortedKeys(newValues) {
+		if !oldValues[v] {
+			d.add("enum-value-added", !request, location, "value %q added", v)
+		}
+	}
+}
+
+func requiredSet(schema map[string]any) map[string]bool {
+	out := map[string]bool{}
+	list, _ := schema["required"].([]any)
+	for _, r := range list {
+		if s, ok := r.(string); ok {
+			out[s] = true
+		}
+	}
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
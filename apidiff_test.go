@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/wool"
+)
+
+// baseSpec is a FastAPI-shaped document: an Item model behind a $ref, an
+// optional query parameter and a recursive Node model.
+const baseSpec = `{
+  "openapi": "3.1.0",
+  "info": {"title": "src", "version": "1.2.0"},
+  "paths": {
+    "/items": {
+      "get": {
+        "parameters": [{"name": "limit", "in": "query", "required": false, "schema": {"type": "integer"}}],
+        "responses": {"200": {"description": "ok", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}}}}
+      },
+      "post": {
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+        "responses": {"200": {"description": "ok", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}}}
+      }
+    },
+    "/tree": {
+      "get": {"responses": {"200": {"description": "ok", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Node"}}}}}}
+    },
+    "/version": {
+      "get": {"responses": {"200": {"description": "ok"}}}
+    }
+  },
+  "components": {"schemas": {
+    "Item": {"type": "object", "properties": {"name": {"type": "string"}, "price": {"type": "number"}}, "required": ["name", "price"]},
+    "Node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}}}
+  }}
+}`
+
+// edit applies a JSON patch-like mutation to a copy of baseSpec.
+func edit(t *testing.T, mutate func(doc map[string]any)) []byte {
+	t.Helper()
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(baseSpec), &doc); err != nil {
+		t.Fatal(err)
+	}
+	mutate(doc)
+	out, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func node(doc map[string]any, keys ...string) map[string]any {
+	cur := doc
+	for _, k := range keys {
+		cur = cur[k].(map[string]any)
+	}
+	return cur
+}
+
+func kinds(changes []APIChange, breaking bool) []string {
+	var out []string
+	for _, c := range changes {
+		if c.Breaking == breaking {
+			out = append(out, c.Kind+" "+c.Location)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestDiffOpenAPI(t *testing.T) {
+	cases := []struct {
+		name        string
+		mutate      func(doc map[string]any)
+		breaking    []string
+		nonBreaking []string
+	}{
+		{
+			name:   "identical",
+			mutate: func(map[string]any) {},
+		},
+		{
+			name:     "path removed",
+			mutate:   func(doc map[string]any) { delete(node(doc, "paths"), "/version") },
+			breaking: []string{"path-removed "},
+		},
+		{
+			name: "path added",
+			mutate: func(doc map[string]any) {
+				node(doc, "paths")["/health"] = map[string]any{"get": map[string]any{"responses": map[string]any{}}}
+			},
+			nonBreaking: []string{"path-added "},
+		},
+		{
+			name: "new required query parameter",
+			mutate: func(doc map[string]any) {
+				get := node(doc, "paths", "/items", "get")
+				get["parameters"] = append(get["parameters"].([]any),
+					map[string]any{"name": "owner", "in": "query", "required": true, "schema": map[string]any{"type": "string"}})
+			},
+			breaking: []string{"required-parameter-added query.owner"},
+		},
+		{
+			name: "query parameter narrowed",
+			mutate: func(doc map[string]any) {
+				param := node(doc, "paths", "/items", "get")["parameters"].([]any)[0].(map[string]any)
+				param["schema"] = map[string]any{"type": "integer", "enum": []any{10, 50}}
+			},
+			breaking: []string{"enum-added query.limit"},
+		},
+		{
+			name: "status code changed",
+			mutate: func(doc map[string]any) {
+				responses := node(doc, "paths", "/items", "post", "responses")
+				responses["201"] = responses["200"]
+				delete(responses, "200")
+			},
+			breaking:    []string{"response-status-removed response.200"},
+			nonBreaking: []string{"response-status-added response.201"},
+		},
+		{
+			name: "field removed from the shared model",
+			mutate: func(doc map[string]any) {
+				item := node(doc, "components", "schemas", "Item")
+				delete(node(item, "properties"), "price")
+				item["required"] = []any{"name"}
+			},
+			// Breaking where clients read it, harmless where they send it.
+			breaking:    []string{"property-removed response.200.price", "property-removed response.200[].price"},
+			nonBreaking: []string{"property-removed request.body.price"},
+		},
+		{
+			name: "optional field added",
+			mutate: func(doc map[string]any) {
+				node(doc, "components", "schemas", "Item", "properties")["tags"] = map[string]any{
+					"anyOf": []any{map[string]any{"type": "array", "items": map[string]any{"type": "string"}}, map[string]any{"type": "null"}},
+				}
+			},
+			nonBreaking: []string{"property-added request.body.tags", "property-added response.200.tags", "property-added response.200[].tags"},
+		},
+		{
+			name: "number narrowed to integer",
+			mutate: func(doc map[string]any) {
+				node(doc, "components", "schemas", "Item", "properties")["price"] = map[string]any{"type": "integer"}
+			},
+			// integer prices are still numbers for readers.
+			breaking:    []string{"type-narrowed request.body.price"},
+			nonBreaking: []string{"type-widened response.200.price", "type-widened response.200[].price"},
+		},
+		{
+			name: "response field made optional",
+			mutate: func(doc map[string]any) {
+				node(doc, "components", "schemas", "Item")["required"] = []any{"price"}
+			},
+			breaking: []string{"property-now-optional response.200.name", "property-now-optional response.200[].name"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			changes, err := diffOpenAPI([]byte(baseSpec), edit(t, c.mutate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(c.breaking)
+			sort.Strings(c.nonBreaking)
+			if got := kinds(changes, true); strings.Join(got, ",") != strings.Join(c.breaking, ",") {
+				t.Errorf("breaking: got %v, want %v", got, c.breaking)
+			}
+			if got := kinds(changes, false); strings.Join(got, ",") != strings.Join(c.nonBreaking, ",") {
+				t.Errorf("non-breaking: got %v, want %v", got, c.nonBreaking)
+			}
+		})
+	}
+}
+
+func TestIsMajorBump(t *testing.T) {
+	cases := []struct {
+		baseline, version string
+		want              bool
+	}{
+		{"1.2.0", "2.0.0", true},
+		{"1.2.0", "1.3.0", false},
+		{"1.2.0", "1.2.0", false},
+		{"0.1.4", "0.2.0", true},
+		{"0.1.4", "0.1.5", false},
+		{"v1.0.0", "2.0.0-rc1", true},
+		{"", "2.0.0", false},
+	}
+	for _, c := range cases {
+		if got := isMajorBump(c.baseline, c.version); got != c.want {
+			t.Errorf("%s -> %s: got %v, want %v", c.baseline, c.version, got, c.want)
+		}
+	}
+}
+
+// TestCheckAPICompatibility: a removed path against the released spec is
+// blocking at the same major version, allowed after a major bump, and
+// written to the report either way.
+func TestCheckAPICompatibility(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService()
+	svc.Wool = wool.Get(ctx).In("api-test")
+	svc.Location = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(svc.Location, "openapi"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(svc.Location, apiReleasedPath), []byte(baseSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	current := edit(t, func(doc map[string]any) { delete(node(doc, "paths"), "/version") })
+	if err := os.WriteFile(filepath.Join(svc.Location, standards.OpenAPIPath), current, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for version, blocking := range map[string]bool{"1.3.0": true, "2.0.0": false} {
+		svc.Base.Service = &resources.Service{Version: version}
+		report, err := svc.CheckAPICompatibility(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Baseline != "released" || report.BaselineVersion != "1.2.0" {
+			t.Errorf("%s: unexpected baseline %s@%s", version, report.Baseline, report.BaselineVersion)
+		}
+		if report.Blocking() != blocking {
+			t.Errorf("%s: blocking=%v, want %v", version, report.Blocking(), blocking)
+		}
+
+		var written APIReport
+		content, err := os.ReadFile(filepath.Join(svc.Location, apiReportPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(content, &written); err != nil {
+			t.Fatal(err)
+		}
+		if len(written.Breaking) != 1 || written.Breaking[0].Kind != "path-removed" || written.Version != version {
+			t.Errorf("%s: unexpected report %s", version, content)
+		}
+	}
+}
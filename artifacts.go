@@ -0,0 +1,218 @@
+package main
+
+// artifacts.go — what Init leaves behind, and how Destroy removes it.
+//
+// CreateRunnerEnvironment records every resource it creates (the runtime
+// container, cache and venv directories, the flake it provisions) in a
+// manifest persisted under .cache. Destroy may run in a fresh agent that
+// never went through Init, so it reads the manifest instead of re-deriving
+// names from the current settings, tears down exactly those resources and
+// reports each one. Without a manifest (services initialized before it
+// existed) Destroy falls back to what CreateRunnerEnvironment would create.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codefly-dev/core/resources"
+	dockerrun "github.com/codefly-dev/core/runners/dockerrun"
+	"github.com/codefly-dev/core/wool"
+)
+
+// artifactManifestPath is relative to the service location.
+const artifactManifestPath = ".cache/runtime-artifacts.json"
+
+// Artifact kinds.
+const (
+	artifactContainer = "container"
+	artifactDirectory = "directory"
+	artifactFile      = "file"
+)
+
+// artifact is one resource created for the service.
+type artifact struct {
+	Kind string `json:"kind"`
+	// Path of a directory or file.
+	Path string `json:"path,omitempty"`
+	// Image, Root and Name identify a container as NewDockerEnvironment
+	// created it.
+	Image string `json:"image,omitempty"`
+	Root  string `json:"root,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
+func (a artifact) String() string {
+	if a.Kind == artifactContainer {
+		return fmt.Sprintf("container %s (%s)", a.Name, a.Image)
+	}
+	return fmt.Sprintf("%s %s", a.Kind, a.Path)
+}
+
+// artifactManifest is the persisted list, in creation order.
+type artifactManifest struct {
+	path      string
+	Artifacts []artifact `json:"artifacts"`
+}
+
+// loadArtifactManifest reads the manifest at path; a missing one is empty.
+func loadArtifactManifest(path string) (*artifactManifest, error) {
+	m := &artifactManifest{path: path}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("corrupted artifact manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// record adds a (once) and persists the manifest.
+func (m *artifactManifest) record(a artifact) error {
+	for _, existing := range m.Artifacts {
+		if existing == a {
+			return nil
+		}
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	return m.save()
+}
+
+func (m *artifactManifest) save() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, content, 0o644)
+}
+
+// recordArtifacts adds to the manifest. A manifest that cannot be written
+// only degrades Destroy to its fallback, so this warns instead of failing.
+func (s *Runtime) recordArtifacts(artifacts ...artifact) {
+	m, err := loadArtifactManifest(s.Local(artifactManifestPath))
+	if err == nil {
+		for _, a := range artifacts {
+			if err = m.record(a); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		s.Wool.Warn("cannot record runtime artifacts", wool.ErrField(err))
+	}
+}
+
+// fallbackArtifacts is what CreateRunnerEnvironment creates in the current
+// mode, for a service without a manifest.
+func (s *Runtime) fallbackArtifacts() ([]artifact, error) {
+	var artifacts []artifact
+	switch {
+	case s.Base.Runtime.IsContainerRuntime():
+		image, err := s.resolveRuntimeImage()
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts,
+			artifact{Kind: artifactContainer, Image: image.FullName(), Root: s.Identity.WorkspacePath, Name: s.UniqueWithWorkspace()},
+			artifact{Kind: artifactDirectory, Path: s.DockerEnvPath()},
+			artifact{Kind: artifactDirectory, Path: s.Local(".cache/container")})
+	case s.Base.Runtime.IsNixRuntime():
+		// Only a flake the agent wrote is ours to remove.
+		flake := filepath.Join(s.Service.SourceLocation, "flake.nix")
+		if isManagedNixFlake(flake) {
+			artifacts = append(artifacts,
+				artifact{Kind: artifactFile, Path: flake},
+				artifact{Kind: artifactFile, Path: filepath.Join(s.Service.SourceLocation, "flake.lock")})
+		}
+		artifacts = append(artifacts, artifact{Kind: artifactDirectory, Path: s.Local(".cache/nix")})
+	default:
+		artifacts = append(artifacts, artifact{Kind: artifactDirectory, Path: s.Local(".cache/local")})
+	}
+	return artifacts, nil
+}
+
+// destroyArtifacts removes every recorded artifact, containers first, and
+// returns what it removed. Artifacts that could not be removed stay in the
+// manifest for the next Destroy.
+func (s *Runtime) destroyArtifacts(ctx context.Context) ([]string, error) {
+	manifest, err := loadArtifactManifest(s.Local(artifactManifestPath))
+	if err != nil {
+		return nil, err
+	}
+	artifacts := manifest.Artifacts
+	if len(artifacts) == 0 {
+		if artifacts, err = s.fallbackArtifacts(); err != nil {
+			return nil, err
+		}
+	}
+
+	var removed []string
+	var failed []artifact
+	var errs []error
+	for _, kind := range []string{artifactContainer, artifactFile, artifactDirectory} {
+		for _, a := range artifacts {
+			if a.Kind != kind {
+				continue
+			}
+			existed, err := s.removeArtifact(ctx, a)
+			if err != nil {
+				failed = append(failed, a)
+				errs = append(errs, fmt.Errorf("%s: %w", a, err))
+				continue
+			}
+			if existed {
+				removed = append(removed, a.String())
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		manifest.Artifacts = failed
+		if err := manifest.save(); err != nil {
+			errs = append(errs, err)
+		}
+		return removed, errors.Join(errs...)
+	}
+	if err := os.Remove(manifest.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return removed, err
+	}
+	// .cache itself goes once nothing else lives there.
+	_ = os.Remove(filepath.Dir(manifest.path))
+	return removed, nil
+}
+
+// removeArtifact removes a, reporting whether there was anything to remove.
+func (s *Runtime) removeArtifact(ctx context.Context, a artifact) (bool, error) {
+	switch a.Kind {
+	case artifactContainer:
+		image, err := resources.ParsePinnedImage(a.Image)
+		if err != nil {
+			return false, err
+		}
+		dockerEnv, err := dockerrun.NewDockerEnvironment(ctx, image, a.Root, a.Name)
+		if err != nil {
+			return false, err
+		}
+		if err := dockerEnv.Shutdown(ctx); err != nil {
+			return false, err
+		}
+		return true, nil
+	case artifactDirectory, artifactFile:
+		if _, err := os.Lstat(a.Path); errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return true, os.RemoveAll(a.Path)
+	default:
+		return false, fmt.Errorf("unknown artifact kind %q", a.Kind)
+	}
+}
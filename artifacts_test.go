@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+func newArtifactsRuntime(t *testing.T) *Runtime {
+	t.Helper()
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(context.Background()).In("artifacts-test")
+	rt.Location = t.TempDir()
+	return rt
+}
+
+func TestArtifactManifest(t *testing.T) {
+	rt := newArtifactsRuntime(t)
+	container := artifact{Kind: artifactContainer, Image: "codeflydev/python:0.0.1", Root: "/workspace", Name: "ws-mod-svc"}
+	cache := artifact{Kind: artifactDirectory, Path: rt.Local(".cache/container")}
+	rt.recordArtifacts(container, cache)
+	rt.recordArtifacts(cache) // recorded again by a second Init
+
+	m, err := loadArtifactManifest(rt.Local(artifactManifestPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Artifacts) != 2 || m.Artifacts[0] != container || m.Artifacts[1] != cache {
+		t.Errorf("unexpected manifest %+v", m.Artifacts)
+	}
+}
+
+// TestDestroyArtifacts: Destroy removes what was recorded, whatever the
+// current settings say, reports it, and leaves no manifest behind.
+func TestDestroyArtifacts(t *testing.T) {
+	ctx := context.Background()
+	rt := newArtifactsRuntime(t)
+	source := filepath.Join(rt.Location, "code")
+	if err := os.MkdirAll(source, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	venv := rt.Local(".cache/container/.venv")
+	if err := os.MkdirAll(filepath.Join(venv, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureNixFlake(source, "python312", nil); err != nil {
+		t.Fatal(err)
+	}
+	rt.recordArtifacts(
+		artifact{Kind: artifactDirectory, Path: venv},
+		artifact{Kind: artifactDirectory, Path: rt.Local(".cache/container")},
+		artifact{Kind: artifactFile, Path: filepath.Join(source, "flake.nix")},
+		artifact{Kind: artifactFile, Path: filepath.Join(source, "flake.lock")},
+		artifact{Kind: artifactDirectory, Path: rt.Local(".cache/nix")}) // never created
+
+	removed, err := rt.destroyArtifacts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 4 || !strings.HasPrefix(removed[0], "file ") {
+		t.Errorf("unexpected report %v", removed)
+	}
+	for _, path := range []string{venv, filepath.Join(source, "flake.nix"), rt.Local(".cache")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists", path)
+		}
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("source directory removed: %v", err)
+	}
+}
+
+// TestDestroyWithoutManifest: a service initialized before the manifest
+// existed still loses its cache.
+func TestDestroyWithoutManifest(t *testing.T) {
+	ctx := context.Background()
+	rt := newArtifactsRuntime(t)
+	if err := os.MkdirAll(rt.Local(".cache/local"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := rt.destroyArtifacts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "directory "+rt.Local(".cache/local") {
+		t.Errorf("unexpected report %v", removed)
+	}
+}
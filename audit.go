@@ -0,0 +1,313 @@
+package main
+
+// audit.go — offline vulnerability audit of code/uv.lock.
+//
+// The inherited Audit exports the lock and runs pip-audit, which queries
+// PyPI's advisory service. With a local advisory database (osv.go), Audit
+// reads uv.lock itself and matches every locked registry package against
+// the database, with no network. The database is audit.database, or
+// advisories/PyPI.zip in the service when it exists; update-advisories
+// downloads a fresh OSV export there. With audit.gate-build, Build fails
+// on findings at or above audit.fail-on (default high).
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	// defaultAdvisoryDatabase is used, relative to the service, when it
+	// exists and audit.database is unset.
+	defaultAdvisoryDatabase = "advisories/PyPI.zip"
+	// osvExportURL is the PyPI export of the OSV database.
+	osvExportURL = "https://osv-vulnerabilities.storage.googleapis.com/PyPI/all.zip"
+	// auditTool identifies the offline audit in responses.
+	auditTool = "uv.lock+osv-offline"
+)
+
+// Audit configures the offline dependency audit (audit setting).
+//
+//	audit:
+//	  database: advisories/PyPI.zip   # optional, relative to the service
+//	  gate-build: true                # Build fails on findings
+//	  fail-on: critical               # optional, default: high
+type Audit struct {
+	Database  string `yaml:"database"`
+	GateBuild bool   `yaml:"gate-build"`
+	FailOn    string `yaml:"fail-on"`
+}
+
+// Validate rejects an unknown fail-on severity.
+func (a *Audit) Validate() error {
+	_, err := a.threshold()
+	return err
+}
+
+// threshold is the lowest severity failing Build.
+func (a *Audit) threshold() (builderv0.AuditFinding_Severity, error) {
+	if a == nil || a.FailOn == "" {
+		return builderv0.AuditFinding_HIGH, nil
+	}
+	severity, err := parseAuditSeverity(a.FailOn)
+	if err != nil {
+		return severity, fmt.Errorf("audit.fail-on: %w", err)
+	}
+	return severity, nil
+}
+
+// advisoryDatabase is the database location, and whether the offline
+// audit applies: the configured one always does, the default one when it
+// exists.
+func (s *Service) advisoryDatabase() (string, bool) {
+	if s.Settings.Audit != nil && s.Settings.Audit.Database != "" {
+		if filepath.IsAbs(s.Settings.Audit.Database) {
+			return s.Settings.Audit.Database, true
+		}
+		return s.Local("%s", s.Settings.Audit.Database), true
+	}
+	database := s.Local(defaultAdvisoryDatabase)
+	_, err := os.Stat(database)
+	return database, err == nil
+}
+
+// lockedPackage is a package pinned by uv.lock.
+type lockedPackage struct {
+	Name    string
+	Version string
+	// Source is the inline table of its source: registry, editable,
+	// virtual, git, path, url.
+	Source string
+}
+
+// Registry reports a package resolved from a package index, which the
+// advisories are about (the project itself is editable or virtual).
+func (p lockedPackage) Registry() bool {
+	return strings.Contains(p.Source, "registry")
+}
+
+// parseUVLock reads the [[package]] tables of uv.lock. The file is TOML
+// written by uv: each table key is on its own line, as `key = value`.
+func parseUVLock(r io.Reader) ([]lockedPackage, error) {
+	var packages []lockedPackage
+	var current *lockedPackage
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			if strings.TrimSpace(line) == "[[package]]" {
+				packages = append(packages, lockedPackage{})
+				current = &packages[len(packages)-1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "name":
+			current.Name = strings.Trim(value, `"`)
+		case "version":
+			current.Version = strings.Trim(value, `"`)
+		case "source":
+			current.Source = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range packages {
+		if p.Name == "" {
+			return nil, fmt.Errorf("uv.lock has a package without a name")
+		}
+	}
+	return packages, nil
+}
+
+// AuditLock matches the packages of uv.lock against the database.
+func (s *Service) AuditLock(database string) ([]*builderv0.AuditFinding, error) {
+	lock, err := os.Open(path.Join(s.Service.SourceLocation, "uv.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("the offline audit reads uv.lock (run uv lock): %w", err)
+	}
+	defer lock.Close()
+	packages, err := parseUVLock(lock)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot read uv.lock")
+	}
+	advisories, err := loadAdvisories(database)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the advisory database %s (run update-advisories): %w", database, err)
+	}
+	s.Wool.Debug("auditing uv.lock", wool.Field("packages", len(packages)), wool.Field("advisories", advisories.Size()))
+	findings := []*builderv0.AuditFinding{}
+	for _, p := range packages {
+		if p.Registry() {
+			findings = append(findings, advisories.Findings(p.Name, p.Version)...)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Package < findings[j].Package
+	})
+	return findings, nil
+}
+
+// Audit is offline when there is an advisory database, else the inherited
+// pip-audit scan. Outdated dependencies are not reported offline.
+func (s *Builder) Audit(ctx context.Context, req *builderv0.AuditRequest) (*builderv0.AuditResponse, error) {
+	database, ok := s.FastAPI.advisoryDatabase()
+	if !ok {
+		return s.Builder.Audit(ctx, req)
+	}
+	defer s.Wool.Catch()
+	findings, err := s.FastAPI.AuditLock(database)
+	if err != nil {
+		return s.Base.Builder.AuditError(err)
+	}
+	resp, err := s.Base.Builder.AuditResponse(req, findings, nil, auditTool, "PYTHON")
+	if err == nil && resp.State.Message == "" {
+		resp.State.Message = auditSummary(findings)
+	}
+	return resp, err
+}
+
+// checkVulnerabilities is the Build gate (audit.gate-build): it logs the
+// findings and fails on those at or above audit.fail-on.
+func (s *Builder) checkVulnerabilities() error {
+	settings := s.FastAPI.Settings.Audit
+	if settings == nil || !settings.GateBuild {
+		return nil
+	}
+	threshold, err := settings.threshold()
+	if err != nil {
+		return err
+	}
+	database, ok := s.FastAPI.advisoryDatabase()
+	if !ok {
+		return fmt.Errorf("audit.gate-build needs an advisory database: run update-advisories or set audit.database")
+	}
+	findings, err := s.FastAPI.AuditLock(database)
+	if err != nil {
+		return err
+	}
+	blocking := 0
+	for _, finding := range findings {
+		s.Wool.Warn("vulnerable dependency", wool.Field("finding", formatFinding(finding)))
+		if finding.Severity >= threshold {
+			blocking++
+		}
+	}
+	if blocking > 0 {
+		return fmt.Errorf("%d vulnerable dependency finding(s) at or above %s in uv.lock (audit.gate-build)", blocking, threshold)
+	}
+	return nil
+}
+
+// formatFinding is "package version: id (severity), fixed in version".
+func formatFinding(f *builderv0.AuditFinding) string {
+	line := fmt.Sprintf("%s %s: %s (%s)", f.Package, f.CurrentVersion, f.Id, f.Severity)
+	if f.FixedVersion != "" {
+		line += ", fixed in " + f.FixedVersion
+	} else {
+		line += ", no fix"
+	}
+	return line
+}
+
+func (s *Builder) registerAuditCommands() {
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "update-advisories",
+		Description: "Download the PyPI export of the OSV database (or the given URL) to the advisory database used by the offline audit.",
+		Usage:       "update-advisories [url]",
+		Tags:        []string{"audit", "security"},
+	}, s.cmdUpdateAdvisories)
+}
+
+func (s *Builder) cmdUpdateAdvisories(ctx context.Context, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("usage: update-advisories [url]")
+	}
+	url := osvExportURL
+	if len(args) == 1 {
+		url = args[0]
+	}
+	database, _ := s.FastAPI.advisoryDatabase()
+	if !strings.HasSuffix(database, ".zip") {
+		return "", fmt.Errorf("update-advisories writes an OSV zip export: %s is not a .zip", database)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot download %s: %s", url, resp.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(database), 0o755); err != nil {
+		return "", err
+	}
+	// Replace the database only with a complete, readable export.
+	staged, err := os.CreateTemp(filepath.Dir(database), ".advisories-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(staged.Name())
+	_, err = io.Copy(staged, resp.Body)
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot download %s: %w", url, err)
+	}
+	advisories, err := loadAdvisories(staged.Name())
+	if err != nil {
+		return "", fmt.Errorf("%s is not an OSV export: %w", url, err)
+	}
+	if err := os.Rename(staged.Name(), database); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d PyPI advisories in %s", advisories.Size(), database), nil
+}
+
+// auditSummary is a one-line count per severity, most severe first.
+func auditSummary(findings []*builderv0.AuditFinding) string {
+	if len(findings) == 0 {
+		return "no known vulnerabilities"
+	}
+	counts := map[builderv0.AuditFinding_Severity]int{}
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	var parts bytes.Buffer
+	for severity := builderv0.AuditFinding_CRITICAL; severity >= builderv0.AuditFinding_UNKNOWN; severity-- {
+		if counts[severity] > 0 {
+			if parts.Len() > 0 {
+				parts.WriteString(", ")
+			}
+			fmt.Fprintf(&parts, "%d %s", counts[severity], strings.ToLower(severity.String()))
+		}
+	}
+	return parts.String()
+}
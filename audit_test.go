@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+)
+
+const uvLockSample = `version = 1
+requires-python = ">=3.12"
+
+[[package]]
+name = "api"
+version = "0.1.0"
+source = { editable = "." }
+dependencies = [
+    { name = "fastapi" },
+]
+
+[package.metadata]
+requires-dist = [{ name = "fastapi", specifier = ">=0.100" }]
+
+[[package]]
+name = "fastapi"
+version = "0.109.0"
+source = { registry = "https://pypi.org/simple" }
+sdist = { url = "https://files.pythonhosted.org/fastapi-0.109.0.tar.gz", hash = "sha256:0" }
+
+[[package]]
+name = "python-multipart"
+version = "0.0.6"
+source = { registry = "https://pypi.org/simple" }
+
+[[package]]
+name = "pydantic"
+version = "2.9.2"
+source = { registry = "https://pypi.org/simple" }
+`
+
+func TestParseUVLock(t *testing.T) {
+	packages, err := parseUVLock(strings.NewReader(uvLockSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 4 {
+		t.Fatalf("got %v", packages)
+	}
+	if p := packages[0]; p.Name != "api" || p.Version != "0.1.0" || p.Registry() {
+		t.Errorf("got %v", p)
+	}
+	if p := packages[1]; p.Name != "fastapi" || p.Version != "0.109.0" || !p.Registry() {
+		t.Errorf("got %v", p)
+	}
+	if _, err := parseUVLock(strings.NewReader("[[package]]\nversion = \"1.0\"\n")); err == nil {
+		t.Error("package without a name accepted")
+	}
+}
+
+// newTestAuditBuilder is a Builder over a service with the sample lock
+// and, under advisories/, the sample database.
+func newTestAuditBuilder(t *testing.T) *Builder {
+	tooling := newTestTooling(t)
+	code := tooling.FastAPI.Service.SourceLocation
+	if err := os.WriteFile(filepath.Join(code, "uv.lock"), []byte(uvLockSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(filepath.Dir(code), "advisories")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sample.json"), []byte(advisoriesSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tooling.FastAPI.Location = filepath.Dir(code)
+	tooling.FastAPI.Settings.Audit = &Audit{Database: "advisories"}
+	return NewBuilder(tooling.FastAPI)
+}
+
+func TestBuilderAudit(t *testing.T) {
+	builder := newTestAuditBuilder(t)
+	resp, err := builder.Audit(context.Background(), &builderv0.AuditRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Tool != auditTool || len(resp.Findings) != 2 {
+		t.Fatalf("got %v", resp)
+	}
+	if f := resp.Findings[0]; f.Package != "fastapi" || f.CurrentVersion != "0.109.0" || f.FixedVersion != "0.109.1" {
+		t.Errorf("got %v", f)
+	}
+	if resp.Findings[1].Package != "python-multipart" {
+		t.Errorf("got %v", resp.Findings[1])
+	}
+
+	// Not gated by default; at high, the moderate finding does not block.
+	if err := builder.checkVulnerabilities(); err != nil {
+		t.Error(err)
+	}
+	builder.FastAPI.Settings.Audit.GateBuild = true
+	if err := builder.checkVulnerabilities(); err == nil || !strings.HasPrefix(err.Error(), "1 vulnerable dependency finding(s) at or above HIGH") {
+		t.Errorf("got %v", err)
+	}
+	builder.FastAPI.Settings.Audit.FailOn = "critical"
+	if err := builder.checkVulnerabilities(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Settings{Audit: &Audit{FailOn: "severe"}}).Validate(); err == nil {
+		t.Error("unknown severity accepted")
+	}
+}
+
+func TestAuditSummary(t *testing.T) {
+	findings := []*builderv0.AuditFinding{
+		{Severity: builderv0.AuditFinding_MEDIUM},
+		{Severity: builderv0.AuditFinding_CRITICAL},
+		{Severity: builderv0.AuditFinding_MEDIUM},
+	}
+	if got := auditSummary(findings); got != "1 critical, 2 medium" {
+		t.Errorf("got %q", got)
+	}
+	if got := formatFinding(&builderv0.AuditFinding{Package: "fastapi", CurrentVersion: "0.109.0", Id: "GHSA-qf9m-vfgh-m389", Severity: builderv0.AuditFinding_HIGH}); got != "fastapi 0.109.0: GHSA-qf9m-vfgh-m389 (HIGH), no fix" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestUpdateAdvisories(t *testing.T) {
+	export, err := os.ReadFile(writeAdvisoriesZip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/PyPI/all.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(export)
+	}))
+	defer server.Close()
+
+	builder := newTestAuditBuilder(t)
+	builder.FastAPI.Settings.Audit = nil
+	if _, ok := builder.FastAPI.advisoryDatabase(); ok {
+		t.Fatal("the default database does not exist yet")
+	}
+	out, err := builder.cmdUpdateAdvisories(context.Background(), []string{server.URL + "/PyPI/all.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, ok := builder.FastAPI.advisoryDatabase()
+	if !ok || out != "1 PyPI advisories in "+database {
+		t.Errorf("got %q", out)
+	}
+
+	// A failed download keeps the database.
+	if _, err := builder.cmdUpdateAdvisories(context.Background(), []string{server.URL + "/missing.zip"}); err == nil {
+		t.Error("404 accepted")
+	}
+	resp, err := builder.Audit(context.Background(), &builderv0.AuditRequest{})
+	if err != nil || len(resp.Findings) != 1 || resp.Findings[0].Id != "PYSEC-2024-38" {
+		t.Errorf("got %v, %v", resp, err)
+	}
+}
@@ -3,3 +3,7 @@ from pydantic import BaseModel
 
 class Version(BaseModel):
     version: str
+
+
+class Health(BaseModel):
+    status: str
@@ -1,10 +1,16 @@
 from fastapi import APIRouter
 
 from src.admin.version import get_version
-from src.admin.models import Version
+from src.admin.models import Health, Version
 
 router = APIRouter()
 
 @router.get("/version", response_model=Version)
 async def version():
     return get_version()
+
+# Readiness probe used by the codefly agent before it reports the service as
+# started. Keep it cheap: it is polled while the app boots.
+@router.get("/health", response_model=Health)
+async def health():
+    return Health(status="ok")
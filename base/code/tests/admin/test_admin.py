@@ -20,3 +20,11 @@ async def test_version():
     assert response.status_code == 200
 
     assert Version.model_validate(response.json()) == get_version()
+
+
+@pytest.mark.asyncio
+async def test_health():
+    async with AsyncClient(transport=ASGITransport(app=app), base_url="http://test") as ac:
+        response = await ac.get("/health")
+    assert response.status_code == 200
+    assert response.json() == {"status": "ok"}
@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"embed"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/codefly-dev/core/agents/communicate"
 	dockerhelpers "github.com/codefly-dev/core/agents/helpers/docker"
@@ -37,8 +40,13 @@ import (
 // Inherited: Init.
 // Overridden: Load (fastapi puts source under ./code, discovers REST
 // endpoint), Update (applies builder templates), Sync (gRPC codegen for
-// declared dependencies), Build (custom DockerTemplating + docker build),
-// Deploy (k8s), Create (two-question Communicate + REST endpoint).
+// declared dependencies), Build (custom DockerTemplating + docker build,
+// gated on API compatibility, type errors, vulnerabilities and the
+// license policy), Audit (offline with an advisory database), Deploy
+// (k8s), Create (two-question Communicate + REST endpoint).
+// Commands: add-plugin, remove-plugin (plugins.go), contribute-plugin,
+// withdraw-plugin (contributions.go), update-advisories (audit.go),
+// licenses (licenses.go).
 type Builder struct {
 	*pythonbuilder.Builder
 
@@ -70,6 +78,10 @@ func (s *Builder) Load(ctx context.Context, req *builderv0.LoadRequest) (*builde
 	// Override SourceLocation: fastapi source lives in ./code, not the
 	// service root (generic's default).
 	s.Service.SourceLocation = s.Local("code")
+	s.registerPluginCommands()
+	s.registerContributionCommands()
+	s.registerAuditCommands()
+	s.registerLicenseCommands()
 
 	// In creation mode, regenerate GETTING_STARTED from the fastapi template
 	// (generic has no templates).
@@ -86,6 +98,14 @@ func (s *Builder) Load(ctx context.Context, req *builderv0.LoadRequest) (*builde
 	if err != nil {
 		return s.Base.Builder.LoadError(err)
 	}
+	s.FastAPI.MetricsEndpoint = findMetricsEndpoint(s.Endpoints)
+
+	if err := s.FastAPI.Settings.Validate(); err != nil {
+		return s.Base.Builder.LoadErrorf(err, "invalid settings in service.codefly.yaml")
+	}
+	if s.FastAPI.Python, err = s.FastAPI.Settings.ResolvePython(); err != nil {
+		return s.Base.Builder.LoadErrorf(err, "invalid python-version in service.codefly.yaml")
+	}
 
 	return s.Base.Builder.LoadResponse()
 }
@@ -138,15 +158,32 @@ type Env struct {
 	Value string
 }
 
+// dockerQuoter escapes what Docker reads in a double-quoted word.
+var dockerQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// Quoted is Value as a double-quoted Dockerfile word: empty, spaces,
+// quotes and $ are kept as they are. build-env values have no newline
+// (validateSystemPackages).
+func (e Env) Quoted() string {
+	return `"` + dockerQuoter.Replace(e.Value) + `"`
+}
+
 type DockerTemplating struct {
 	Builder         string
 	Components      []string
 	RuntimePackages []string
 	Envs            []Env
+
+	// Command is the JSON-encoded CMD, built by Settings.ServerCommand so
+	// the image serves with the same process model as `codefly run`.
+	Command string
+	// Port is the port Command listens on (Service.ContainerPort).
+	Port uint16
 }
 
 // Build produces the service Docker image. Generic is a no-op; fastapi
-// renders a Dockerfile and runs docker build.
+// renders a Dockerfile, runs docker build and writes the image SBOM
+// (sbom.go), referenced at the end of the response message.
 func (s *Builder) Build(ctx context.Context, req *builderv0.BuildRequest) (*builderv0.BuildResponse, error) {
 	defer s.Wool.Catch()
 	dockerRequest, err := s.Base.Builder.DockerBuildRequest(ctx, req)
@@ -158,9 +195,44 @@ func (s *Builder) Build(ctx context.Context, req *builderv0.BuildRequest) (*buil
 	s.Wool.Debug("building docker image", wool.Field("image", image.FullName()))
 	ctx = s.Wool.Inject(ctx)
 
+	if err := s.FastAPI.Settings.Validate(); err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+	if err := s.checkAPI(ctx); err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+	if err := s.checkTypes(ctx); err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+	if err := s.checkVulnerabilities(); err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+	if err := s.checkLicenses(ctx); err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+
+	mode, err := s.FastAPI.Settings.ImageMode()
+	if err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+	port := s.FastAPI.ContainerPort()
+	command, err := json.Marshal(s.FastAPI.Settings.ServerCommand(mode, "0.0.0.0", port))
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot encode image command")
+	}
+
+	inventory, err := s.ImageSBOM(ctx, image, s.FastAPI.Python.Image)
+	if err != nil {
+		return s.Base.Builder.BuildError(err)
+	}
+
 	docker := DockerTemplating{
-		Builder:    runtimeImage.FullName(),
-		Components: requirements.All(),
+		Builder:         s.FastAPI.Python.Image.FullName(),
+		Components:      requirements.All(),
+		RuntimePackages: s.FastAPI.Settings.apkPackages(),
+		Envs:            s.FastAPI.Settings.buildEnvs(),
+		Command:         string(command),
+		Port:            port,
 	}
 
 	if err := shared.DeleteFile(ctx, s.Local("builder/Dockerfile")); err != nil {
@@ -183,8 +255,36 @@ func (s *Builder) Build(ctx context.Context, req *builderv0.BuildRequest) (*buil
 		return nil, s.Wool.Wrapf(err, "cannot build image")
 	}
 
+	if mode, _ := s.FastAPI.Settings.CompatibilityMode(); mode != APICompatibilityOff {
+		if err := s.FastAPI.ReleaseAPI(ctx); err != nil {
+			s.Wool.Warn("cannot record released openapi spec", wool.ErrField(err))
+		}
+	}
+
+	sbomFile, err := s.writeSBOM(inventory)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot write sbom")
+	}
+
 	s.Base.Builder.WithDockerImages(image)
-	return s.Base.Builder.BuildResponse()
+	resp, err := s.Base.Builder.BuildResponse()
+	if err == nil {
+		noteSBOM(resp, sbomFile, inventory.SHA256)
+	}
+	return resp, err
+}
+
+// noteSBOM appends the SBOM reference to the message of a successful
+// build: BuildResponse has no field for it.
+func noteSBOM(resp *builderv0.BuildResponse, file string, sha256 string) {
+	if resp.GetState().GetState() != builderv0.BuildStatus_SUCCESS {
+		return
+	}
+	note := fmt.Sprintf("sbom: %s (sha256 %s)", file, sha256)
+	if resp.State.Message != "" {
+		note = resp.State.Message + "; " + note
+	}
+	resp.State.Message = note
 }
 
 // Upgrade bumps Python dependencies in requirements.txt (pip list
@@ -206,7 +306,19 @@ func (s *Builder) Upgrade(ctx context.Context, req *builderv0.UpgradeRequest) (*
 }
 
 // Parameters is the template parameter set for the k8s deployment.
-type Parameters struct{}
+type Parameters struct {
+	// Port is the container port (Service.ContainerPort); ServicePort is
+	// the Service's, as the REST endpoint's network mapping has it.
+	Port        uint16
+	ServicePort uint16
+	// Metrics adds the metrics port and the prometheus.io annotations;
+	// MetricsPort is the Service's, as the metrics endpoint's network
+	// mapping has it.
+	Metrics     bool
+	MetricsPort uint16
+	// Migrations adds the Job applying the plugins' migrations.
+	Migrations *MigrationJob
+}
 
 // Deploy renders and applies k8s manifests.
 func (s *Builder) Deploy(ctx context.Context, req *builderv0.DeploymentRequest) (*builderv0.DeploymentResponse, error) {
@@ -219,15 +331,42 @@ func (s *Builder) Deploy(ctx context.Context, req *builderv0.DeploymentRequest)
 			OwnConfiguration:         true,
 			DependencyConfigurations: true,
 		},
-		Parameters: Parameters{},
+		Parameters: Parameters{
+			Port:        s.FastAPI.ContainerPort(),
+			ServicePort: s.servicePort(ctx, req),
+			Metrics:     s.FastAPI.Settings.Metrics,
+			MetricsPort: s.mappedPort(ctx, req, s.FastAPI.MetricsEndpoint),
+			Migrations:  migrationJob(s.FastAPI.Settings, s.Identity),
+		},
 	})
 }
 
-// Options returns the two-question set shown during `codefly add service`.
+// servicePort is the port of the REST endpoint's network mapping in the
+// cluster, which is what other services dial; without one, the container
+// port.
+func (s *Builder) servicePort(ctx context.Context, req *builderv0.DeploymentRequest) uint16 {
+	return s.mappedPort(ctx, req, s.FastAPI.RestEndpoint)
+}
+
+// mappedPort is the port of the endpoint's network mapping in the cluster;
+// without one, the container port, which serves every route of the app.
+func (s *Builder) mappedPort(ctx context.Context, req *builderv0.DeploymentRequest, endpoint *basev0.Endpoint) uint16 {
+	if endpoint == nil {
+		return s.FastAPI.ContainerPort()
+	}
+	instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, req.NetworkMappings, endpoint, resources.NewContainerNetworkAccess())
+	if err != nil || instance == nil || instance.Port == 0 {
+		return s.FastAPI.ContainerPort()
+	}
+	return uint16(instance.Port)
+}
+
+// Options returns the question set shown during `codefly add service`.
 func (s *Builder) Options() []*agentv0.Question {
 	return []*agentv0.Question{
 		communicate.NewConfirm(&agentv0.Message{Name: PublicEndpoint, Message: "Expose API as public", Description: "is that directly accessible from the internet?"}, true),
 		communicate.NewConfirm(&agentv0.Message{Name: HotReload, Message: "Code hot-reload (Recommended)?", Description: "codefly can restart your service when code changes are detected 🔎"}, true),
+		communicate.NewConfirm(&agentv0.Message{Name: Metrics, Message: "Expose Prometheus metrics?", Description: "serves /metrics as a separate endpoint, summarised during codefly run 📈"}, false),
 	}
 }
 
@@ -236,6 +375,14 @@ type CreateConfiguration struct {
 	*services.Information
 	Image *resources.DockerImage
 	Envs  []string
+	// Python is the release of python-version (flake.nix interpreter).
+	Python *PythonRelease
+	// Metrics scaffolds src/metrics.py, its /metrics route and
+	// prometheus-client.
+	Metrics bool
+	// Tracing adds the OpenTelemetry packages of src/telemetry.py, which
+	// is a no-op without them (tracing turned on later: uv add them).
+	Tracing bool
 }
 
 // Create applies factory templates, scaffolds src/tests dirs, and
@@ -254,10 +401,21 @@ func (s *Builder) Create(ctx context.Context, _ *builderv0.CreateRequest) (*buil
 		}
 	}
 
-	create := CreateConfiguration{Information: s.Information, Envs: []string{}}
+	python, err := s.FastAPI.Settings.ResolvePython()
+	if err != nil {
+		return s.Base.Builder.CreateError(err)
+	}
+	create := CreateConfiguration{Information: s.Information, Envs: []string{}, Python: python, Metrics: s.FastAPI.Settings.Metrics, Tracing: s.FastAPI.Settings.TracingEnabled()}
 	if err := s.Base.Templates(ctx, create, services.WithFactory(factoryFS)); err != nil {
 		return s.Base.Builder.CreateError(err)
 	}
+	if !create.Metrics {
+		// The factory renders every template: without metrics, nothing
+		// imports src/metrics.py, nor installs prometheus-client.
+		if err := os.Remove(s.Local("code/src/metrics.py")); err != nil {
+			return s.Base.Builder.CreateError(err)
+		}
+	}
 
 	// Scaffold package + tests dirs with empty __init__.py.
 	if _, err := shared.CheckDirectoryOrCreate(ctx, s.Local("code/src")); err != nil {
@@ -278,7 +436,8 @@ func (s *Builder) Create(ctx context.Context, _ *builderv0.CreateRequest) (*buil
 	return s.Base.Builder.CreateResponse(ctx, s.FastAPI.Settings)
 }
 
-// CreateEndpoints materializes the REST endpoint.
+// CreateEndpoints materializes the REST endpoint, and the metrics endpoint
+// when the metrics setting is on.
 //
 // openapi/api.swagger.json is generated at Runtime time by src/openapi.py
 // (uv run python src/openapi.py). At Create time it doesn't exist yet —
@@ -306,6 +465,14 @@ func (s *Builder) CreateEndpoints(ctx context.Context) error {
 	}
 	s.FastAPI.RestEndpoint = api
 	s.Endpoints = []*basev0.Endpoint{s.FastAPI.RestEndpoint}
+
+	if s.FastAPI.Settings.Metrics {
+		s.FastAPI.MetricsEndpoint, err = s.createMetricsEndpoint(ctx)
+		if err != nil {
+			return err
+		}
+		s.Endpoints = append(s.Endpoints, s.FastAPI.MetricsEndpoint)
+	}
 	return nil
 }
 
@@ -324,6 +491,9 @@ func (s *Builder) populateSettingsFromAnswers() error {
 	if s.FastAPI.Settings.PublicEndpoint, err = communicate.Confirm(s.answers, PublicEndpoint); err != nil {
 		return err
 	}
+	if s.FastAPI.Settings.Metrics, err = communicate.Confirm(s.answers, Metrics); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -336,6 +506,9 @@ func (s *Builder) populateSettingsFromDefaults() error {
 	if s.FastAPI.Settings.PublicEndpoint, err = communicate.GetDefaultConfirm(opts, PublicEndpoint); err != nil {
 		return err
 	}
+	if s.FastAPI.Settings.Metrics, err = communicate.GetDefaultConfirm(opts, Metrics); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -362,5 +535,8 @@ var factoryFS embed.FS
 //go:embed templates/builder
 var builderFS embed.FS
 
+//go:embed templates/plugin
+var pluginFS embed.FS
+
 //go:embed templates/deployment
 var deploymentFS embed.FS
@@ -0,0 +1,873 @@
+package main
+
+// contributions.go — FastAPI plugins contributed by other codefly agents.
+//
+// A module agent contributes a plugin by calling the plugin.contribute
+// tool of the agent's Toolbox with a bundle: the package files, the
+// registry entry (the Plugin its __init__.py binds), the Python
+// dependencies and the environment variables it reads. The contributor is
+// the agent principal of the call, as codefly's principal interceptor
+// stamps it (and the host's PDP authorizes the call), not a claim of the bundle. The contribute-plugin
+// and withdraw-plugin commands take the same bundle as JSON for local use:
+// without an agent principal, the agent they declare is recorded as
+// unverified, and cannot change a verified contribution.
+//
+// The bundle is validated (names, paths, sizes, Python syntax, the registry
+// entry bound at the top of __init__.py), its dependencies are added with
+// uv, then the package is swapped into src/plugins/<name> and registered. A
+// failing step undoes the previous ones: pyproject.toml, uv.lock, the
+// previous package and the registry are restored; withdrawing undoes its
+// registry edit the same way. Environment requirements are recorded with
+// the provenance and are required configuration of the service: Init
+// fails while a required variable is unset (pluginenv in runtime.go).
+//
+// Provenance — which agent and version contributed which files, with
+// their hashes — is kept in src/plugins/contributions.json, next to the
+// registry and versioned with the code. Only the contributing agent can
+// update or withdraw its plugin, and a plugin edited locally is not
+// overwritten unless the bundle says force.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	toolboxv0 "github.com/codefly-dev/core/generated/go/codefly/services/toolbox/v0"
+	"github.com/codefly-dev/core/policy"
+	"github.com/codefly-dev/core/resources"
+	runners "github.com/codefly-dev/core/runners/base"
+	coretoolbox "github.com/codefly-dev/core/toolbox"
+	"github.com/codefly-dev/core/toolbox/registry"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// contributionsFile is relative to the source location.
+const contributionsFile = "src/plugins/contributions.json"
+
+// Bundle limits: a plugin is source code, not data.
+const (
+	maxBundleFiles    = 200
+	maxBundleFileSize = 512 << 10
+	maxBundleSize     = 4 << 20
+)
+
+// bundleExtensions are the files a plugin package may carry: code, Alembic
+// migrations and their configuration, static descriptions.
+var bundleExtensions = []string{".py", ".pyi", ".ini", ".mako", ".sql", ".json", ".yaml", ".yml", ".toml", ".cfg", ".txt", ".md"}
+
+// dependencyPattern is a PEP 508 name, extras and version specifiers (no
+// URLs, no markers, nothing uv would read as an option).
+var dependencyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*(\[[A-Za-z0-9._,-]+\])?([<>=!~]=?[A-Za-z0-9.*+!-]+(,[<>=!~]=?[A-Za-z0-9.*+!-]+)*)?$`)
+
+// PluginBundle is what a contributing agent sends.
+type PluginBundle struct {
+	// Name is the plugin package (src/plugins/<name>).
+	Name string `json:"name"`
+	// Agent and Version identify the contributor. A call made by an agent
+	// fills them from its principal.
+	Agent   string `json:"agent,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Files maps paths relative to the package to their content.
+	Files map[string]string `json:"files"`
+	// Entry is the name __init__.py binds to its Plugin, imported by the
+	// registry; default plugin.
+	Entry string `json:"entry,omitempty"`
+	// Dependencies are requirements added with uv add ("httpx>=0.27").
+	Dependencies []string `json:"dependencies,omitempty"`
+	// Environment lists the variables the plugin reads.
+	Environment []EnvironmentRequirement `json:"environment,omitempty"`
+	// Force overwrites local edits of a previous contribution.
+	Force bool `json:"force,omitempty"`
+}
+
+// EnvironmentRequirement is a variable a plugin reads.
+type EnvironmentRequirement struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+func (e EnvironmentRequirement) String() string {
+	s := e.Name
+	if e.Required {
+		s += " (required)"
+	}
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	return s
+}
+
+var (
+	entryPattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	environmentPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+// pluginEntry is the registry entry of a bundle or contribution.
+func pluginEntry(entry string) string {
+	if entry == "" {
+		return defaultPluginEntry
+	}
+	return entry
+}
+
+// Validate checks everything that can be checked without Python.
+func (b *PluginBundle) Validate() error {
+	var errs []error
+	if err := validatePluginName(b.Name); err != nil {
+		errs = append(errs, err)
+	}
+	if b.Agent == "" || b.Version == "" {
+		errs = append(errs, fmt.Errorf("agent and version identify the contributor and are required"))
+	}
+	switch {
+	case len(b.Files) == 0:
+		errs = append(errs, fmt.Errorf("bundle has no files"))
+	case len(b.Files) > maxBundleFiles:
+		errs = append(errs, fmt.Errorf("bundle has %d files, at most %d allowed", len(b.Files), maxBundleFiles))
+	}
+	if _, ok := b.Files["__init__.py"]; !ok && len(b.Files) > 0 {
+		errs = append(errs, fmt.Errorf("bundle has no __init__.py"))
+	}
+	total := 0
+	for _, p := range sortedKeys(b.Files) {
+		if err := validateBundlePath(p); err != nil {
+			errs = append(errs, err)
+		}
+		size := len(b.Files[p])
+		total += size
+		if size > maxBundleFileSize {
+			errs = append(errs, fmt.Errorf("%s is %d bytes, at most %d allowed", p, size, maxBundleFileSize))
+		}
+	}
+	if total > maxBundleSize {
+		errs = append(errs, fmt.Errorf("bundle is %d bytes, at most %d allowed", total, maxBundleSize))
+	}
+	for _, dep := range b.Dependencies {
+		if !dependencyPattern.MatchString(dep) {
+			errs = append(errs, fmt.Errorf("dependency %q is not a plain requirement like httpx>=0.27", dep))
+		}
+	}
+	if b.Entry != "" && !entryPattern.MatchString(b.Entry) {
+		errs = append(errs, fmt.Errorf("entry %q is not a Python name", b.Entry))
+	}
+	seen := map[string]bool{}
+	for _, env := range b.Environment {
+		if !environmentPattern.MatchString(env.Name) {
+			errs = append(errs, fmt.Errorf("environment variable %q must be UPPER_SNAKE_CASE", env.Name))
+		}
+		if seen[env.Name] {
+			errs = append(errs, fmt.Errorf("environment variable %s is listed twice", env.Name))
+		}
+		seen[env.Name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// contributor resolves who a call is made on behalf of. A call carrying an
+// agent principal is made by that agent ("publisher/name:version"), and a
+// declared agent must match it; otherwise the declared agent stands,
+// unverified.
+func contributor(ctx context.Context, declared string) (agent, version string, verified bool, err error) {
+	principal := policy.PrincipalFrom(ctx)
+	if principal == nil || principal.AgentID == "" {
+		return declared, "", false, nil
+	}
+	agent, version, _ = strings.Cut(principal.AgentID, ":")
+	if declared != "" && declared != agent {
+		return "", "", false, fmt.Errorf("the call is made by agent %s, not %s", agent, declared)
+	}
+	return agent, version, true, nil
+}
+
+// identify sets the contributor of the bundle from the call.
+func (b *PluginBundle) identify(ctx context.Context) (verified bool, err error) {
+	agent, version, verified, err := contributor(ctx, b.Agent)
+	if err != nil {
+		return false, err
+	}
+	b.Agent = agent
+	if b.Version == "" {
+		b.Version = version
+	}
+	return verified, nil
+}
+
+// validateBundlePath keeps files inside the package, visible and of a
+// known kind.
+func validateBundlePath(p string) error {
+	if p == "" || strings.Contains(p, `\`) || path.IsAbs(p) || path.Clean(p) != p {
+		return fmt.Errorf("%q is not a clean relative path", p)
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." || strings.HasPrefix(segment, ".") {
+			return fmt.Errorf("%q leaves the package or is hidden", p)
+		}
+	}
+	if !slices.Contains(bundleExtensions, path.Ext(p)) {
+		return fmt.Errorf("%q: only %s files are accepted", p, strings.Join(bundleExtensions, " "))
+	}
+	return nil
+}
+
+// contribution is the provenance of one contributed plugin.
+type contribution struct {
+	Plugin  string `json:"plugin"`
+	Agent   string `json:"agent"`
+	Version string `json:"version"`
+	// Files maps package paths to the sha256 of what was contributed.
+	Files        map[string]string `json:"files"`
+	Entry        string            `json:"entry,omitempty"`
+	Dependencies []string          `json:"dependencies,omitempty"`
+	// Environment is required configuration of the service.
+	Environment []EnvironmentRequirement `json:"environment,omitempty"`
+	// Verified is set when the agent was the principal of the call.
+	Verified bool      `json:"verified,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// changeableBy checks agent may update or withdraw the contribution: the
+// agent that made it, verified when it was.
+func (c *contribution) changeableBy(agent string, verified bool) error {
+	if c.Agent != agent {
+		return fmt.Errorf("plugin %s was contributed by %s", c.Plugin, c.Agent)
+	}
+	if c.Verified && !verified {
+		return fmt.Errorf("plugin %s was contributed by %s in an authenticated call: only a call made by that agent can change it", c.Plugin, c.Agent)
+	}
+	return nil
+}
+
+// editedFiles lists the contributed files that changed or disappeared in
+// dir, and files added next to them.
+func (c *contribution) editedFiles(dir string) ([]string, error) {
+	var edited []string
+	for _, p := range sortedKeys(c.Files) {
+		content, err := os.ReadFile(filepath.Join(dir, p))
+		if errors.Is(err, os.ErrNotExist) || (err == nil && hashString(string(content)) != c.Files[p]) {
+			edited = append(edited, p)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			if entry != nil && entry.Name() == "__pycache__" {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.Files[filepath.ToSlash(rel)]; !ok {
+			edited = append(edited, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return edited, err
+}
+
+// contributions is the provenance file.
+type contributions struct {
+	path    string
+	Plugins []contribution `json:"plugins"`
+}
+
+func loadContributions(p string) (*contributions, error) {
+	c := &contributions{path: p}
+	content, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("corrupted %s: %w", p, err)
+	}
+	return c, nil
+}
+
+func (c *contributions) find(plugin string) *contribution {
+	for i := range c.Plugins {
+		if c.Plugins[i].Plugin == plugin {
+			return &c.Plugins[i]
+		}
+	}
+	return nil
+}
+
+func (c *contributions) put(record contribution) {
+	if existing := c.find(record.Plugin); existing != nil {
+		*existing = record
+		return
+	}
+	c.Plugins = append(c.Plugins, record)
+}
+
+func (c *contributions) delete(plugin string) {
+	c.Plugins = slices.DeleteFunc(c.Plugins, func(record contribution) bool { return record.Plugin == plugin })
+}
+
+func (c *contributions) save() error {
+	if len(c.Plugins) == 0 {
+		if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(content, '\n'), 0o644)
+}
+
+// registerContributionCommands exposes contribute-plugin and withdraw-plugin.
+func (s *Builder) registerContributionCommands() {
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "contribute-plugin",
+		Description: "Merge a plugin bundle from another agent (JSON: name, agent, version, files, entry, dependencies, environment, force) into src/plugins, add its dependencies with uv and register it. The same agent can send it again to update the plugin. Agents call the plugin.contribute tool instead.",
+		Usage:       `contribute-plugin '{"name": "orders", "agent": "acme/orders", "version": "1.0.0", "files": {"__init__.py": "..."}}'`,
+		Tags:        []string{"plugin", "contribution"},
+	}, s.cmdContributePlugin)
+
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "withdraw-plugin",
+		Description: "Remove a contributed plugin, on behalf of the agent that contributed it. --force also discards local edits.",
+		Usage:       "withdraw-plugin acme/orders orders [--force]",
+		Tags:        []string{"plugin", "contribution"},
+		Destructive: true,
+	}, s.cmdWithdrawPlugin)
+}
+
+func (s *Builder) cmdContributePlugin(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: contribute-plugin '<bundle json>'")
+	}
+	bundle, err := decodePluginBundle([]byte(args[0]))
+	if err != nil {
+		return "", err
+	}
+	return s.ContributePlugin(ctx, bundle)
+}
+
+// decodePluginBundle reads a JSON bundle, rejecting unknown fields.
+func decodePluginBundle(content []byte) (*PluginBundle, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var bundle PluginBundle
+	if err := decoder.Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("invalid plugin bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+// ContributePlugin merges the bundle into src/plugins on behalf of the
+// agent making the call, and returns a report of what changed.
+func (s *Builder) ContributePlugin(ctx context.Context, bundle *PluginBundle) (_ string, err error) {
+	if s.Service.SourceLocation == "" {
+		return "", fmt.Errorf("the service is not loaded")
+	}
+	verified, err := bundle.identify(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := bundle.Validate(); err != nil {
+		return "", fmt.Errorf("invalid plugin bundle: %w", err)
+	}
+
+	registry, err := loadContributions(path.Join(s.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		return "", err
+	}
+	pkg := path.Join(s.Service.SourceLocation, "src/plugins", bundle.Name)
+	previous := registry.find(bundle.Name)
+	_, statErr := os.Stat(pkg)
+	exists := statErr == nil
+	if exists {
+		if previous == nil {
+			return "", fmt.Errorf("src/plugins/%s exists and was not contributed: pick another name", bundle.Name)
+		}
+		if err := previous.changeableBy(bundle.Agent, verified); err != nil {
+			return "", err
+		}
+		if !bundle.Force {
+			edited, err := previous.editedFiles(pkg)
+			if err != nil {
+				return "", err
+			}
+			if len(edited) > 0 {
+				return "", fmt.Errorf("plugin %s was edited locally (%s): send the bundle with force to overwrite", bundle.Name, strings.Join(edited, ", "))
+			}
+		}
+	}
+
+	// Stage next to the package so the swaps are renames; the previous
+	// package waits there until the contribution is recorded.
+	work, err := os.MkdirTemp(path.Dir(pkg), "."+bundle.Name+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work)
+	staging := filepath.Join(work, "contributed")
+	record := contribution{Plugin: bundle.Name, Agent: bundle.Agent, Version: bundle.Version, Files: map[string]string{},
+		Entry: bundle.Entry, Dependencies: bundle.Dependencies, Environment: bundle.Environment, Verified: verified, At: time.Now().UTC()}
+	for p, content := range bundle.Files {
+		destination := filepath.Join(staging, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(destination, []byte(content), 0o644); err != nil {
+			return "", err
+		}
+		record.Files[p] = hashString(content)
+	}
+	if err := s.checkBundleSyntax(ctx, staging, pluginEntry(bundle.Entry)); err != nil {
+		return "", err
+	}
+
+	// From here on, a failing step undoes the previous ones, last first.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undoErr := undo[i](); undoErr != nil {
+				s.Wool.Warn("cannot undo a step of the failed contribution", wool.ErrField(undoErr))
+			}
+		}
+	}()
+
+	var out []string
+	if len(bundle.Dependencies) > 0 {
+		restore, err := s.saveManifests()
+		if err != nil {
+			return "", err
+		}
+		undo = append(undo, restore)
+		if err := s.addDependencies(ctx, bundle.Dependencies); err != nil {
+			return "", err
+		}
+		out = append(out, "added dependencies: "+strings.Join(bundle.Dependencies, ", "))
+	}
+
+	replaced := filepath.Join(work, "previous")
+	if exists {
+		if err := os.Rename(pkg, replaced); err != nil {
+			return "", err
+		}
+	}
+	undo = append(undo, func() error {
+		if err := os.RemoveAll(pkg); err != nil || !exists {
+			return err
+		}
+		return os.Rename(replaced, pkg)
+	})
+	if err := os.Rename(staging, pkg); err != nil {
+		return "", err
+	}
+	registered, err := s.editPluginRegistry(ctx, "add", bundle.Name, pluginEntry(bundle.Entry))
+	if err != nil {
+		return "", err
+	}
+	if registered {
+		// An update can change the entry: register the previous one again.
+		undo = append(undo, func() error {
+			if previous != nil {
+				_, err := s.editPluginRegistry(ctx, "add", bundle.Name, pluginEntry(previous.Entry))
+				return err
+			}
+			_, err := s.editPluginRegistry(ctx, "remove", bundle.Name, "")
+			return err
+		})
+	}
+	registry.put(record)
+	if err := registry.save(); err != nil {
+		return "", s.Wool.Wrapf(err, "cannot record the contribution")
+	}
+
+	verb := "contributed"
+	if previous != nil {
+		verb = "updated"
+		if dropped := missing(previous.Dependencies, bundle.Dependencies); len(dropped) > 0 {
+			out = append(out, fmt.Sprintf("no longer needed by %s: %s (uv remove them if nothing else uses them)", bundle.Name, strings.Join(dropped, ", ")))
+		}
+	}
+	out = append([]string{fmt.Sprintf("%s plugin %s from %s %s", verb, bundle.Name, bundle.Agent, bundle.Version)}, out...)
+	if len(bundle.Environment) > 0 {
+		out = append(out, "configuration of the service (Init fails while a required one is unset):")
+		for _, env := range bundle.Environment {
+			out = append(out, "  "+env.String())
+		}
+	}
+	out = append(out, s.regenerateOpenAPI(ctx))
+	return strings.Join(out, "\n"), nil
+}
+
+// saveManifests returns a function writing pyproject.toml and uv.lock
+// back as they are now. The venv catches up on the next uv sync.
+func (s *Builder) saveManifests() (func() error, error) {
+	type saved struct {
+		path    string
+		content []byte
+		exists  bool
+	}
+	var manifests []saved
+	for _, manifest := range dependencyManifests {
+		p := filepath.Join(s.Service.SourceLocation, manifest)
+		content, err := os.ReadFile(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		manifests = append(manifests, saved{path: p, content: content, exists: err == nil})
+	}
+	return func() error {
+		var errs []error
+		for _, m := range manifests {
+			if m.exists {
+				errs = append(errs, os.WriteFile(m.path, m.content, 0o644))
+			} else if err := os.Remove(m.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+func (s *Builder) cmdWithdrawPlugin(ctx context.Context, args []string) (string, error) {
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "--force") {
+		return "", fmt.Errorf("usage: withdraw-plugin <agent> <name> [--force]")
+	}
+	return s.WithdrawPlugin(ctx, args[0], args[1], len(args) == 3)
+}
+
+// WithdrawPlugin removes a contributed plugin on behalf of the agent that
+// contributed it; a call made by an agent can leave agent empty. A failing
+// step undoes the previous ones, as in ContributePlugin.
+func (s *Builder) WithdrawPlugin(ctx context.Context, agent string, name string, force bool) (_ string, err error) {
+	if s.Service.SourceLocation == "" {
+		return "", fmt.Errorf("the service is not loaded")
+	}
+	agent, _, verified, err := contributor(ctx, agent)
+	if err != nil {
+		return "", err
+	}
+	if err := validatePluginName(name); err != nil {
+		return "", err
+	}
+	registry, err := loadContributions(path.Join(s.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		return "", err
+	}
+	record := registry.find(name)
+	if record == nil {
+		return "", fmt.Errorf("plugin %s was not contributed by an agent", name)
+	}
+	if err := record.changeableBy(agent, verified); err != nil {
+		return "", err
+	}
+	pkg := path.Join(s.Service.SourceLocation, "src/plugins", name)
+	if !force {
+		edited, err := record.editedFiles(pkg)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if len(edited) > 0 {
+			return "", fmt.Errorf("plugin %s was edited locally (%s): withdraw with --force to discard the edits", name, strings.Join(edited, ", "))
+		}
+	}
+
+	// The package waits next to its place until the withdrawal is recorded.
+	work, err := os.MkdirTemp(path.Dir(pkg), "."+name+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(work)
+
+	// From here on, a failing step undoes the previous ones, last first.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if undoErr := undo[i](); undoErr != nil {
+				s.Wool.Warn("cannot undo a step of the failed withdrawal", wool.ErrField(undoErr))
+			}
+		}
+	}()
+
+	if _, err := os.Stat(pkg); err == nil {
+		withdrawn := filepath.Join(work, "withdrawn")
+		if err := os.Rename(pkg, withdrawn); err != nil {
+			return "", err
+		}
+		undo = append(undo, func() error { return os.Rename(withdrawn, pkg) })
+	}
+	unregistered, err := s.editPluginRegistry(ctx, "remove", name, "")
+	if err != nil {
+		return "", err
+	}
+	if unregistered {
+		undo = append(undo, func() error {
+			_, err := s.editPluginRegistry(ctx, "add", name, pluginEntry(record.Entry))
+			return err
+		})
+	}
+	registry.delete(name)
+	if err := registry.save(); err != nil {
+		return "", s.Wool.Wrapf(err, "cannot record the withdrawal")
+	}
+	out := []string{fmt.Sprintf("withdrew plugin %s from %s %s", name, record.Agent, record.Version)}
+	if len(record.Dependencies) > 0 {
+		out = append(out, fmt.Sprintf("no longer needed by %s: %s (uv remove them if nothing else uses them)", name, strings.Join(record.Dependencies, ", ")))
+	}
+	out = append(out, s.regenerateOpenAPI(ctx))
+	return strings.Join(out, "\n"), nil
+}
+
+// checkPluginEnvironment fails while a variable a contributed plugin
+// requires is unset: neither a configuration of the service nor, outside a
+// container, inherited from the agent. An unset optional one is logged.
+func (s *Runtime) checkPluginEnvironment() error {
+	registry, err := loadContributions(path.Join(s.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		return err
+	}
+	envs, err := s.EnvironmentVariables.All()
+	if err != nil {
+		return s.Wool.Wrapf(err, "getting environment variables")
+	}
+	set := map[string]bool{}
+	for _, env := range envs {
+		set[env.Key] = true
+	}
+	inherited := s.Base.Runtime.RuntimeContext.GetKind() != resources.RuntimeContextContainer
+	var unset []string
+	for _, record := range registry.Plugins {
+		for _, env := range record.Environment {
+			if set[env.Name] {
+				continue
+			}
+			if _, ok := os.LookupEnv(env.Name); ok && inherited {
+				continue
+			}
+			if !env.Required {
+				s.Wool.Debug("optional plugin environment variable is unset", wool.Field("plugin", record.Plugin), wool.Field("name", env.Name))
+				continue
+			}
+			unset = append(unset, fmt.Sprintf("%s (plugin %s)", env.Name, record.Plugin))
+		}
+	}
+	if len(unset) > 0 {
+		return fmt.Errorf("required plugin environment is unset: %s: set it in a configuration of the service", strings.Join(unset, ", "))
+	}
+	return nil
+}
+
+// addDependencies runs uv add in the service's environment.
+func (s *Builder) addDependencies(ctx context.Context, dependencies []string) error {
+	proc, err := s.FastAPI.serviceEnvironment(ctx).NewProcess("uv", append([]string{"add"}, dependencies...)...)
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot create uv add process")
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	proc.WithDir(s.Service.SourceLocation)
+	if err := proc.Run(ctx); err != nil {
+		return fmt.Errorf("uv add %s: %w: %s", strings.Join(dependencies, " "), err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// checkBundleSyntax parses the staged Python files and checks __init__.py
+// binds entry, which the registry imports.
+func (s *Builder) checkBundleSyntax(ctx context.Context, dir string, entry string) error {
+	env := runners.ResolveStandaloneEnvironment(ctx, dir, s.FastAPI.runtimeContext())
+	proc, err := env.NewProcess("python3", "-c", bundleCheckScript, dir, entry)
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot create bundle checker")
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	if err := proc.Run(ctx); err != nil {
+		return fmt.Errorf("cannot check the bundle: %w: %s", err, strings.TrimSpace(output.String()))
+	}
+	var result struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(output.Bytes()), &result); err != nil {
+		return fmt.Errorf("unexpected bundle checker output %q: %w", output.String(), err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("invalid plugin bundle: %s", strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
+
+// missing is what is in before and not in after.
+func missing(before, after []string) []string {
+	var out []string
+	for _, item := range before {
+		if !slices.Contains(after, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// bundleCheckScript: python3 -c <script> <dir> <entry>. It prints
+// {"errors": [...]}.
+const bundleCheckScript = `
+import ast, json, os, sys
+
+root, entry = sys.argv[1:3]
+errors = []
+for directory, _, files in os.walk(root):
+    for name in sorted(files):
+        if not name.endswith(".py"):
+            continue
+        path = os.path.join(directory, name)
+        rel = os.path.relpath(path, root)
+        try:
+            with open(path, "rb") as f:
+                tree = ast.parse(f.read(), filename=rel)
+        except SyntaxError as e:
+            errors.append("%s:%s: %s" % (rel, e.lineno, e.msg))
+            continue
+        if rel != "__init__.py":
+            continue
+        bound = set()
+        for node in tree.body:
+            if isinstance(node, ast.Assign):
+                bound.update(t.id for t in node.targets if isinstance(t, ast.Name))
+            elif isinstance(node, ast.AnnAssign) and isinstance(node.target, ast.Name):
+                bound.add(node.target.id)
+            elif isinstance(node, (ast.Import, ast.ImportFrom)):
+                bound.update(a.asname or a.name for a in node.names)
+        if entry not in bound:
+            errors.append("__init__.py must define %s = Plugin(...)" % entry)
+print(json.dumps({"errors": errors}))
+`
+
+// The Toolbox names of ContributePlugin and WithdrawPlugin.
+const (
+	ToolContributePlugin = "plugin.contribute"
+	ToolWithdrawPlugin   = "plugin.withdraw"
+)
+
+func contributionTools() []*registry.ToolDefinition {
+	contribute := "Contribute a FastAPI plugin to this service, or update yours: files, registry entry, dependencies added with uv, environment variables it reads."
+	contributeSchema, _ := structpb.NewStruct(map[string]any{
+		"type": "object", "additionalProperties": false,
+		"required": []any{"name", "files"},
+		"properties": map[string]any{
+			"name":         map[string]any{"type": "string", "description": "Plugin package: src/plugins/<name>."},
+			"version":      map[string]any{"type": "string", "description": "Version of the contribution; default: the version of the calling agent."},
+			"files":        map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}, "description": "Package-relative paths to contents; __init__.py binds the entry to a Plugin(...)."},
+			"entry":        map[string]any{"type": "string", "description": "Name __init__.py binds to its Plugin, imported by the registry; default: plugin."},
+			"dependencies": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Requirements added with uv add, like httpx>=0.27."},
+			"environment": map[string]any{"type": "array", "description": "Environment variables the plugin reads, required configuration of the service.", "items": map[string]any{
+				"type": "object", "additionalProperties": false,
+				"required": []any{"name"},
+				"properties": map[string]any{
+					"name":        map[string]any{"type": "string", "description": "UPPER_SNAKE_CASE variable name."},
+					"description": map[string]any{"type": "string"},
+					"required":    map[string]any{"type": "boolean", "description": "Init fails while it is unset."},
+				},
+			}},
+			"force": map[string]any{"type": "boolean", "description": "Overwrite local edits of your previous contribution."},
+		},
+	})
+	withdraw := "Withdraw a plugin you contributed to this service."
+	withdrawSchema, _ := structpb.NewStruct(map[string]any{
+		"type": "object", "additionalProperties": false,
+		"required": []any{"name"},
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string", "description": "Plugin package: src/plugins/<name>."},
+			"force": map[string]any{"type": "boolean", "description": "Also discard local edits of the plugin."},
+		},
+	})
+	return []*registry.ToolDefinition{
+		{
+			Name:               ToolContributePlugin,
+			SummaryDescription: contribute,
+			LongDescription:    contribute + " The contributor is the agent making the call: only that agent can update or withdraw the plugin. The bundle is validated, the package swapped into src/plugins and registered; a failing step restores pyproject.toml, uv.lock, the previous package and the registry.",
+			InputSchema:        contributeSchema,
+			Tags:               []string{"plugin", "contribution", "filesystem"},
+			Idempotency:        "side_effecting",
+			ErrorModes:         "The call fails without an agent principal, on an invalid bundle, when another agent contributed the plugin, or when it was edited locally (unless force).",
+		},
+		{
+			Name:               ToolWithdrawPlugin,
+			SummaryDescription: withdraw,
+			LongDescription:    withdraw + " Its package and registry entry are removed; its dependencies stay.",
+			InputSchema:        withdrawSchema,
+			Destructive:        true,
+			Tags:               []string{"plugin", "contribution", "filesystem", "destructive"},
+			Idempotency:        "side_effecting",
+			ErrorModes:         "The call fails without an agent principal, when another agent contributed the plugin, or when it was edited locally (unless force).",
+		},
+	}
+}
+
+// callContributionTool serves plugin.contribute and plugin.withdraw, for
+// calls made by an agent only.
+func (b *Toolbox) callContributionTool(ctx context.Context, req *toolboxv0.CallToolRequest) *toolboxv0.CallToolResponse {
+	if _, _, verified, _ := contributor(ctx, ""); !verified {
+		return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("%s is called by agents: the call carries no agent principal", req.GetName())}
+	}
+	var schema *structpb.Struct
+	for _, tool := range contributionTools() {
+		if tool.Name == req.GetName() {
+			schema = tool.InputSchema
+		}
+	}
+	if err := coretoolbox.ValidateArguments(schema, req.GetArguments()); err != nil {
+		return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("invalid arguments for %q: %s", req.GetName(), err)}
+	}
+	raw, err := protojson.Marshal(req.GetArguments())
+	if err != nil {
+		return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("cannot decode arguments: %v", err)}
+	}
+
+	var out string
+	if req.GetName() == ToolContributePlugin {
+		bundle, decodeErr := decodePluginBundle(raw)
+		if decodeErr != nil {
+			return &toolboxv0.CallToolResponse{Error: decodeErr.Error()}
+		}
+		out, err = b.builder.ContributePlugin(ctx, bundle)
+	} else {
+		var args struct {
+			Name  string `json:"name"`
+			Force bool   `json:"force"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("cannot decode arguments: %v", err)}
+		}
+		out, err = b.builder.WithdrawPlugin(ctx, "", args.Name, args.Force)
+	}
+	if err != nil {
+		return &toolboxv0.CallToolResponse{Error: err.Error()}
+	}
+	return &toolboxv0.CallToolResponse{Content: []*toolboxv0.Content{{Body: &toolboxv0.Content_Text{Text: out}}}}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	toolboxv0 "github.com/codefly-dev/core/generated/go/codefly/services/toolbox/v0"
+	"github.com/codefly-dev/core/policy"
+	"github.com/codefly-dev/core/resources"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const contributedPlugin = `from src.framework.plugin import Plugin
+
+plugin = Plugin(name="orders")
+`
+
+func TestPluginBundleValidate(t *testing.T) {
+	valid := func() PluginBundle {
+		return PluginBundle{
+			Name:         "orders",
+			Agent:        "acme/orders",
+			Version:      "1.0.0",
+			Files:        map[string]string{"__init__.py": contributedPlugin, "migrations/env.py": "", "migrations/script.py.mako": ""},
+			Dependencies: []string{"httpx>=0.27,<1", "sqlalchemy[asyncio]", "alembic"},
+			Environment:  []EnvironmentRequirement{{Name: "ORDERS_API_KEY", Required: true}, {Name: "ORDERS_REGION"}},
+		}
+	}
+	b := valid()
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(*PluginBundle){
+		"name":          func(b *PluginBundle) { b.Name = "Orders" },
+		"contributor":   func(b *PluginBundle) { b.Agent = "" },
+		"no init":       func(b *PluginBundle) { delete(b.Files, "__init__.py") },
+		"escaping path": func(b *PluginBundle) { b.Files["../registry.py"] = "" },
+		"absolute path": func(b *PluginBundle) { b.Files["/etc/passwd.txt"] = "" },
+		"unclean path":  func(b *PluginBundle) { b.Files["a//b.py"] = "" },
+		"hidden file":   func(b *PluginBundle) { b.Files[".env.txt"] = "" },
+		"extension":     func(b *PluginBundle) { b.Files["run.sh"] = "" },
+		"large file":    func(b *PluginBundle) { b.Files["data.json"] = strings.Repeat("x", maxBundleFileSize+1) },
+		"option":        func(b *PluginBundle) { b.Dependencies = []string{"--index-url=https://example.com"} },
+		"url":           func(b *PluginBundle) { b.Dependencies = []string{"pkg @ https://example.com/pkg.whl"} },
+		"entry":         func(b *PluginBundle) { b.Entry = "orders-plugin" },
+		"environment":   func(b *PluginBundle) { b.Environment[1].Name = "orders_region" },
+		"listed twice":  func(b *PluginBundle) { b.Environment[1].Name = "ORDERS_API_KEY" },
+	} {
+		b := valid()
+		change(&b)
+		if err := b.Validate(); err == nil {
+			t.Errorf("%s: bundle accepted", name)
+		}
+	}
+}
+
+func contribute(t *testing.T, b *Builder, bundle PluginBundle) (string, error) {
+	t.Helper()
+	content, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.cmdContributePlugin(context.Background(), []string{string(content)})
+}
+
+func TestContributeAndWithdrawPlugin(t *testing.T) {
+	b := pluginTestBuilder(t)
+	ctx := context.Background()
+	bundle := PluginBundle{
+		Name:    "orders",
+		Agent:   "acme/orders",
+		Version: "1.0.0",
+		Files:   map[string]string{"__init__.py": contributedPlugin, "router.py": "ROUTES = []\n"},
+	}
+	out, err := contribute(t, b, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "contributed plugin orders from acme/orders 1.0.0") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	pkg := filepath.Join(b.Service.SourceLocation, "src/plugins/orders")
+	if content, err := os.ReadFile(filepath.Join(pkg, "router.py")); err != nil || string(content) != "ROUTES = []\n" {
+		t.Errorf("router.py not merged: %q %v", content, err)
+	}
+	if !strings.Contains(readRegistry(t, b), "from src.plugins.orders import plugin as orders_plugin") {
+		t.Errorf("not registered:\n%s", readRegistry(t, b))
+	}
+	registry, err := loadContributions(filepath.Join(b.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := registry.find("orders"); record == nil || record.Agent != "acme/orders" || record.Files["router.py"] != hashString("ROUTES = []\n") {
+		t.Fatalf("provenance not recorded: %+v", registry.Plugins)
+	}
+
+	other := bundle
+	other.Agent = "someone/else"
+	if _, err := contribute(t, b, other); err == nil {
+		t.Error("another agent replaced the plugin")
+	}
+
+	// A local edit blocks the update unless forced.
+	if err := os.WriteFile(filepath.Join(pkg, "router.py"), []byte("ROUTES = ['edited']\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	update := bundle
+	update.Version = "1.1.0"
+	update.Files = map[string]string{"__init__.py": contributedPlugin}
+	if _, err := contribute(t, b, update); err == nil || !strings.Contains(err.Error(), "router.py") {
+		t.Errorf("expected the local edit to block the update, got %v", err)
+	}
+	update.Force = true
+	if out, err := contribute(t, b, update); err != nil || !strings.Contains(out, "updated plugin orders") {
+		t.Fatalf("forced update: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(pkg, "router.py")); !os.IsNotExist(err) {
+		t.Error("the update must replace the package")
+	}
+
+	if _, err := b.cmdWithdrawPlugin(ctx, []string{"someone/else", "orders"}); err == nil {
+		t.Error("another agent withdrew the plugin")
+	}
+	if _, err := b.cmdWithdrawPlugin(ctx, []string{"acme/orders", "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pkg); !os.IsNotExist(err) {
+		t.Error("plugin package still there")
+	}
+	if got := readRegistry(t, b); got != scaffoldedRegistry {
+		t.Errorf("registry not restored:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(b.Service.SourceLocation, contributionsFile)); !os.IsNotExist(err) {
+		t.Error("provenance of the withdrawn plugin kept")
+	}
+}
+
+func TestContributePluginEntry(t *testing.T) {
+	b := pluginTestBuilder(t)
+	bundle := PluginBundle{
+		Name:    "orders",
+		Agent:   "acme/orders",
+		Version: "1.0.0",
+		Entry:   "orders",
+		Files:   map[string]string{"__init__.py": contributedPlugin},
+	}
+	if _, err := contribute(t, b, bundle); err == nil || !strings.Contains(err.Error(), "must define orders") {
+		t.Fatalf("expected the entry to be checked, got %v", err)
+	}
+	bundle.Files["__init__.py"] = "from src.framework.plugin import Plugin\n\norders = Plugin(name=\"orders\")\n"
+	if _, err := contribute(t, b, bundle); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRegistry(t, b); !strings.Contains(got, "from src.plugins.orders import orders as orders_plugin") {
+		t.Errorf("entry not registered:\n%s", got)
+	}
+
+	// An update with another entry replaces the registered one.
+	update := bundle
+	update.Version = "1.1.0"
+	update.Entry = ""
+	update.Files = map[string]string{"__init__.py": contributedPlugin}
+	if _, err := contribute(t, b, update); err != nil {
+		t.Fatal(err)
+	}
+	got := readRegistry(t, b)
+	if !strings.Contains(got, "from src.plugins.orders import plugin as orders_plugin") || strings.Contains(got, "import orders as") || strings.Count(got, "    orders_plugin,") != 1 {
+		t.Errorf("entry not replaced:\n%s", got)
+	}
+	if _, err := b.cmdWithdrawPlugin(context.Background(), []string{"acme/orders", "orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRegistry(t, b); got != scaffoldedRegistry {
+		t.Errorf("registry not restored:\n%s", got)
+	}
+}
+
+func TestWithdrawPluginRollsBack(t *testing.T) {
+	b := pluginTestBuilder(t)
+	bundle := PluginBundle{Name: "orders", Agent: "acme/orders", Version: "1.0.0", Files: map[string]string{"__init__.py": contributedPlugin, "router.py": "ROUTES = []\n"}}
+	if _, err := contribute(t, b, bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	// The registry edit fails once the package is moved aside.
+	registryPath := filepath.Join(b.Service.SourceLocation, pluginRegistry)
+	if err := os.WriteFile(registryPath, []byte("def broken(:\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.cmdWithdrawPlugin(context.Background(), []string{"acme/orders", "orders"}); err == nil {
+		t.Fatal("the withdrawal succeeded with a broken registry")
+	}
+	pkg := filepath.Join(b.Service.SourceLocation, "src/plugins/orders")
+	if content, err := os.ReadFile(filepath.Join(pkg, "router.py")); err != nil || string(content) != "ROUTES = []\n" {
+		t.Errorf("package not restored: %q %v", content, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(pkg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("left %s behind", entry.Name())
+		}
+	}
+	registry, err := loadContributions(filepath.Join(b.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.find("orders") == nil {
+		t.Error("provenance of the plugin dropped")
+	}
+}
+
+func TestPluginEnvironment(t *testing.T) {
+	b := pluginTestBuilder(t)
+	bundle := PluginBundle{
+		Name:        "orders",
+		Agent:       "acme/orders",
+		Version:     "1.0.0",
+		Files:       map[string]string{"__init__.py": contributedPlugin},
+		Environment: []EnvironmentRequirement{{Name: "ORDERS_API_KEY", Description: "key of the orders API", Required: true}, {Name: "ORDERS_REGION"}},
+	}
+	out, err := contribute(t, b, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "ORDERS_API_KEY (required): key of the orders API") || !strings.Contains(out, "ORDERS_REGION") {
+		t.Errorf("environment not reported:\n%s", out)
+	}
+	registry, err := loadContributions(filepath.Join(b.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := registry.find("orders"); record == nil || len(record.Environment) != 2 {
+		t.Fatalf("environment not recorded: %+v", registry.Plugins)
+	}
+
+	rt := NewRuntime(b.FastAPI)
+	rt.Wool = b.Wool
+	rt.Base.Runtime.RuntimeContext = resources.NewRuntimeContextContainer()
+	t.Setenv("ORDERS_API_KEY", "from-the-agent")
+	if err := rt.checkPluginEnvironment(); err == nil || !strings.Contains(err.Error(), "ORDERS_API_KEY (plugin orders)") {
+		t.Errorf("a container does not inherit the agent's environment, got %v", err)
+	}
+	rt.Base.Runtime.RuntimeContext = resources.NewRuntimeContextNative()
+	if err := rt.checkPluginEnvironment(); err != nil {
+		t.Errorf("natively, the agent's environment is inherited: %v", err)
+	}
+	rt.Base.Runtime.RuntimeContext = resources.NewRuntimeContextContainer()
+	rt.EnvironmentVariables.AddOverrides(map[string]string{"ORDERS_API_KEY": "configured"})
+	if err := rt.checkPluginEnvironment(); err != nil {
+		t.Errorf("configured variable not found: %v", err)
+	}
+}
+
+func TestContributePluginRejectsInvalidPython(t *testing.T) {
+	b := pluginTestBuilder(t)
+	for name, files := range map[string]map[string]string{
+		"syntax":    {"__init__.py": contributedPlugin, "router.py": "def broken(:\n"},
+		"no plugin": {"__init__.py": "from src.framework.plugin import Plugin\n"},
+	} {
+		bundle := PluginBundle{Name: "orders", Agent: "acme/orders", Version: "1.0.0", Files: files}
+		if _, err := contribute(t, b, bundle); err == nil {
+			t.Errorf("%s: bundle accepted", name)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(b.Service.SourceLocation, "src/plugins"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "registry.py" {
+			t.Errorf("left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestContributePluginKeepsLocalPlugins(t *testing.T) {
+	b := pluginTestBuilder(t)
+	if _, err := b.cmdAddPlugin(context.Background(), []string{"orders"}); err != nil {
+		t.Fatal(err)
+	}
+	bundle := PluginBundle{Name: "orders", Agent: "acme/orders", Version: "1.0.0", Files: map[string]string{"__init__.py": contributedPlugin}}
+	if _, err := contribute(t, b, bundle); err == nil {
+		t.Error("a contribution replaced a local plugin")
+	}
+}
+
+func TestContributePluginRollsBack(t *testing.T) {
+	b := pluginTestBuilder(t)
+	pyproject := filepath.Join(b.Service.SourceLocation, "pyproject.toml")
+	if err := os.WriteFile(pyproject, []byte("[project]\ndependencies = []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// uv add edits the manifests, as the real one does.
+	dir := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = add ] || exit 0\necho 'added = true' >> pyproject.toml\necho lock > uv.lock\n"
+	if err := os.WriteFile(filepath.Join(dir, "uv"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	bundle := PluginBundle{Name: "orders", Agent: "acme/orders", Version: "1.0.0", Files: map[string]string{"__init__.py": contributedPlugin, "router.py": "ROUTES = []\n"}}
+	if _, err := contribute(t, b, bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	// The registry edit fails once the dependencies are added.
+	if err := os.WriteFile(filepath.Join(b.Service.SourceLocation, pluginRegistry), []byte("def broken(:\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	update := bundle
+	update.Version = "1.1.0"
+	update.Files = map[string]string{"__init__.py": contributedPlugin}
+	update.Dependencies = []string{"httpx"}
+	if _, err := contribute(t, b, update); err == nil {
+		t.Fatal("the contribution succeeded with a broken registry")
+	}
+	if content, err := os.ReadFile(pyproject); err != nil || string(content) != "[project]\ndependencies = []\n" {
+		t.Errorf("pyproject.toml not restored: %q %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(b.Service.SourceLocation, "uv.lock")); !os.IsNotExist(err) {
+		t.Error("uv.lock of the failed contribution kept")
+	}
+	pkg := filepath.Join(b.Service.SourceLocation, "src/plugins/orders")
+	if content, err := os.ReadFile(filepath.Join(pkg, "router.py")); err != nil || string(content) != "ROUTES = []\n" {
+		t.Errorf("previous package not restored: %q %v", content, err)
+	}
+	registry, err := loadContributions(filepath.Join(b.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := registry.find("orders"); record == nil || record.Version != "1.0.0" {
+		t.Errorf("provenance changed: %+v", registry.Plugins)
+	}
+}
+
+func TestContributePluginIdentifiesTheCaller(t *testing.T) {
+	b := pluginTestBuilder(t)
+	ctx := policy.WithPrincipal(context.Background(), &policy.Principal{Kind: policy.KindAgent, AgentID: "acme/orders:1.2.0"})
+	bundle := &PluginBundle{Name: "orders", Files: map[string]string{"__init__.py": contributedPlugin}}
+	out, err := b.ContributePlugin(ctx, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "contributed plugin orders from acme/orders 1.2.0") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	claim := &PluginBundle{Name: "orders", Agent: "someone/else", Files: map[string]string{"__init__.py": contributedPlugin}}
+	if _, err := b.ContributePlugin(ctx, claim); err == nil || !strings.Contains(err.Error(), "made by agent acme/orders") {
+		t.Errorf("expected the declared agent to be checked against the caller, got %v", err)
+	}
+
+	// Without a principal, the same name does not change a verified
+	// contribution.
+	unverified := PluginBundle{Name: "orders", Agent: "acme/orders", Version: "2.0.0", Files: map[string]string{"__init__.py": contributedPlugin}}
+	if _, err := contribute(t, b, unverified); err == nil || !strings.Contains(err.Error(), "authenticated call") {
+		t.Errorf("an unverified call changed a verified contribution: %v", err)
+	}
+	if _, err := b.cmdWithdrawPlugin(context.Background(), []string{"acme/orders", "orders"}); err == nil {
+		t.Error("an unverified call withdrew a verified contribution")
+	}
+	if _, err := b.WithdrawPlugin(ctx, "", "orders", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContributionTools(t *testing.T) {
+	b := pluginTestBuilder(t)
+	toolbox := &Toolbox{builder: b}
+	args, err := structpb.NewStruct(map[string]any{
+		"name":        "orders",
+		"files":       map[string]any{"__init__.py": contributedPlugin},
+		"entry":       "plugin",
+		"environment": []any{map[string]any{"name": "ORDERS_API_KEY", "required": true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := &toolboxv0.CallToolRequest{Name: ToolContributePlugin, Arguments: args}
+	if resp, err := toolbox.CallTool(context.Background(), call); err != nil || !strings.Contains(resp.GetError(), "no agent principal") {
+		t.Errorf("expected the call to need an agent principal, got %v %v", resp, err)
+	}
+
+	ctx := policy.WithPrincipal(context.Background(), &policy.Principal{Kind: policy.KindAgent, AgentID: "acme/orders:1.0.0"})
+	resp, err := toolbox.CallTool(ctx, call)
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("contribute: %v %v", resp.GetError(), err)
+	}
+	if text := resp.GetContent()[0].GetText(); !strings.Contains(text, "contributed plugin orders from acme/orders 1.0.0") {
+		t.Errorf("unexpected output:\n%s", text)
+	}
+
+	withAgent, _ := structpb.NewStruct(map[string]any{"name": "orders", "agent": "someone/else", "files": map[string]any{"__init__.py": contributedPlugin}})
+	if resp, _ := toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: ToolContributePlugin, Arguments: withAgent}); resp.GetError() == "" {
+		t.Error("the agent is not an argument of the tool")
+	}
+
+	name, _ := structpb.NewStruct(map[string]any{"name": "orders"})
+	if resp, err := toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: ToolWithdrawPlugin, Arguments: name}); err != nil || resp.GetError() != "" {
+		t.Fatalf("withdraw: %v %v", resp.GetError(), err)
+	}
+	if got := readRegistry(t, b); got != scaffoldedRegistry {
+		t.Errorf("registry not restored:\n%s", got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+// debug.go — running the app under debugpy.
+//
+// With `debug: true`, Start launches the server command as a module under
+// `python -m debugpy --listen`, with debugpy pulled in by `uv run --with`
+// so projects need not depend on it. The debug port is the same on the
+// host and in the container (published by CreateRunnerEnvironment), and
+// the attach address is advertised through Information.
+
+import (
+	"fmt"
+)
+
+// defaultDebugPort is debugpy's conventional port.
+const defaultDebugPort = 5678
+
+// DebugListenPort is the debug-port setting, or the default.
+func (s *Settings) DebugListenPort() uint16 {
+	if s.DebugPort > 0 {
+		return uint16(s.DebugPort)
+	}
+	return defaultDebugPort
+}
+
+// DebugCommand wraps a ServerCommand argv so it runs under debugpy,
+// listening on host. The server is started as a module (-m uvicorn,
+// -m gunicorn) so debugpy owns the interpreter from the first import.
+func (s *Settings) DebugCommand(command []string, host string) []string {
+	args := []string{"python", "-m", "debugpy", "--listen", fmt.Sprintf("%s:%d", host, s.DebugListenPort())}
+	if s.DebugWait {
+		args = append(args, "--wait-for-client")
+	}
+	return append(append(args, "-m"), command...)
+}
+
+// serverArgv is the full `uv run` command line of the server.
+func (s *Runtime) serverArgv(mode ServerMode) []string {
+	command := s.FastAPI.Settings.ServerCommand(mode, "0.0.0.0", s.port)
+	if !s.FastAPI.Settings.Debug {
+		return append([]string{"uv", "run"}, command...)
+	}
+	// Only reachable from the host, unless the port has to cross the
+	// container boundary.
+	host := "127.0.0.1"
+	if s.Base.Runtime.IsContainerRuntime() {
+		host = "0.0.0.0"
+	}
+	return append([]string{"uv", "run", "--with", "debugpy"}, s.FastAPI.Settings.DebugCommand(command, host)...)
+}
+
+// debugAddress is where an IDE attaches, empty when debugging is off.
+func (s *Runtime) debugAddress() string {
+	if !s.FastAPI.Settings.Debug {
+		return ""
+	}
+	return fmt.Sprintf("localhost:%d", s.FastAPI.Settings.DebugListenPort())
+}
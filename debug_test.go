@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugCommand(t *testing.T) {
+	s := &Settings{}
+	command := s.ServerCommand(ServerModeSingle, "0.0.0.0", 8080)
+
+	got := strings.Join(s.DebugCommand(command, "127.0.0.1"), " ")
+	want := "python -m debugpy --listen 127.0.0.1:5678 -m uvicorn src.main:app --host 0.0.0.0 --port 8080"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	s.DebugPort = 9000
+	s.DebugWait = true
+	got = strings.Join(s.DebugCommand(command, "0.0.0.0"), " ")
+	if !strings.HasPrefix(got, "python -m debugpy --listen 0.0.0.0:9000 --wait-for-client -m uvicorn ") {
+		t.Errorf("unexpected command: %s", got)
+	}
+}
+
+func TestServerArgvDebug(t *testing.T) {
+	rt := NewRuntime(NewService())
+	rt.port = 8080
+
+	if got := strings.Join(rt.serverArgv(ServerModeSingle), " "); !strings.HasPrefix(got, "uv run uvicorn ") {
+		t.Errorf("debug off: %s", got)
+	}
+	if rt.debugAddress() != "" {
+		t.Error("debug address advertised with debug off")
+	}
+
+	rt.FastAPI.Settings.Debug = true
+	got := strings.Join(rt.serverArgv(ServerModeSingle), " ")
+	if !strings.HasPrefix(got, "uv run --with debugpy python -m debugpy --listen 127.0.0.1:5678 -m uvicorn ") {
+		t.Errorf("debug on: %s", got)
+	}
+	if rt.debugAddress() != "localhost:5678" {
+		t.Errorf("debug address: %s", rt.debugAddress())
+	}
+}
+
+func TestValidateDebugPort(t *testing.T) {
+	if err := (&Settings{Debug: true, DebugPort: 70000}).ValidateServer(); err == nil {
+		t.Error("out-of-range debug-port accepted")
+	}
+}
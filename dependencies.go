@@ -0,0 +1,121 @@
+package main
+
+// dependencies.go — keeping the venv in sync with pyproject.toml/uv.lock.
+//
+// Init runs `uv sync` when the dependency manifests changed since the last
+// sync (hash cached under cacheLocation). While running, the watcher also
+// covers the manifests: `uv add foo` re-runs the same cached sync in the
+// active runner environment and restarts the server on the new venv.
+
+import (
+	"context"
+	"path"
+	"slices"
+
+	"github.com/codefly-dev/core/builders"
+	"github.com/codefly-dev/core/wool"
+)
+
+// dependencyManifests are the files `uv sync` reads, relative to the source.
+var dependencyManifests = []string{"pyproject.toml", "uv.lock"}
+
+// runtimeWatch is what the Runtime watcher reacts to: the sources plus the
+// dependency manifests.
+var runtimeWatch = builders.NewDependencies(agent.Name,
+	append(slices.Clone(requirements.Components),
+		builders.NewDependency(path.Join("code", dependencyManifests[0])),
+		builders.NewDependency(path.Join("code", dependencyManifests[1])))...)
+
+// isDependencyManifest reports whether a watcher event path (relative to
+// the service) is one of the dependency manifests.
+func isDependencyManifest(p string) bool {
+	for _, manifest := range dependencyManifests {
+		if p == path.Join("code", manifest) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncDependencies runs `uv sync` in the runner environment when the
+// manifests changed since the last successful sync. It reports whether a
+// sync ran.
+func (s *Runtime) syncDependencies(ctx context.Context) (bool, error) {
+	var components []*builders.Dependency
+	for _, manifest := range dependencyManifests {
+		components = append(components, builders.NewDependency(path.Join(s.Service.SourceLocation, manifest)))
+	}
+	deps := builders.NewDependencies("uv", components...).WithCache(s.cacheLocation)
+
+	updated, err := deps.Updated(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !updated {
+		return false, nil
+	}
+
+	s.Infof("syncing uv environment")
+	proc, err := s.runnerEnvironment.NewProcess("uv", "sync")
+	if err != nil {
+		return false, s.Wool.Wrapf(err, "cannot create uv sync process")
+	}
+	proc.WithDir(s.Service.SourceLocation)
+	proc.WithOutput(s.Logger)
+	if err := proc.Run(ctx); err != nil {
+		return false, s.Wool.Wrapf(err, "cannot run uv sync")
+	}
+	if err := deps.UpdateCache(ctx); err != nil {
+		return true, s.Wool.Wrapf(err, "cannot update cache")
+	}
+	return true, nil
+}
+
+// onDependencyChange re-syncs the venv and restarts the server on it. A
+// failed sync keeps the current server running on the previous venv.
+func (s *Runtime) onDependencyChange(ctx context.Context) {
+	synced, err := s.syncDependencies(ctx)
+	if err != nil {
+		s.Wool.Error("uv sync failed, keeping the running server", wool.ErrField(err))
+		return
+	}
+	if !synced {
+		return
+	}
+	if err := s.restart(ctx); err != nil {
+		s.Wool.Error("cannot restart fastapi app after uv sync", wool.ErrField(err))
+		s.Base.Runtime.MarkRunnerExited(err)
+		return
+	}
+	s.Infof("restarted fastapi app with updated dependencies")
+}
+
+// restart replaces the running server with a fresh process. Nothing is
+// started when no server is running. It runs from EventHandler, under the
+// lifecycle operation lock, so Stop cannot interleave with it.
+func (s *Runtime) restart(ctx context.Context) error {
+	mode, err := s.FastAPI.Settings.Mode()
+	if err != nil {
+		return err
+	}
+	runner := s.setRunner(nil)
+	if runner == nil {
+		return nil
+	}
+	report, err := s.shutdown(ctx, runner)
+	if err != nil {
+		return s.Wool.Wrapf(err, "cannot stop server")
+	}
+	s.Wool.Debug("server stopped for restart", wool.Field("shutdown", report.Message))
+
+	launch, err := s.newLaunch(mode)
+	if err != nil {
+		return err
+	}
+	proc, tail, err := launch.launch(ctx)
+	if err != nil {
+		return s.Wool.Wrapf(err, "fastapi app did not become ready")
+	}
+	s.supervise(launch, proc, tail)
+	return nil
+}
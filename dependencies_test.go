@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsDependencyManifest(t *testing.T) {
+	cases := map[string]bool{
+		"code/pyproject.toml":     true,
+		"code/uv.lock":            true,
+		"code/src/main.py":        false,
+		"code/src/pyproject.toml": false,
+		"service.codefly.yaml":    false,
+	}
+	for p, want := range cases {
+		if got := isDependencyManifest(p); got != want {
+			t.Errorf("%s: got %v, want %v", p, got, want)
+		}
+	}
+}
+
+// TestRuntimeWatchCoversManifests: the runtime watcher adds the manifests
+// without touching the build requirements.
+func TestRuntimeWatchCoversManifests(t *testing.T) {
+	watched := map[string]bool{}
+	for _, c := range runtimeWatch.All() {
+		watched[c] = true
+	}
+	for _, want := range []string{"code/src", "code/pyproject.toml", "code/uv.lock"} {
+		if !watched[want] {
+			t.Errorf("runtime watcher does not cover %s", want)
+		}
+	}
+	if got := requirements.All(); len(got) != 1 || got[0] != "code/src" {
+		t.Errorf("build requirements changed: %v", got)
+	}
+}
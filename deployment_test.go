@@ -1,11 +1,138 @@
 package main
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	agenttesting "github.com/codefly-dev/core/agents/testing"
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/templates"
 )
 
 func TestDeploymentTemplates(t *testing.T) {
-	agenttesting.AssertKustomizeTemplates(t, deploymentFS, Parameters{})
+	agenttesting.AssertKustomizeTemplates(t, deploymentFS, Parameters{Port: 8080, ServicePort: 8080})
+}
+
+func TestDeploymentTemplatesPorts(t *testing.T) {
+	rendered := renderDeployment(t, Parameters{Port: 8080, ServicePort: 80, Metrics: true})
+	for _, want := range []string{"containerPort: 8080", "port: 80\n      targetPort: http", `prometheus.io/port: "8080"`} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("rendered manifests miss %q:\n%s", want, rendered)
+		}
+	}
+}
+
+func TestServicePort(t *testing.T) {
+	ctx := context.Background()
+	builder := NewBuilder(newTestTooling(t).FastAPI)
+	endpoint := &basev0.Endpoint{Module: "mod", Service: "api", Name: "rest", Api: standards.REST}
+	builder.FastAPI.RestEndpoint = endpoint
+	if got := builder.servicePort(ctx, &builderv0.DeploymentRequest{}); got != 8080 {
+		t.Errorf("without mapping: got %d", got)
+	}
+
+	instance := resources.NewHTTPNetworkInstance("api.mod.svc.cluster.local", 80, false)
+	instance.Access = resources.NewContainerNetworkAccess()
+	req := &builderv0.DeploymentRequest{NetworkMappings: []*basev0.NetworkMapping{{Endpoint: endpoint, Instances: []*basev0.NetworkInstance{instance}}}}
+	if got := builder.servicePort(ctx, req); got != 80 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestDeploymentTemplatesWithMetrics(t *testing.T) {
+	rendered := renderDeployment(t, Parameters{Port: 8080, Metrics: true, MetricsPort: 9464})
+	for _, want := range []string{`prometheus.io/scrape: "true"`, "name: metrics\n      port: 9464\n      targetPort: http"} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("rendered manifests miss %q", want)
+		}
+	}
+}
+
+func TestMetricsPort(t *testing.T) {
+	ctx := context.Background()
+	builder := NewBuilder(newTestTooling(t).FastAPI)
+	if got := builder.mappedPort(ctx, &builderv0.DeploymentRequest{}, nil); got != 8080 {
+		t.Errorf("without endpoint: got %d", got)
+	}
+	endpoint := &basev0.Endpoint{Module: "mod", Service: "api", Name: metricsEndpointName, Api: standards.HTTP}
+	instance := resources.NewHTTPNetworkInstance("api.mod.svc.cluster.local", 9464, false)
+	instance.Access = resources.NewContainerNetworkAccess()
+	req := &builderv0.DeploymentRequest{NetworkMappings: []*basev0.NetworkMapping{{Endpoint: endpoint, Instances: []*basev0.NetworkInstance{instance}}}}
+	if got := builder.mappedPort(ctx, req, endpoint); got != 9464 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestFactoryTemplatesMetrics(t *testing.T) {
+	ctx := context.Background()
+	render := func(file string, metrics bool) string {
+		t.Helper()
+		out, err := templates.ApplyTemplateFrom(ctx, shared.Embed(factoryFS), "templates/factory/code/"+file, CreateConfiguration{Metrics: metrics})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	for file, want := range map[string]string{"src/main.py": "instrument_metrics(app)", "pyproject.toml": `"prometheus-client`} {
+		if !strings.Contains(render(file, true), want) {
+			t.Errorf("%s misses %q with metrics", file, want)
+		}
+		if strings.Contains(render(file, false), want) {
+			t.Errorf("%s has %q without metrics", file, want)
+		}
+	}
+}
+
+func TestFactoryTemplatesTracing(t *testing.T) {
+	ctx := context.Background()
+	for _, tracing := range []bool{true, false} {
+		out, err := templates.ApplyTemplateFrom(ctx, shared.Embed(factoryFS), "templates/factory/code/pyproject.toml", CreateConfiguration{Tracing: tracing})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pkg := range otelPackages {
+			if strings.Contains(out, `"`+pkg+`>=`) != tracing {
+				t.Errorf("tracing %v: %s in pyproject.toml:\n%s", tracing, pkg, out)
+			}
+		}
+	}
+}
+
+func TestDeploymentTemplatesWithMigrations(t *testing.T) {
+	job := &MigrationJob{Name: "example-service-migrations-1-2-3", Command: []string{"python", migrationsScript, "upgrade"}}
+	rendered := renderDeployment(t, Parameters{Migrations: job})
+	for _, want := range []string{"kind: Job", "name: example-service-migrations-1-2-3", `- "src/migrations.py"`, "- migrations.yaml"} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("rendered manifests miss %q", want)
+		}
+	}
+	if strings.Contains(renderDeployment(t, Parameters{}), "kind: Job") {
+		t.Error("Job rendered without migrations")
+	}
+}
+
+// renderDeployment renders the manifests and returns them concatenated.
+func renderDeployment(t *testing.T, params Parameters) string {
+	t.Helper()
+	destination := agenttesting.AssertKustomizeTemplates(t, deploymentFS, params)
+	var rendered strings.Builder
+	err := filepath.WalkDir(destination, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		rendered.Write(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered.String()
 }
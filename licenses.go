@@ -0,0 +1,446 @@
+package main
+
+// licenses.go — license report of the synced venv, and its policy.
+//
+// The packages are read where they are installed: a short script lists
+// every distribution of the venv through the active runner environment
+// (native, nix or Docker) with its License-Expression, License field and
+// license classifiers. Each package gets an SPDX-like license, then the
+// licenses policy is applied: a package is denied when every license it
+// offers has a denied term, and not allowed, with an allow list, when none
+// is fully allowed. The report is kept in .cache/licenses.json under the
+// source; the licenses command prints it, and any violation fails Build.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+)
+
+// licenseReportPath is relative to the source location.
+const licenseReportPath = ".cache/licenses.json"
+
+// unknownLicense is reported for packages without license metadata.
+const unknownLicense = "UNKNOWN"
+
+// Licenses is the license policy (licenses setting). Entries are SPDX
+// identifiers, matched case-insensitively, and may be globs.
+//
+//	licenses:
+//	  allow: [MIT, BSD-*, Apache-2.0, ISC, PSF-2.0]
+//	  deny: [GPL-*, AGPL-*]
+//	  exceptions: [certifi]    # packages the policy skips
+type Licenses struct {
+	Allow      []string `yaml:"allow"`
+	Deny       []string `yaml:"deny"`
+	Exceptions []string `yaml:"exceptions"`
+}
+
+// Validate rejects malformed globs.
+func (l *Licenses) Validate() error {
+	if l == nil {
+		return nil
+	}
+	for _, pattern := range slices.Concat(l.Allow, l.Deny) {
+		if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+			return fmt.Errorf("licenses: invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// enforced reports a policy to apply.
+func (l *Licenses) enforced() bool {
+	return l != nil && len(l.Allow)+len(l.Deny) > 0
+}
+
+// packageLicense is one package of the report.
+type packageLicense struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// License is an SPDX expression, or UNKNOWN.
+	License string `json:"license"`
+	// Source is where License comes from: expression, license, classifier.
+	Source string `json:"source,omitempty"`
+	// Local packages (the project, editable installs) are not checked.
+	Local bool `json:"local,omitempty"`
+	// Violation is why the policy rejects it: denied or not allowed.
+	Violation string `json:"violation,omitempty"`
+}
+
+// licenseReport is the report kept in .cache/licenses.json.
+type licenseReport struct {
+	Packages []*packageLicense `json:"packages"`
+}
+
+// Violations are the packages rejected by the policy.
+func (r *licenseReport) Violations() []*packageLicense {
+	var violations []*packageLicense
+	for _, p := range r.Packages {
+		if p.Violation != "" {
+			violations = append(violations, p)
+		}
+	}
+	return violations
+}
+
+// String is a table of the packages, violations last.
+func (r *licenseReport) String() string {
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tVERSION\tLICENSE\tPOLICY")
+	packages := append([]*packageLicense{}, r.Packages...)
+	sort.SliceStable(packages, func(i, j int) bool {
+		return packages[i].Violation == "" && packages[j].Violation != ""
+	})
+	for _, p := range packages {
+		policy := "ok"
+		switch {
+		case p.Violation != "":
+			policy = p.Violation
+		case p.Local:
+			policy = "local"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, p.Version, p.License, policy)
+	}
+	_ = w.Flush()
+	fmt.Fprintf(&out, "%d package(s), %d violation(s)", len(r.Packages), len(r.Violations()))
+	return out.String()
+}
+
+// distributionScript prints one JSON line per distribution of the venv.
+const distributionScript = `import json
+from importlib import metadata
+
+for dist in metadata.distributions():
+    meta = dist.metadata
+    try:
+        direct = json.loads(dist.read_text("direct_url.json") or "{}")
+    except ValueError:
+        direct = {}
+    print(json.dumps({
+        "name": meta["Name"],
+        "version": dist.version,
+        "expression": meta.get("License-Expression"),
+        "license": meta.get("License"),
+        "classifiers": [c for c in (meta.get_all("Classifier") or []) if c.startswith("License ::")],
+        "editable": bool(direct.get("dir_info", {}).get("editable")),
+    }))
+`
+
+// distribution is a line printed by distributionScript.
+type distribution struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Expression  string   `json:"expression"`
+	License     string   `json:"license"`
+	Classifiers []string `json:"classifiers"`
+	Editable    bool     `json:"editable"`
+}
+
+// parseDistributions reads the JSON lines of distributionScript, skipping
+// the rest of the output (uv's own messages).
+func parseDistributions(output []byte) []distribution {
+	var distributions []distribution
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		var d distribution
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &d) != nil || d.Name == "" {
+			continue
+		}
+		// A distribution installed twice is listed twice.
+		if key := normalizePackageName(d.Name); !seen[key] {
+			seen[key] = true
+			distributions = append(distributions, d)
+		}
+	}
+	return distributions
+}
+
+// Licenses lists the license of every package of the synced venv, and
+// applies the policy.
+func (s *Service) Licenses(ctx context.Context, env runners.RunnerEnvironment) (*licenseReport, error) {
+	// --no-sync: report the venv as it is.
+	proc, err := env.NewProcess("uv", "run", "--no-sync", "python", "-c", distributionScript)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create license listing process")
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	proc.WithDir(s.Service.SourceLocation)
+	runErr := proc.Run(ctx)
+	distributions := parseDistributions(output.Bytes())
+	if runErr == nil && len(distributions) == 0 {
+		runErr = fmt.Errorf("no package listed")
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("cannot list the packages of the venv (run uv sync): %w: %s", runErr, lastLines(output.String(), 20))
+	}
+
+	project := projectName(s.Service.SourceLocation)
+	report := &licenseReport{}
+	for _, d := range distributions {
+		license, source := resolveLicense(d)
+		p := &packageLicense{
+			Name:    d.Name,
+			Version: d.Version,
+			License: license,
+			Source:  source,
+			Local:   d.Editable || normalizePackageName(d.Name) == project,
+		}
+		if !p.Local {
+			p.Violation = s.Settings.Licenses.check(p)
+		}
+		report.Packages = append(report.Packages, p)
+	}
+	sort.Slice(report.Packages, func(i, j int) bool {
+		return normalizePackageName(report.Packages[i].Name) < normalizePackageName(report.Packages[j].Name)
+	})
+	s.Wool.Debug("licenses", wool.Field("packages", len(report.Packages)), wool.Field("violations", len(report.Violations())))
+	return report, nil
+}
+
+// saveLicenseReport writes the report to .cache/licenses.json.
+func (s *Service) saveLicenseReport(report *licenseReport) error {
+	file := path.Join(s.Service.SourceLocation, licenseReportPath)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(content, '\n'), 0o644)
+}
+
+// projectName is the normalized name of pyproject.toml's project.
+func projectName(sourceLocation string) string {
+	content, err := os.ReadFile(path.Join(sourceLocation, "pyproject.toml"))
+	if err != nil {
+		return ""
+	}
+	inProject := false
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inProject = line == "[project]"
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); inProject && ok && strings.TrimSpace(key) == "name" {
+			return normalizePackageName(strings.Trim(strings.TrimSpace(value), `"'`))
+		}
+	}
+	return ""
+}
+
+// resolveLicense picks, in order, the License-Expression (PEP 639), a
+// short License field, and the license classifiers (as alternatives).
+func resolveLicense(d distribution) (license string, source string) {
+	if expression := strings.TrimSpace(d.Expression); expression != "" {
+		return expression, "expression"
+	}
+	// The field often holds the whole license text: only a name is used.
+	if field := strings.TrimSpace(d.License); field != "" && !strings.Contains(field, "\n") && len(field) <= 80 && !strings.EqualFold(field, unknownLicense) {
+		return normalizeLicense(field), "license"
+	}
+	var alternatives []string
+	for _, classifier := range d.Classifiers {
+		parts := strings.Split(classifier, " :: ")
+		name := normalizeLicense(parts[len(parts)-1])
+		if name != "" && !strings.EqualFold(name, "OSI Approved") && !containsFold(alternatives, name) {
+			alternatives = append(alternatives, name)
+		}
+	}
+	if len(alternatives) > 0 {
+		return strings.Join(alternatives, " OR "), "classifier"
+	}
+	return unknownLicense, ""
+}
+
+// licenseAliases maps the names found in License fields and classifiers
+// to SPDX identifiers.
+var licenseAliases = map[string]string{
+	"mit":                                   "MIT",
+	"mit license":                           "MIT",
+	"bsd":                                   "BSD-3-Clause",
+	"bsd license":                           "BSD-3-Clause",
+	"new bsd":                               "BSD-3-Clause",
+	"new bsd license":                       "BSD-3-Clause",
+	"bsd 3-clause":                          "BSD-3-Clause",
+	"bsd-3":                                 "BSD-3-Clause",
+	"bsd 2-clause":                          "BSD-2-Clause",
+	"apache":                                "Apache-2.0",
+	"apache 2":                              "Apache-2.0",
+	"apache 2.0":                            "Apache-2.0",
+	"apache-2":                              "Apache-2.0",
+	"apache license 2.0":                    "Apache-2.0",
+	"apache license, version 2.0":           "Apache-2.0",
+	"apache software license":               "Apache-2.0",
+	"apache software license 2.0":           "Apache-2.0",
+	"isc":                                   "ISC",
+	"isc license":                           "ISC",
+	"isc license (iscl)":                    "ISC",
+	"python software foundation license":    "PSF-2.0",
+	"psf":                                   "PSF-2.0",
+	"mozilla public license 2.0 (mpl 2.0)":  "MPL-2.0",
+	"mpl 2.0":                               "MPL-2.0",
+	"gnu general public license v2 (gplv2)": "GPL-2.0-only",
+	"gnu general public license v2 or later (gplv2+)":         "GPL-2.0-or-later",
+	"gnu general public license v3 (gplv3)":                   "GPL-3.0-only",
+	"gnu general public license v3 or later (gplv3+)":         "GPL-3.0-or-later",
+	"gnu lesser general public license v2 (lgplv2)":           "LGPL-2.0-only",
+	"gnu lesser general public license v2 or later (lgplv2+)": "LGPL-2.0-or-later",
+	"gnu lesser general public license v3 (lgplv3)":           "LGPL-3.0-only",
+	"gnu lesser general public license v3 or later (lgplv3+)": "LGPL-3.0-or-later",
+	"gnu affero general public license v3":                    "AGPL-3.0-only",
+	"gnu affero general public license v3 or later (agplv3+)": "AGPL-3.0-or-later",
+	"the unlicense (unlicense)":                               "Unlicense",
+	"public domain":                                           "LicenseRef-Public-Domain",
+}
+
+func normalizeLicense(name string) string {
+	if spdx, ok := licenseAliases[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return spdx
+	}
+	return strings.TrimSpace(name)
+}
+
+// check returns why the policy rejects the package, or "".
+func (l *Licenses) check(p *packageLicense) string {
+	if !l.enforced() || containsFold(l.Exceptions, p.Name) {
+		return ""
+	}
+	alternatives := licenseAlternatives(p.License)
+	denied, allowed := len(alternatives) > 0, false
+	for _, alternative := range alternatives {
+		alternativeDenied, alternativeAllowed := false, true
+		for _, term := range alternative {
+			if matchesLicense(l.Deny, term) {
+				alternativeDenied = true
+			}
+			if len(l.Allow) > 0 && !matchesLicense(l.Allow, term) {
+				alternativeAllowed = false
+			}
+		}
+		denied = denied && alternativeDenied
+		allowed = allowed || (alternativeAllowed && !alternativeDenied)
+	}
+	switch {
+	case denied:
+		return "denied"
+	case !allowed:
+		return "not allowed"
+	}
+	return ""
+}
+
+// licenseAlternatives splits an SPDX expression into the alternatives of
+// its OR, each the terms of its AND. Grouping is flattened and WITH
+// exceptions dropped: this is a policy check, not an SPDX parser.
+func licenseAlternatives(expression string) [][]string {
+	expression = strings.NewReplacer("(", " ", ")", " ").Replace(expression)
+	var alternatives [][]string
+	for _, alternative := range splitFold(expression, " or ") {
+		var terms []string
+		for _, term := range splitFold(alternative, " and ") {
+			term, _, _ = strings.Cut(strings.TrimSpace(term), " WITH ")
+			if term = strings.TrimSpace(term); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) > 0 {
+			alternatives = append(alternatives, terms)
+		}
+	}
+	return alternatives
+}
+
+// splitFold splits on sep, ignoring case.
+func splitFold(s string, sep string) []string {
+	var parts []string
+	lower := strings.ToLower(s)
+	for {
+		i := strings.Index(lower, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s, lower = s[i+len(sep):], lower[i+len(sep):]
+	}
+}
+
+func matchesLicense(patterns []string, license string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(license)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) || normalizePackageName(v) == normalizePackageName(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLicenses is the Build gate: with a policy, it reports the licenses
+// and fails on violations.
+func (s *Builder) checkLicenses(ctx context.Context) error {
+	if !s.FastAPI.Settings.Licenses.enforced() {
+		return nil
+	}
+	report, err := s.FastAPI.Licenses(ctx, s.FastAPI.serviceEnvironment(ctx))
+	if err != nil {
+		return err
+	}
+	if err := s.FastAPI.saveLicenseReport(report); err != nil {
+		s.Wool.Warn("cannot save license report", wool.ErrField(err))
+	}
+	violations := report.Violations()
+	for _, p := range violations {
+		s.Wool.Warn("license policy violation", wool.Field("package", p.Name+" "+p.Version), wool.Field("license", p.License), wool.Field("violation", p.Violation))
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d package(s) violate the license policy; see %s", len(violations), path.Join("code", licenseReportPath))
+	}
+	return nil
+}
+
+func (s *Builder) registerLicenseCommands() {
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "licenses",
+		Description: "List the license of every package of the synced venv, checked against the licenses policy, and save the report to code/.cache/licenses.json.",
+		Usage:       "licenses",
+		Tags:        []string{"audit", "licenses"},
+	}, s.cmdLicenses)
+}
+
+func (s *Builder) cmdLicenses(ctx context.Context, args []string) (string, error) {
+	if len(args) > 0 {
+		return "", fmt.Errorf("usage: licenses")
+	}
+	report, err := s.FastAPI.Licenses(ctx, s.FastAPI.serviceEnvironment(ctx))
+	if err != nil {
+		return "", err
+	}
+	if err := s.FastAPI.saveLicenseReport(report); err != nil {
+		return "", err
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveLicense(t *testing.T) {
+	for _, c := range []struct {
+		d      distribution
+		want   string
+		source string
+	}{
+		{distribution{Expression: "MIT OR Apache-2.0", License: "MIT"}, "MIT OR Apache-2.0", "expression"},
+		{distribution{License: "Apache License, Version 2.0"}, "Apache-2.0", "license"},
+		{distribution{License: "Copyright (c) 2018\n\nPermission is hereby granted", Classifiers: []string{"License :: OSI Approved :: MIT License"}}, "MIT", "classifier"},
+		{distribution{License: "UNKNOWN", Classifiers: []string{"License :: OSI Approved :: BSD License", "License :: OSI Approved :: Apache Software License", "License :: OSI Approved"}}, "BSD-3-Clause OR Apache-2.0", "classifier"},
+		{distribution{}, unknownLicense, ""},
+	} {
+		if license, source := resolveLicense(c.d); license != c.want || source != c.source {
+			t.Errorf("%v: got %q from %q", c.d, license, source)
+		}
+	}
+}
+
+func TestLicensePolicy(t *testing.T) {
+	policy := &Licenses{Allow: []string{"MIT", "bsd-*", "Apache-2.0"}, Deny: []string{"GPL-*", "AGPL-*"}, Exceptions: []string{"Legacy_Lib"}}
+	for license, want := range map[string]string{
+		"MIT":                            "",
+		"BSD-3-Clause":                   "",
+		"MIT OR GPL-3.0-only":            "",
+		"(Apache-2.0 AND MIT)":           "",
+		"Apache-2.0 WITH LLVM-exception": "",
+		"GPL-3.0-or-later":               "denied",
+		"MIT AND GPL-2.0-only":           "denied",
+		"MPL-2.0":                        "not allowed",
+		"MPL-2.0 OR GPL-3.0-only":        "not allowed",
+		unknownLicense:                   "not allowed",
+	} {
+		if got := policy.check(&packageLicense{Name: "lib", License: license}); got != want {
+			t.Errorf("%s: got %q, want %q", license, got, want)
+		}
+	}
+	if got := policy.check(&packageLicense{Name: "legacy-lib", License: "GPL-3.0-only"}); got != "" {
+		t.Errorf("exception checked: %q", got)
+	}
+
+	// Deny only: anything not denied passes, including unknown licenses.
+	denyOnly := &Licenses{Deny: []string{"AGPL-*"}}
+	if got := denyOnly.check(&packageLicense{License: unknownLicense}); got != "" {
+		t.Errorf("got %q", got)
+	}
+	if (&Licenses{}).enforced() || (&Settings{Licenses: &Licenses{Allow: []string{"[MIT"}}}).Validate() == nil {
+		t.Error("got a policy from nothing, or accepted a malformed pattern")
+	}
+}
+
+const distributionsSample = `Installed 1 package in 2ms
+{"name": "api", "version": "0.1.0", "expression": null, "license": null, "classifiers": [], "editable": true}
+{"name": "fastapi", "version": "0.115.0", "expression": null, "license": null, "classifiers": ["License :: OSI Approved :: MIT License"], "editable": false}
+{"name": "paramiko", "version": "3.5.0", "expression": null, "license": "LGPL", "classifiers": ["License :: OSI Approved :: GNU Library or Lesser General Public License (LGPL)"], "editable": false}
+{"name": "Starlette", "version": "0.38.6", "expression": "BSD-3-Clause", "license": null, "classifiers": [], "editable": false}
+{"name": "starlette", "version": "0.38.6", "expression": "BSD-3-Clause", "license": null, "classifiers": [], "editable": false}
+`
+
+func TestLicenses(t *testing.T) {
+	ctx := context.Background()
+	args := fakeUV(t, distributionsSample, 0)
+	tooling := newTestTooling(t)
+	svc := tooling.FastAPI
+	builder := NewBuilder(svc)
+
+	// Without a policy the report is informational and Build is not gated.
+	out, err := builder.cmdLicenses(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out, "4 package(s), 0 violation(s)") || !strings.Contains(out, "api") || !strings.Contains(out, "local") {
+		t.Errorf("got %s", out)
+	}
+	if content, _ := os.ReadFile(args); !strings.HasPrefix(string(content), "run --no-sync python -c import json") {
+		t.Errorf("ran uv %s", content)
+	}
+	if err := builder.checkLicenses(ctx); err != nil {
+		t.Error(err)
+	}
+
+	svc.Settings.Licenses = &Licenses{Allow: []string{"MIT", "BSD-*"}}
+	report, err := svc.Licenses(ctx, svc.serviceEnvironment(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := report.Violations()
+	if len(violations) != 1 || violations[0].Name != "paramiko" || violations[0].License != "LGPL" || violations[0].Violation != "not allowed" {
+		t.Errorf("got %v", violations)
+	}
+	if err := builder.checkLicenses(ctx); err == nil || !strings.HasPrefix(err.Error(), "1 package(s) violate the license policy") {
+		t.Errorf("got %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(svc.Service.SourceLocation, licenseReportPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved licenseReport
+	if err := json.Unmarshal(content, &saved); err != nil || len(saved.Packages) != 4 || len(saved.Violations()) != 1 {
+		t.Errorf("got %s", content)
+	}
+
+	fakeUV(t, "error: No virtual environment found\n", 2)
+	if _, err := svc.Licenses(ctx, svc.serviceEnvironment(ctx)); err == nil || !strings.Contains(err.Error(), "No virtual environment found") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestProjectName(t *testing.T) {
+	dir := t.TempDir()
+	pyproject := "[build-system]\nname = \"hatch\"\n\n[project]\nname = \"My_API\"\nversion = \"0.1.0\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "pyproject.toml"), []byte(pyproject), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := projectName(dir); got != "my-api" {
+		t.Errorf("got %q", got)
+	}
+}
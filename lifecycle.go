@@ -0,0 +1,164 @@
+package main
+
+// lifecycle.go — the Runtime state machine.
+//
+// codefly drives the agent through Load → Init → Start → Stop → Destroy,
+// but nothing stops a misbehaving caller from sending Start before Init, or
+// Init while the app is running; meanwhile the watcher restarts the server
+// from its own goroutine. Every lifecycle RPC and every watcher action runs
+// under one operation lock, after checking the transition is allowed in the
+// current state. A rejected transition is a FailedPrecondition gRPC status,
+// so callers can tell a protocol error from a failing app. Watcher events
+// outside Running are dropped.
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LifecycleState is where the Runtime is in its lifecycle.
+type LifecycleState int
+
+const (
+	StateNew LifecycleState = iota
+	StateLoaded
+	StateInitialized
+	StateRunning
+	StateStopped
+	StateDestroyed
+)
+
+func (st LifecycleState) String() string {
+	switch st {
+	case StateNew:
+		return "new"
+	case StateLoaded:
+		return "loaded"
+	case StateInitialized:
+		return "initialized"
+	case StateRunning:
+		return "running"
+	case StateStopped:
+		return "stopped"
+	case StateDestroyed:
+		return "destroyed"
+	}
+	return "unknown"
+}
+
+// lifecycleOperation names a lifecycle RPC.
+type lifecycleOperation string
+
+const (
+	opLoad    lifecycleOperation = "Load"
+	opInit    lifecycleOperation = "Init"
+	opStart   lifecycleOperation = "Start"
+	opStop    lifecycleOperation = "Stop"
+	opDestroy lifecycleOperation = "Destroy"
+	opTest    lifecycleOperation = "Test"
+)
+
+// lifecycleTransitions maps each operation to the states it is allowed in
+// and the state it leads to on success. A missing state is a rejection.
+var lifecycleTransitions = map[lifecycleOperation]map[LifecycleState]LifecycleState{
+	// Load re-reads the service; codefly reloads a running service when
+	// its endpoints change (OpenAPI refresh), which keeps it running.
+	opLoad: {
+		StateNew:         StateLoaded,
+		StateLoaded:      StateLoaded,
+		StateInitialized: StateLoaded,
+		StateRunning:     StateRunning,
+		StateStopped:     StateLoaded,
+		StateDestroyed:   StateLoaded,
+	},
+	opInit: {
+		StateLoaded:      StateInitialized,
+		StateInitialized: StateInitialized,
+		StateStopped:     StateInitialized,
+	},
+	// Start while running restarts the server.
+	opStart: {
+		StateInitialized: StateRunning,
+		StateRunning:     StateRunning,
+	},
+	// Stop is idempotent, and harmless before anything was started.
+	opStop: {
+		StateLoaded:      StateLoaded,
+		StateInitialized: StateStopped,
+		StateRunning:     StateStopped,
+		StateStopped:     StateStopped,
+		StateDestroyed:   StateDestroyed,
+	},
+	opDestroy: {
+		StateLoaded:      StateDestroyed,
+		StateInitialized: StateDestroyed,
+		StateStopped:     StateDestroyed,
+		StateDestroyed:   StateDestroyed,
+	},
+	// Test only holds the lock to set its run up (pytest.go), and needs
+	// the runner environment of Init.
+	opTest: {
+		StateInitialized: StateInitialized,
+		StateRunning:     StateRunning,
+		StateStopped:     StateStopped,
+	},
+}
+
+// lifecycle holds the state. op serializes operations (RPCs and watcher
+// actions); mu only guards state, so State never waits on an operation.
+type lifecycle struct {
+	op    sync.Mutex
+	mu    sync.Mutex
+	state LifecycleState
+}
+
+// State is the current state.
+func (l *lifecycle) State() LifecycleState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+func (l *lifecycle) set(st LifecycleState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = st
+}
+
+// begin takes the operation lock for op, or rejects it in the current
+// state with a FailedPrecondition status. On success the caller must call
+// end.
+func (l *lifecycle) begin(op lifecycleOperation) error {
+	l.op.Lock()
+	current := l.State()
+	if _, ok := lifecycleTransitions[op][current]; !ok {
+		l.op.Unlock()
+		return status.Errorf(codes.FailedPrecondition, "%s is not allowed while the service is %s", op, current)
+	}
+	return nil
+}
+
+// end moves to the state op leads to when it succeeded, then releases the
+// operation lock. A failed operation leaves the state unchanged.
+func (l *lifecycle) end(op lifecycleOperation, succeeded bool) {
+	defer l.op.Unlock()
+	if !succeeded {
+		return
+	}
+	l.set(lifecycleTransitions[op][l.State()])
+}
+
+// whileRunning runs fn under the operation lock if the service is running,
+// and reports whether it did. Stop waits for an fn in progress; fn never
+// starts after Stop.
+func (l *lifecycle) whileRunning(fn func()) bool {
+	l.op.Lock()
+	defer l.op.Unlock()
+	if l.State() != StateRunning {
+		return false
+	}
+	fn()
+	return true
+}
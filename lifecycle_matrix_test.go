@@ -16,7 +16,7 @@ import (
 // TestPythonFastAPILifecycle_Matrix exercises python-fastapi's parity
 // across native, nix, and docker by running `python3 --version` in each.
 // Uses the same uv-bundled image the runtime uses in container mode
-// (main.go `runtimeImage`). Nix + native rely on host's python3 via PATH
+// (pythonversion.go `pythonReleases`). Nix + native rely on host's python3 via PATH
 // or flake.nix respectively.
 func TestPythonFastAPILifecycle_Matrix(t *testing.T) {
 	dir, err := os.MkdirTemp("", "pyfastapi-matrix-*")
@@ -39,7 +39,7 @@ func TestPythonFastAPILifecycle_Matrix(t *testing.T) {
 	}
 
 	// python:3.12-slim has /usr/local/bin/python3 directly, no entrypoint
-	// wrapper. The runtime (main.go) uses the uv-bundled image for actual
+	// wrapper. The runtime (pythonversion.go) uses the uv-bundled image for actual
 	// service execution; this matrix test just validates toolchain reach
 	// across backends, so a plain python image is the right fit.
 	img := &resources.DockerImage{Name: "python", Tag: "3.12-slim"}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codefly-dev/core/agents/helpers/code"
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	runtimev0 "github.com/codefly-dev/core/generated/go/codefly/services/runtime/v0"
+	"github.com/codefly-dev/core/network"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLifecycleTransitions(t *testing.T) {
+	tcs := []struct {
+		name string
+		from LifecycleState
+		op   lifecycleOperation
+		want LifecycleState
+		ok   bool
+	}{
+		{"load", StateNew, opLoad, StateLoaded, true},
+		{"init", StateLoaded, opInit, StateInitialized, true},
+		{"start", StateInitialized, opStart, StateRunning, true},
+		{"restart", StateRunning, opStart, StateRunning, true},
+		{"reload while running", StateRunning, opLoad, StateRunning, true},
+		{"stop", StateRunning, opStop, StateStopped, true},
+		{"stop twice", StateStopped, opStop, StateStopped, true},
+		{"init after stop", StateStopped, opInit, StateInitialized, true},
+		{"destroy", StateStopped, opDestroy, StateDestroyed, true},
+		{"init before load", StateNew, opInit, StateNew, false},
+		{"start before init", StateLoaded, opStart, StateLoaded, false},
+		{"start after stop", StateStopped, opStart, StateStopped, false},
+		{"init while running", StateRunning, opInit, StateRunning, false},
+		{"destroy while running", StateRunning, opDestroy, StateRunning, false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var l lifecycle
+			l.set(tc.from)
+			err := l.begin(tc.op)
+			if tc.ok != (err == nil) {
+				t.Fatalf("begin %s from %s: %v", tc.op, tc.from, err)
+			}
+			if err == nil {
+				l.end(tc.op, true)
+			}
+			if got := l.State(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestLifecycleFailureKeepsState: a failed operation is no transition.
+func TestLifecycleFailureKeepsState(t *testing.T) {
+	var l lifecycle
+	l.set(StateInitialized)
+	if err := l.begin(opStart); err != nil {
+		t.Fatal(err)
+	}
+	l.end(opStart, false)
+	if got := l.State(); got != StateInitialized {
+		t.Errorf("got %s after a failed Start", got)
+	}
+}
+
+// TestRuntimeRejectsOutOfOrderCalls: protocol errors are FailedPrecondition
+// statuses, returned before the runtime touches anything.
+func TestRuntimeRejectsOutOfOrderCalls(t *testing.T) {
+	ctx := context.Background()
+	rt := NewRuntime(NewService())
+
+	_, err := rt.Start(ctx, &runtimev0.StartRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Start before Init: got %v", err)
+	}
+
+	rt.lifecycle.set(StateRunning)
+	_, err = rt.Init(ctx, &runtimev0.InitRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Init while running: got %v", err)
+	}
+	_, err = rt.Destroy(ctx, &runtimev0.DestroyRequest{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Destroy while running: got %v", err)
+	}
+}
+
+// testLaunch is the launch of rt's server in mode, for supervise.
+func testLaunch(t *testing.T, rt *Runtime, mode ServerMode) *serverLaunch {
+	t.Helper()
+	launch, err := rt.newLaunch(mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return launch
+}
+
+// TestStopRacesWatcherEvents runs Stop against a burst of watcher events,
+// the crash supervisor and Information calls, then Destroy against
+// Information; run with -race. Once Stop returns, nothing runs and no event
+// is handled anymore.
+func TestStopRacesWatcherEvents(t *testing.T) {
+	ctx := context.Background()
+	rt, proc := startShutdownTarget(t, `while true; do sleep 0.1; done`, 1)
+	rt.Location = t.TempDir()
+	rt.FastAPI.Settings.HotReload = true
+	rt.openapiRefresh = newDebouncer(openapiDebounce, func() {})
+	defer rt.openapiRefresh.Stop()
+	rt.supervise(testLaunch(t, rt, ServerModeSingle), proc, newOutputTail(outputTailLines))
+	rt.lifecycle.set(StateRunning)
+	rt.Base.Runtime.StartStatus = &runtimev0.StartStatus{State: runtimev0.StartStatus_STARTED}
+	rt.metrics = newMetricsScraper("http://127.0.0.1:1/metrics", time.Hour)
+	traces, err := startOTLPReceiver(ctx, "127.0.0.1:0", filepath.Join(t.TempDir(), tracesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.traces = traces
+
+	done := make(chan struct{})
+	var informing sync.WaitGroup
+	for range 4 {
+		informing.Add(1)
+		go func() {
+			defer informing.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_, _ = rt.Information(ctx, &runtimev0.InformationRequest{})
+				}
+			}
+		}()
+	}
+
+	events := []string{"code/src/main.py", "code/src/admin/router.py", "service.codefly.yaml", "openapi/api.json"}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = rt.EventHandler(code.Change{Path: events[i%len(events)]})
+		}()
+	}
+	resp, err := rt.Stop(ctx, &runtimev0.StopRequest{})
+	wg.Wait()
+	if err != nil || resp.GetStatus().GetState() != runtimev0.StopStatus_SUCCESS {
+		t.Fatalf("Stop: %v %v", resp, err)
+	}
+	if got := rt.lifecycle.State(); got != StateStopped {
+		t.Errorf("got %s after Stop", got)
+	}
+	_, err = rt.Destroy(ctx, &runtimev0.DestroyRequest{})
+	close(done)
+	informing.Wait()
+	if err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	if got := rt.lifecycle.State(); got != StateDestroyed {
+		t.Errorf("got %s after Destroy", got)
+	}
+	if rt.traces != nil || rt.metrics != nil {
+		t.Error("traces or metrics kept after Destroy")
+	}
+	if rt.currentRunner() != nil {
+		t.Error("runner still set after Stop")
+	}
+	if running, _ := proc.IsRunning(ctx); running {
+		t.Error("server still running after Stop")
+	}
+
+	rt.Base.Runtime.DesiredState = nil
+	_ = rt.EventHandler(code.Change{Path: "service.codefly.yaml"})
+	if rt.Base.Runtime.DesiredState != nil {
+		t.Error("event handled after Stop")
+	}
+}
+
+// TestStopDuringRelaunchBackoff: a crashed server waiting for its relaunch
+// is abandoned by Stop, which returns once its supervisor has, so Destroy
+// cannot meet a relaunch; a Load rewriting the Runtime meanwhile is not
+// read by the supervisor. Run with -race.
+func TestStopDuringRelaunchBackoff(t *testing.T) {
+	ctx := context.Background()
+	rt, proc := startShutdownTarget(t, `exit 1`, 1)
+	rt.Location = t.TempDir()
+	rt.Logger = rt.Wool
+	rt.supervise(testLaunch(t, rt, ServerModeSingle), proc, newOutputTail(outputTailLines))
+	rt.lifecycle.set(StateRunning)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rt.supervisionNote() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("crash not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// What a Load while running rewrites.
+	rt.Wool = wool.Get(ctx).In("reloaded")
+	rt.Logger = rt.Wool
+	rt.FastAPI.Settings.MaxRestarts = 1
+	rt.FastAPI.Settings.Debug = true
+
+	stopping := time.Now()
+	if _, err := rt.Stop(ctx, &runtimev0.StopRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(stopping); elapsed >= restartInitialBackoff {
+		t.Errorf("Stop sat out the backoff: %s", elapsed)
+	}
+	if _, err := rt.Destroy(ctx, &runtimev0.DestroyRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(restartInitialBackoff + 200*time.Millisecond)
+	if rt.currentRunner() != nil {
+		t.Error("server relaunched after Stop")
+	}
+}
+
+// fakeServerUV puts a uv on the PATH that succeeds at everything, lists no
+// migrations, and serves 200 on --port for `uv run uvicorn`.
+func fakeServerUV(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$*" in
+*uvicorn*) ;;
+*migrations.py*) echo 'codefly-migrations: []'; exit 0 ;;
+*) exit 0 ;;
+esac
+while [ "$1" != --port ]; do shift; done
+exec python3 -c '
+import http.server, sys
+class OK(http.server.BaseHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200)
+        self.end_headers()
+        self.wfile.write(b"{}")
+http.server.HTTPServer(("127.0.0.1", int(sys.argv[1])), OK).serve_forever()
+' "$2"
+`
+	if err := os.WriteFile(filepath.Join(dir, "uv"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestDestroyThenRestart: after Destroy, Load → Init → Start builds the
+// environment and its cache again instead of reusing the destroyed one.
+func TestDestroyThenRestart(t *testing.T) {
+	ctx := context.Background()
+	fakeServerUV(t)
+
+	workspace := &resources.Workspace{Name: "test"}
+	dir := t.TempDir()
+	service := resources.Service{Name: "svc", Version: "0.0.0"}
+	if err := service.SaveAtDir(ctx, filepath.Join(dir, "mod/svc")); err != nil {
+		t.Fatal(err)
+	}
+	identity := &basev0.ServiceIdentity{Name: "svc", Version: "0.0.0", Module: "mod", Workspace: workspace.Name,
+		WorkspacePath: dir, RelativeToWorkspace: "mod/svc"}
+	svc := NewService()
+	builder := NewBuilder(svc)
+	if _, err := builder.Load(ctx, &builderv0.LoadRequest{Identity: identity, CreationMode: &builderv0.CreationMode{}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Create(ctx, &builderv0.CreateRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := NewRuntime(svc)
+	env := resources.LocalEnvironment()
+	networkManager, err := network.NewRuntimeManager(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkManager.WithTemporaryPorts()
+
+	run := func() {
+		t.Helper()
+		if _, err := rt.Load(ctx, &runtimev0.LoadRequest{Identity: identity, Environment: shared.Must(env.Proto()), DisableCatch: true}); err != nil {
+			t.Fatal(err)
+		}
+		mappings, err := networkManager.GenerateNetworkMappings(ctx, env, workspace, rt.Identity, rt.Endpoints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		init, err := rt.Init(ctx, &runtimev0.InitRequest{RuntimeContext: resources.NewRuntimeContextNative(), ProposedNetworkMappings: mappings})
+		if err != nil || init.GetStatus().GetState() != runtimev0.InitStatus_READY {
+			t.Fatalf("Init: %v %v", init, err)
+		}
+		start, err := rt.Start(ctx, &runtimev0.StartRequest{})
+		if err != nil || start.GetStatus().GetState() != runtimev0.StartStatus_STARTED {
+			t.Fatalf("Start: %v %v", start, err)
+		}
+		if _, err := rt.Stop(ctx, &runtimev0.StopRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run()
+	first := rt.runnerEnvironment
+	cache := rt.cacheLocation
+	if _, err := rt.Destroy(ctx, &runtimev0.DestroyRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if rt.runnerEnvironment != nil || svc.Service.ActiveEnv != nil {
+		t.Fatal("environment kept after Destroy")
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Fatalf("cache not removed: %v", err)
+	}
+
+	run()
+	if rt.runnerEnvironment == nil || rt.runnerEnvironment == first || svc.Service.ActiveEnv != rt.runnerEnvironment {
+		t.Error("environment not rebuilt")
+	}
+	if rt.cacheLocation != cache {
+		t.Errorf("cache location %q, want %q", rt.cacheLocation, cache)
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Errorf("cache not rebuilt: %v", err)
+	}
+	if _, err := os.Stat(rt.Local(artifactManifestPath)); err != nil {
+		t.Errorf("artifact manifest not rebuilt: %v", err)
+	}
+	if _, err := rt.Destroy(ctx, &runtimev0.DestroyRequest{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSourceChangeRestartsServer: with hot-reload, a source change restarts
+// the modes uvicorn does not reload itself, and refreshes the OpenAPI spec.
+func TestSourceChangeRestartsServer(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []ServerMode{ServerModeSingle, ServerModeReload} {
+		t.Run(string(mode), func(t *testing.T) {
+			fakeServerUV(t)
+			rt, proc := startShutdownTarget(t, `while true; do sleep 0.1; done`, 1)
+			rt.Logger = rt.Wool
+			rt.FastAPI.Settings.HotReload = true
+			rt.FastAPI.Settings.ServerMode = string(mode)
+			rt.Service.SourceLocation = t.TempDir()
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt.port = uint16(listener.Addr().(*net.TCPAddr).Port)
+			rt.address = fmt.Sprintf("http://127.0.0.1:%d", rt.port)
+			listener.Close()
+			refreshed := make(chan struct{}, 1)
+			rt.openapiRefresh = newDebouncer(time.Millisecond, func() { refreshed <- struct{}{} })
+			defer rt.openapiRefresh.Stop()
+			rt.supervise(testLaunch(t, rt, mode), proc, newOutputTail(outputTailLines))
+			rt.lifecycle.set(StateRunning)
+			defer rt.Stop(ctx, &runtimev0.StopRequest{})
+
+			_ = rt.EventHandler(code.Change{Path: "code/src/main.py"})
+			select {
+			case <-refreshed:
+			case <-time.After(5 * time.Second):
+				t.Error("OpenAPI not refreshed")
+			}
+			restarted := rt.currentRunner() != proc
+			if want := mode != ServerModeReload; restarted != want {
+				t.Errorf("restarted: got %v, want %v", restarted, want)
+			}
+			if running, _ := proc.IsRunning(ctx); running == restarted {
+				t.Errorf("previous server running: %v", running)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+// logs.go — structured logging of the app's output.
+//
+// uvicorn and the app write plain text. logAdapter reads it line by line
+// and turns it into wool events, so codefly output can be filtered by
+// level and searched by field:
+//
+//   - level prefixes (uvicorn's "INFO:     ...", Python logging's
+//     "WARNING:root:...", gunicorn's "[...] [pid] [ERROR] ...") map onto
+//     wool levels;
+//   - uvicorn access lines become method/path/status fields, plus latency
+//     when the line carries one (uvicorn's stock format does not);
+//   - a multi-line traceback becomes one ERROR event carrying the file and
+//     line of the failing frame;
+//   - Python warnings keep their file, line and category.
+//
+// Anything else (print output) is forwarded as before.
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	// tracebackIdleFlush emits a traceback cut short (the process died
+	// while printing it) once output goes quiet.
+	tracebackIdleFlush = 500 * time.Millisecond
+	// maxTracebackLines bounds a single traceback event.
+	maxTracebackLines = 200
+)
+
+var (
+	// uvicorn's default formatter, and Python logging's basicConfig.
+	levelPrefix = regexp.MustCompile(`^(TRACE|DEBUG|INFO|WARNING|WARN|ERROR|CRITICAL|FATAL):(?:([\w.]+):)?\s*(.*)$`)
+	// gunicorn: [2024-05-01 10:00:00 +0000] [42] [INFO] Booting worker
+	gunicornPrefix = regexp.MustCompile(`^\[[^\]]+\] \[\d+\] \[(TRACE|DEBUG|INFO|WARNING|ERROR|CRITICAL)\] (.*)$`)
+	// 127.0.0.1:51234 - "GET /items?limit=10 HTTP/1.1" 200 OK
+	accessLine = regexp.MustCompile(`^(\S+) - "([A-Z]+) (\S+) HTTP/[\d.]+" (\d{3})(.*)$`)
+	latency    = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(ms|s)\)?\s*$`)
+	// /app/src/main.py:12: DeprecationWarning: message
+	warningLine = regexp.MustCompile(`^(.+\.py):(\d+): (\w*Warning): (.*)$`)
+	// File "/app/src/admin/router.py", line 12, in version
+	frameLine = regexp.MustCompile(`^\s*File "([^"]+)", line (\d+), in (.+)$`)
+)
+
+const tracebackStart = "Traceback (most recent call last):"
+
+// Chained exceptions print one of these between tracebacks.
+var tracebackChain = []string{
+	"During handling of the above exception, another exception occurred:",
+	"The above exception was the direct cause of the following exception:",
+}
+
+// logAdapter is an io.Writer parsing process output into wool events.
+type logAdapter struct {
+	mu      sync.Mutex
+	out     *wool.Wool
+	partial string
+	trace   []string
+	idle    *time.Timer
+}
+
+func newLogAdapter(out *wool.Wool) *logAdapter {
+	return &logAdapter{out: out}
+}
+
+func (a *logAdapter) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	text := a.partial + string(p)
+	lines := strings.Split(text, "\n")
+	// Runners write one line at a time, not always newline-terminated: only
+	// hold back a tail when a chunk visibly stops mid-line.
+	a.partial = ""
+	if len(lines) > 1 {
+		a.partial = lines[len(lines)-1]
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		a.line(strings.TrimRight(line, "\r"))
+	}
+	if len(a.trace) > 0 {
+		if a.idle != nil {
+			a.idle.Stop()
+		}
+		a.idle = time.AfterFunc(tracebackIdleFlush, a.Flush)
+	}
+	return len(p), nil
+}
+
+// Flush emits whatever is pending.
+func (a *logAdapter) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.partial != "" {
+		a.line(a.partial)
+		a.partial = ""
+	}
+	a.flushTraceback("")
+}
+
+func (a *logAdapter) line(line string) {
+	if len(a.trace) > 0 {
+		if a.continuesTraceback(line) {
+			a.trace = append(a.trace, line)
+			if len(a.trace) >= maxTracebackLines {
+				a.flushTraceback("")
+			}
+			return
+		}
+		// The first unindented line closes the traceback: the exception.
+		a.flushTraceback(line)
+		return
+	}
+	if strings.HasSuffix(line, tracebackStart) || isTracebackChain(line) {
+		a.trace = append(a.trace, line)
+		return
+	}
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	a.emit(line)
+}
+
+func (a *logAdapter) continuesTraceback(line string) bool {
+	return line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") ||
+		line == tracebackStart || isTracebackChain(line)
+}
+
+func isTracebackChain(line string) bool {
+	for _, marker := range tracebackChain {
+		if line == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// flushTraceback emits the pending traceback as one error event. exception
+// is its closing "Type: message" line, empty when it was cut short.
+func (a *logAdapter) flushTraceback(exception string) {
+	if len(a.trace) == 0 {
+		return
+	}
+	lines := a.trace
+	a.trace = nil
+	if a.idle != nil {
+		a.idle.Stop()
+		a.idle = nil
+	}
+
+	fields := []*wool.LogField{}
+	// The failing frame is the innermost one: the last File line.
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := frameLine.FindStringSubmatch(lines[i]); m != nil {
+			fields = append(fields, wool.Field("file", m[1]), wool.Field("line", atoi(m[2])), wool.Field("function", m[3]))
+			break
+		}
+	}
+	message := exception
+	if message == "" {
+		message = "traceback (truncated)"
+	} else {
+		lines = append(lines, exception)
+		kind, _, _ := strings.Cut(exception, ":")
+		fields = append(fields, wool.Field("exception", kind))
+	}
+	fields = append(fields, wool.Field("traceback", strings.Join(lines, "\n")))
+	a.out.Error(message, fields...)
+}
+
+// emit logs a single line at its own level.
+func (a *logAdapter) emit(line string) {
+	level, logger, message, ok := parseLevel(line)
+	if !ok {
+		if m := warningLine.FindStringSubmatch(line); m != nil {
+			a.out.Warn(m[4], wool.Field("file", m[1]), wool.Field("line", atoi(m[2])), wool.Field("category", m[3]))
+			return
+		}
+		_, _ = a.out.Forward([]byte(line))
+		return
+	}
+
+	var fields []*wool.LogField
+	if logger != "" {
+		fields = append(fields, wool.Field("logger", logger))
+	}
+	if m := accessLine.FindStringSubmatch(message); m != nil {
+		status := atoi(m[4])
+		fields = append(fields,
+			wool.Field("client", m[1]),
+			wool.Field("method", m[2]),
+			wool.Field("path", m[3]),
+			wool.Field("status", status))
+		if l := latency.FindStringSubmatch(m[5]); l != nil {
+			fields = append(fields, wool.Field("latency", parseLatency(l[1], l[2])))
+		}
+		// A server error is worth seeing even when access logs are filtered.
+		if status >= 500 && level < wool.WARN {
+			level = wool.WARN
+		}
+		message = m[2] + " " + m[3] + " " + m[4]
+	}
+	a.log(level, message, fields...)
+}
+
+func (a *logAdapter) log(level wool.Loglevel, message string, fields ...*wool.LogField) {
+	switch level {
+	case wool.TRACE:
+		a.out.Trace(message, fields...)
+	case wool.DEBUG:
+		a.out.Debug(message, fields...)
+	case wool.WARN:
+		a.out.Warn(message, fields...)
+	case wool.ERROR:
+		a.out.Error(message, fields...)
+	default:
+		a.out.Info(message, fields...)
+	}
+}
+
+// parseLevel maps a Python/uvicorn/gunicorn level prefix onto wool.
+func parseLevel(line string) (level wool.Loglevel, logger, message string, ok bool) {
+	var name string
+	if m := levelPrefix.FindStringSubmatch(line); m != nil {
+		name, logger, message = m[1], m[2], m[3]
+	} else if m := gunicornPrefix.FindStringSubmatch(line); m != nil {
+		name, message = m[1], m[2]
+	} else {
+		return 0, "", "", false
+	}
+	switch name {
+	case "TRACE":
+		level = wool.TRACE
+	case "DEBUG":
+		level = wool.DEBUG
+	case "INFO":
+		level = wool.INFO
+	case "WARNING", "WARN":
+		level = wool.WARN
+	default:
+		// CRITICAL/FATAL stay at ERROR: wool's FATAL is for the agent itself.
+		level = wool.ERROR
+	}
+	return level, logger, message, true
+}
+
+func parseLatency(value, unit string) time.Duration {
+	f, _ := strconv.ParseFloat(value, 64)
+	if unit == "s" {
+		return time.Duration(f * float64(time.Second))
+	}
+	return time.Duration(f * float64(time.Millisecond))
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
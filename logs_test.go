@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+type capturedLogs struct {
+	sync.Mutex
+	logs []*wool.Log
+}
+
+func (c *capturedLogs) Process(log *wool.Log) {
+	c.Lock()
+	defer c.Unlock()
+	c.logs = append(c.logs, log)
+}
+
+func (c *capturedLogs) all() []*wool.Log {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*wool.Log(nil), c.logs...)
+}
+
+func field(log *wool.Log, key string) any {
+	for _, f := range log.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+func newCapturingAdapter() (*logAdapter, *capturedLogs) {
+	captured := &capturedLogs{}
+	w := wool.Get(context.Background()).WithLogger(captured)
+	w.WithLoglevel(wool.TRACE)
+	return newLogAdapter(w), captured
+}
+
+func TestLogAdapterLines(t *testing.T) {
+	cases := []struct {
+		line    string
+		level   wool.Loglevel
+		message string
+		fields  map[string]any
+	}{
+		{
+			line:    `INFO:     127.0.0.1:51234 - "GET /items?limit=10 HTTP/1.1" 200 OK`,
+			level:   wool.INFO,
+			message: "GET /items?limit=10 200",
+			fields:  map[string]any{"client": "127.0.0.1:51234", "method": "GET", "path": "/items?limit=10", "status": 200},
+		},
+		{
+			line:    `INFO:     127.0.0.1:51234 - "POST /items HTTP/1.1" 201 Created 12.5ms`,
+			level:   wool.INFO,
+			message: "POST /items 201",
+			fields:  map[string]any{"latency": 12500 * time.Microsecond},
+		},
+		{
+			line:    `INFO:     127.0.0.1:51234 - "GET /boom HTTP/1.1" 500 Internal Server Error`,
+			level:   wool.WARN,
+			message: "GET /boom 500",
+			fields:  map[string]any{"status": 500},
+		},
+		{
+			line:    "WARNING:app.db:pool exhausted",
+			level:   wool.WARN,
+			message: "pool exhausted",
+			fields:  map[string]any{"logger": "app.db"},
+		},
+		{
+			line:    "DEBUG:    loaded config",
+			level:   wool.DEBUG,
+			message: "loaded config",
+		},
+		{
+			line:    "CRITICAL:root:out of memory",
+			level:   wool.ERROR,
+			message: "out of memory",
+		},
+		{
+			line:    "[2024-05-01 10:00:00 +0000] [42] [INFO] Booting worker with pid: 43",
+			level:   wool.INFO,
+			message: "Booting worker with pid: 43",
+		},
+		{
+			line:    "/app/src/main.py:12: DeprecationWarning: on_event is deprecated",
+			level:   wool.WARN,
+			message: "on_event is deprecated",
+			fields:  map[string]any{"file": "/app/src/main.py", "line": 12, "category": "DeprecationWarning"},
+		},
+		{
+			line:    "hello from print()",
+			level:   wool.FORWARD,
+			message: "hello from print()",
+		},
+	}
+	for _, c := range cases {
+		adapter, captured := newCapturingAdapter()
+		_, _ = adapter.Write([]byte(c.line + "\n"))
+		logs := captured.all()
+		if len(logs) != 1 {
+			t.Errorf("%q: got %d events, want 1", c.line, len(logs))
+			continue
+		}
+		log := logs[0]
+		if log.Level != c.level || log.Message != c.message {
+			t.Errorf("%q: got %s %q, want %s %q", c.line, log.Level, log.Message, c.level, c.message)
+		}
+		for key, want := range c.fields {
+			if got := field(log, key); got != want {
+				t.Errorf("%q: field %s = %v, want %v", c.line, key, got, want)
+			}
+		}
+	}
+}
+
+const traceback = `ERROR:    Exception in ASGI application
+Traceback (most recent call last):
+  File "/app/.venv/lib/python3.12/site-packages/starlette/routing.py", line 74, in app
+    response = await func(request)
+  File "/app/src/admin/router.py", line 12, in version
+    return {"version": 1 / 0}
+ZeroDivisionError: division by zero
+INFO:     127.0.0.1:51234 - "GET /version HTTP/1.1" 500 Internal Server Error
+`
+
+// TestLogAdapterTraceback: a traceback split across writes is one error
+// event pointing at the innermost frame.
+func TestLogAdapterTraceback(t *testing.T) {
+	adapter, captured := newCapturingAdapter()
+	half := len(traceback) / 2
+	_, _ = adapter.Write([]byte(traceback[:half]))
+	_, _ = adapter.Write([]byte(traceback[half:]))
+
+	logs := captured.all()
+	if len(logs) != 3 {
+		t.Fatalf("got %d events, want 3", len(logs))
+	}
+	event := logs[1]
+	if event.Level != wool.ERROR || event.Message != "ZeroDivisionError: division by zero" {
+		t.Fatalf("unexpected traceback event %s %q", event.Level, event.Message)
+	}
+	want := map[string]any{"file": "/app/src/admin/router.py", "line": 12, "function": "version", "exception": "ZeroDivisionError"}
+	for key, value := range want {
+		if got := field(event, key); got != value {
+			t.Errorf("field %s = %v, want %v", key, got, value)
+		}
+	}
+	if tb, _ := field(event, "traceback").(string); strings.Count(tb, "\n") != 5 {
+		t.Errorf("traceback field should hold all 6 lines, got %q", tb)
+	}
+}
+
+// TestLogAdapterTruncatedTraceback: a traceback that never gets its
+// exception line is still emitted once output goes quiet.
+func TestLogAdapterTruncatedTraceback(t *testing.T) {
+	adapter, captured := newCapturingAdapter()
+	_, _ = adapter.Write([]byte("Traceback (most recent call last):\n  File \"/app/src/main.py\", line 3, in <module>\n"))
+	if len(captured.all()) != 0 {
+		t.Fatal("traceback emitted before it ended")
+	}
+	time.Sleep(tracebackIdleFlush + 200*time.Millisecond)
+	logs := captured.all()
+	if len(logs) != 1 || logs[0].Level != wool.ERROR || field(logs[0], "line") != 3 {
+		t.Fatalf("unexpected events %v", logs)
+	}
+}
@@ -19,7 +19,6 @@ import (
 	pythonrunner "github.com/codefly-dev/core/runners/python"
 	"github.com/codefly-dev/core/shared"
 	"github.com/codefly-dev/core/templates"
-	"github.com/codefly-dev/core/toolbox/lang"
 
 	pythoncode "github.com/codefly-dev/service-python/pkg/code"
 	pythonruntime "github.com/codefly-dev/service-python/pkg/runtime"
@@ -39,6 +38,7 @@ var requirements = builders.NewDependencies(agent.Name,
 const (
 	HotReload      = "hot-reload"
 	PublicEndpoint = "public-endpoint"
+	Metrics        = "metrics"
 )
 
 // Settings inherits the generic Python Settings (PythonVersion) and adds
@@ -47,28 +47,122 @@ const (
 //	python-version: "3.12"   # from generic
 //	hot-reload: true         # from this layer
 //	public-endpoint: true    # from this layer
+//	server-mode: workers     # from this layer (optional)
 type Settings struct {
 	pythonservice.Settings `yaml:",inline"`
 
 	HotReload      bool `yaml:"hot-reload"`
 	PublicEndpoint bool `yaml:"public-endpoint"`
 
+	// TestWatch reruns the tests affected by each change while the service
+	// runs (see testwatch.go).
+	TestWatch bool `yaml:"test-watch"`
+
+	// Metrics declares a "metrics" endpoint served by GET /metrics
+	// (src/metrics.py), scraped and summarised during `codefly run`. The
+	// route and prometheus-client are only scaffolded with it.
+	Metrics bool `yaml:"metrics"`
+
+	// ServerMode is one of dev-reload, single, workers, gunicorn (see
+	// server.go). Empty derives it from HotReload. Workers, KeepAlive and
+	// GracefulTimeout (seconds) are passed through to uvicorn/gunicorn;
+	// zero leaves the server default.
+	ServerMode      string `yaml:"server-mode"`
+	Workers         int    `yaml:"workers"`
+	KeepAlive       int    `yaml:"keep-alive"`
+	GracefulTimeout int    `yaml:"graceful-timeout"`
+
+	// RootPath is the path prefix a proxy serves the app under (uvicorn
+	// --root-path). ProxyHeaders trusts the X-Forwarded-* headers of the
+	// proxies in ForwardedAllowIPs (default: any). Both apply wherever the
+	// app is served: codefly run, the image and Kubernetes.
+	RootPath          string `yaml:"root-path"`
+	ProxyHeaders      bool   `yaml:"proxy-headers"`
+	ForwardedAllowIPs string `yaml:"forwarded-allow-ips"`
+
+	// DrainTimeout (seconds) is how long Stop waits after SIGTERM for
+	// uvicorn to finish in-flight requests and run the lifespan shutdown
+	// before the process is killed. Unset, it is 10, or graceful-timeout
+	// plus 5 when longer; set, it must exceed graceful-timeout.
+	DrainTimeout int `yaml:"drain-timeout"`
+
+	// ReadinessPath is probed on the REST endpoint before Start returns
+	// (default /health, scaffolded in src/admin/router.py).
+	// ReadinessTimeout is in seconds (default 30).
+	ReadinessPath    string `yaml:"readiness-path"`
+	ReadinessTimeout int    `yaml:"readiness-timeout"`
+
+	// MaxRestarts bounds how many consecutive crashes are restarted (with
+	// exponential backoff) before the service is reported as crash-looping.
+	// Zero uses the default (5); negative disables automatic restarts.
+	MaxRestarts int `yaml:"max-restarts"`
+
+	// Debug runs the app under debugpy, listening on DebugPort (default
+	// 5678, published from the container in Docker mode). DebugWait holds
+	// the app until an IDE attaches.
+	Debug     bool `yaml:"debug"`
+	DebugPort int  `yaml:"debug-port"`
+	DebugWait bool `yaml:"debug-wait"`
+
+	// Observability turns on OpenTelemetry tracing (see observability.go).
+	Observability *Observability `yaml:"observability"`
+
+	// Migrations applies the plugins' migrations to a database dependency
+	// during Init, and as a Job on deploy (see migrations.go).
+	Migrations *Migrations `yaml:"migrations"`
+
+	// APICompatibility gates Build on breaking OpenAPI changes since the
+	// last released spec: fail (default) unless the major version was
+	// bumped, warn, or off. APICheckOnInit also reports them during
+	// Runtime.Init, without failing it.
+	APICompatibility string `yaml:"api-compatibility"`
+	APICheckOnInit   bool   `yaml:"api-check-on-init"`
+
+	// Typecheck selects the static type checker served by Tooling, and
+	// whether type errors fail Build (see typecheck.go).
+	Typecheck *Typecheck `yaml:"typecheck"`
+
+	// Audit points the dependency audit at a local advisory database, and
+	// can fail Build on vulnerable dependencies (see audit.go).
+	Audit *Audit `yaml:"audit"`
+
+	// Licenses is the license policy of the venv's packages; violations
+	// fail Build (see licenses.go).
+	Licenses *Licenses `yaml:"licenses"`
+
+	// SystemPackages are OS packages installed in both image stages and
+	// provisioned by the nix and native backends; BuildEnv is environment
+	// fixed in both image stages (see systempackages.go).
+	SystemPackages []SystemPackage   `yaml:"system-packages"`
+	BuildEnv       map[string]string `yaml:"build-env"`
+
 	// RuntimeImage overrides the default codefly-built runtime image.
 	// Format: "name:tag". Plain "name" and ":latest" are rejected —
-	// pinning is enforced. Leave empty to use codeflydev/python:<ver>
-	// (recommended; companion is rebuilt + pinned on every codefly
-	// release). Field named RuntimeImage (not DockerImage) to avoid
+	// pinning is enforced. Leave empty to use the companion image of
+	// python-version (recommended; companion is rebuilt + pinned on every
+	// codefly release, see pythonversion.go). Field named RuntimeImage (not DockerImage) to avoid
 	// colliding with services.Base.DockerImage(req).
 	RuntimeImage string `yaml:"docker-image"`
 }
 
-// runtimeImage is the codefly-built Python runtime companion —
-// python:3.13.1-alpine3.21 + codefly CLI + uv 0.5.29. Built from
-// core/companions/python/. Users can override via the Python settings
-// (DockerImage field) but NOT recommended — the companion image is
-// the mode-consistent default and gets the same tool set as every
-// other codefly-built image.
-var runtimeImage = &resources.DockerImage{Name: "codeflydev/python", Tag: "0.0.1"}
+// Validate checks the server options and every settings section: Load
+// rejects a service.codefly.yaml that fails it, and Build checks it again.
+func (s *Settings) Validate() error {
+	for _, validate := range []func() error{
+		s.ValidateServer,
+		s.Observability.Validate,
+		s.Migrations.Validate,
+		s.Typecheck.Validate,
+		s.Audit.Validate,
+		s.Licenses.Validate,
+		s.validateSystemPackages,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 // Service is the FastAPI specialization. It embeds the generic Python
 // Service so methods defined on *pythonservice.Service (and transitively
@@ -87,6 +181,10 @@ type Service struct {
 	Settings *Settings
 
 	RestEndpoint *v0.Endpoint
+	// Python is the release resolved from python-version during Load.
+	Python *PythonRelease
+	// MetricsEndpoint is set when the metrics setting declared one.
+	MetricsEndpoint *v0.Endpoint
 }
 
 // GetAgentInformation overrides the generic info to advertise HTTP protocol
@@ -129,17 +227,17 @@ func NewService() *Service {
 func main() {
 	svc := NewService()
 
-	// Code + Tooling are inherited wholesale from the generic Python layer.
-	// FastAPI has no Python analysis behavior to add beyond what generic
-	// already does (uv/AST/ruff/pytest). If that changes, wrap them here.
+	// Code is inherited wholesale from the generic Python layer. Tooling
+	// wraps the generic one to add type checking (tooling.go).
 	code := pythoncode.New(svc.Service)
 	genericRuntime := pythonruntime.New(svc.Service)
-	tooling := pythontooling.New(code, genericRuntime)
+	tooling := NewTooling(svc, pythontooling.New(code, genericRuntime))
+	builder := NewBuilder(svc)
 
 	agents.Serve(agents.PluginRegistration{
 		Agent:   svc,
 		Runtime: NewRuntime(svc),
-		Builder: NewBuilder(svc),
+		Builder: builder,
 		Code:    code,
 		Tooling: tooling,
 		// Phase B migration: expose the unified Toolbox surface
@@ -147,7 +245,7 @@ func main() {
 		// lang.ToolingFromToolbox(toolboxClient); other Toolbox
 		// consumers (MCP transcoder, future codefly tools) work
 		// without further changes.
-		Toolbox: lang.NewToolboxFromTooling(agent.Name, agent.Version, tooling),
+		Toolbox: NewToolbox(tooling, builder),
 	})
 }
 
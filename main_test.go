@@ -81,6 +81,8 @@ func testCreateToRun(t *testing.T, runtimeContext *basev0.RuntimeContext) {
 	runtime := NewRuntime(svc)
 
 	defer func() {
+		// Destroy is rejected while running.
+		_, _ = runtime.Stop(ctx, &runtimev0.StopRequest{})
 		_, _ = runtime.Destroy(ctx, &runtimev0.DestroyRequest{})
 	}()
 
@@ -100,7 +102,7 @@ func testCreateToRun(t *testing.T, runtimeContext *basev0.RuntimeContext) {
 
 	restRoutes, err := resources.ExtractRestRoutes(ctx, networkMappings, resources.NewPublicNetworkAccess())
 	require.NoError(t, err)
-	require.Equal(t, 1, len(restRoutes))
+	require.Equal(t, 2, len(restRoutes)) // /version + /health
 
 	testRun(t, runtime, ctx, identity, runtimeContext, networkMappings)
 
@@ -128,37 +130,24 @@ func testRun(t *testing.T, runtime *Runtime, ctx context.Context, identity *base
 	instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, init.NetworkMappings, runtime.FastAPI.RestEndpoint, resources.NewNativeNetworkAccess())
 	require.NoError(t, err)
 
-	_, err = runtime.Start(ctx, &runtimev0.StartRequest{})
+	start, err := runtime.Start(ctx, &runtimev0.StartRequest{})
 	require.NoError(t, err)
+	require.Equal(t, runtimev0.StartStatus_STARTED, start.Status.State, start.Status.Message)
 
-	client := http.Client{Timeout: 200 * time.Millisecond}
-	// Loop and wait for 1 seconds up to do a HTTP request to localhost with /version path
-	tries := 0
-	for {
-		if tries > 10 {
-			t.Fatal("too many tries")
-		}
-		time.Sleep(time.Second)
-
-		// HTTP
-		response, err := client.Get(fmt.Sprintf("%s/version", instance.Address))
-		if err != nil {
-			tries++
-			continue
-		}
-
-		defer response.Body.Close()
+	// Start only returns once the readiness gate passed: no polling needed.
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/version", instance.Address))
+	require.NoError(t, err)
+	defer response.Body.Close()
 
-		body, err := io.ReadAll(response.Body)
-		require.NoError(t, err)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
 
-		var data map[string]interface{}
-		err = json.Unmarshal(body, &data)
-		require.NoError(t, err)
+	var data map[string]interface{}
+	err = json.Unmarshal(body, &data)
+	require.NoError(t, err)
 
-		version, ok := data["version"].(string)
-		require.True(t, ok)
-		require.Equal(t, identity.Version, version)
-		break
-	}
+	version, ok := data["version"].(string)
+	require.True(t, ok)
+	require.Equal(t, identity.Version, version)
 }
@@ -0,0 +1,386 @@
+package main
+
+// metrics.go — Prometheus metrics.
+//
+// With `metrics: true` the service declares a second endpoint, "metrics"
+// (HTTP), next to the REST one. The app serves GET /metrics itself
+// (src/metrics.py, on the REST port); during `codefly run` the agent
+// serves the metrics endpoint's own port by proxying to that route, and
+// scrapes it to summarise request rate, latency and errors through
+// Information. In Kubernetes the metrics port maps to the app's port and
+// the pod carries the prometheus.io scrape annotations.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	// metricsEndpointName is the endpoint declared next to the REST one.
+	metricsEndpointName = "metrics"
+	// metricsPath is the route scaffolded in src/metrics.py.
+	metricsPath = "/metrics"
+
+	metricsScrapeInterval = 10 * time.Second
+	metricsScrapeTimeout  = 2 * time.Second
+
+	// Series written by src/metrics.py.
+	requestsMetric = "http_requests_total"
+	latencyMetric  = "http_request_duration_seconds"
+)
+
+// createMetricsEndpoint declares the metrics endpoint. Like the REST
+// endpoint, it is private unless public-endpoint is set.
+func (s *Builder) createMetricsEndpoint(ctx context.Context) (*basev0.Endpoint, error) {
+	endpoint := s.Base.BaseEndpoint(metricsEndpointName)
+	if s.FastAPI.Settings.PublicEndpoint {
+		endpoint.Visibility = resources.VisibilityPublic
+	}
+	api, err := resources.NewAPI(ctx, endpoint, resources.ToHTTPAPI(&basev0.HttpAPI{}))
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create metrics api")
+	}
+	return api, nil
+}
+
+// findMetricsEndpoint returns the declared metrics endpoint, if any.
+func findMetricsEndpoint(endpoints []*basev0.Endpoint) *basev0.Endpoint {
+	for _, endpoint := range endpoints {
+		if endpoint.Name == metricsEndpointName {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+// startMetrics serves the metrics endpoint (when one is mapped) and starts
+// the scraper against the app's route.
+func (s *Runtime) startMetrics(ctx context.Context) {
+	if !s.FastAPI.Settings.Metrics {
+		return
+	}
+	target := strings.TrimSuffix(s.address, "/") + metricsPath
+	if s.metricsProxy == nil && s.FastAPI.MetricsEndpoint != nil {
+		instance, err := resources.FindNetworkInstanceInNetworkMappings(ctx, s.NetworkMappings, s.FastAPI.MetricsEndpoint, resources.NewNativeNetworkAccess())
+		if err != nil {
+			s.Wool.Warn("metrics endpoint has no network mapping", wool.ErrField(err))
+		} else if listener, err := net.Listen("tcp", fmt.Sprintf(":%d", instance.Port)); err != nil {
+			s.Wool.Warn("cannot serve the metrics endpoint", wool.ErrField(err))
+		} else {
+			s.metricsProxy = serveMetricsProxy(ctx, listener, target)
+		}
+	}
+	if s.metrics == nil {
+		scraper := newMetricsScraper(target, metricsScrapeInterval)
+		go scraper.run(s.Wool.Inject(context.Background()))
+		s.mu.Lock()
+		s.metrics = scraper
+		s.mu.Unlock()
+	}
+}
+
+// stopMetrics stops the scraper and the proxy.
+func (s *Runtime) stopMetrics(ctx context.Context) {
+	s.mu.Lock()
+	scraper := s.metrics
+	s.metrics = nil
+	s.mu.Unlock()
+	if scraper != nil {
+		scraper.Stop()
+	}
+	if s.metricsProxy != nil {
+		_ = s.metricsProxy.Shutdown(ctx)
+		s.metricsProxy = nil
+	}
+}
+
+// serveMetricsProxy forwards /metrics on listener to target, the app's
+// metrics URL.
+func serveMetricsProxy(ctx context.Context, listener net.Listener, target string) *http.Server {
+	proxy := &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+		u, _ := url.Parse(target)
+		r.SetURL(&url.URL{Scheme: u.Scheme, Host: u.Host})
+		r.Out.URL.Path = u.Path
+	}}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, proxy)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			wool.Get(ctx).In("metrics").Warn("metrics proxy stopped", wool.ErrField(err))
+		}
+	}()
+	return server
+}
+
+// requestStats are the counters read from one scrape. Buckets map an upper
+// bound (seconds) to a cumulative count, summed over routes.
+type requestStats struct {
+	At          time.Time
+	Requests    float64
+	Errors      float64
+	LatencySum  float64
+	LatencyHits float64
+	Buckets     map[float64]float64
+}
+
+// parseRequestStats reads the Prometheus text format, keeping the request
+// counter and latency histogram of src/metrics.py. Errors are 5xx responses.
+func parseRequestStats(r io.Reader) (*requestStats, error) {
+	stats := &requestStats{Buckets: map[float64]float64{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, value, err := parseSample(line)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case requestsMetric:
+			stats.Requests += value
+			if strings.HasPrefix(labels["status"], "5") {
+				stats.Errors += value
+			}
+		case latencyMetric + "_sum":
+			stats.LatencySum += value
+		case latencyMetric + "_count":
+			stats.LatencyHits += value
+		case latencyMetric + "_bucket":
+			le, err := strconv.ParseFloat(labels["le"], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket bound %q", labels["le"])
+			}
+			stats.Buckets[le] += value
+		}
+	}
+	return stats, scanner.Err()
+}
+
+// parseSample splits `name{label="value",...} value [timestamp]`.
+func parseSample(line string) (string, map[string]string, float64, error) {
+	labels := map[string]string{}
+	name, rest := line, ""
+	if i := strings.IndexAny(line, "{ "); i >= 0 {
+		name, rest = line[:i], line[i:]
+	}
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, ", ")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, `="`)
+			if eq < 0 {
+				return "", nil, 0, fmt.Errorf("malformed labels in %q", line)
+			}
+			key := rest[:eq]
+			rest = rest[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' && i+1 < len(rest) {
+					i++
+					switch rest[i] {
+					case 'n':
+						value.WriteByte('\n')
+					default:
+						value.WriteByte(rest[i])
+					}
+					continue
+				}
+				if c == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return "", nil, 0, fmt.Errorf("unterminated label in %q", line)
+			}
+			labels[key] = value.String()
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, fmt.Errorf("missing value in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid value in %q", line)
+	}
+	return name, labels, value, nil
+}
+
+// summarizeRequests describes the window between two scrapes: request
+// rate, mean and p95 latency, and 5xx errors. previous may be nil (first
+// scrape) or ahead of current (the app restarted): the window then starts
+// at zero.
+func summarizeRequests(previous, current *requestStats) string {
+	if previous == nil || current.Requests < previous.Requests {
+		previous = &requestStats{At: current.At, Buckets: map[float64]float64{}}
+	}
+	requests := current.Requests - previous.Requests
+	errors := current.Errors - previous.Errors
+	parts := []string{}
+	if elapsed := current.At.Sub(previous.At).Seconds(); elapsed > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f req/s", requests/elapsed))
+	} else {
+		parts = append(parts, fmt.Sprintf("%.0f requests", requests))
+	}
+	if hits := current.LatencyHits - previous.LatencyHits; hits > 0 {
+		mean := time.Duration((current.LatencySum - previous.LatencySum) / hits * float64(time.Second))
+		parts = append(parts, "mean "+roundLatency(mean).String())
+		if p95, ok := quantile(0.95, previous.Buckets, current.Buckets); ok {
+			parts = append(parts, "p95 "+p95)
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%.0f errors (5xx)", errors))
+	return "requests: " + strings.Join(parts, ", ")
+}
+
+// quantile estimates q from the bucket increments between two scrapes, as
+// the upper bound of the first bucket reaching it.
+func quantile(q float64, previous, current map[float64]float64) (string, bool) {
+	bounds := make([]float64, 0, len(current))
+	for le := range current {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+	if len(bounds) == 0 {
+		return "", false
+	}
+	total := current[bounds[len(bounds)-1]] - previous[bounds[len(bounds)-1]]
+	if total <= 0 {
+		return "", false
+	}
+	for _, le := range bounds {
+		if current[le]-previous[le] >= q*total {
+			if math.IsInf(le, 1) {
+				return fmt.Sprintf("> %s", roundLatency(time.Duration(bounds[max(0, len(bounds)-2)]*float64(time.Second)))), true
+			}
+			return "≤ " + roundLatency(time.Duration(le*float64(time.Second))).String(), true
+		}
+	}
+	return "", false
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(10 * time.Millisecond)
+	}
+	return d.Round(100 * time.Microsecond)
+}
+
+// metricsScraper polls the app's metrics route.
+type metricsScraper struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu       sync.Mutex
+	previous *requestStats
+	current  *requestStats
+	err      error
+}
+
+func newMetricsScraper(url string, interval time.Duration) *metricsScraper {
+	return &metricsScraper{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: metricsScrapeTimeout},
+		done:     make(chan struct{}),
+	}
+}
+
+func (m *metricsScraper) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.scrape(ctx)
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop ends the scrape loop.
+func (m *metricsScraper) Stop() {
+	m.stopOnce.Do(func() { close(m.done) })
+}
+
+func (m *metricsScraper) scrape(ctx context.Context) {
+	stats, err := m.fetch(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		if m.err == nil {
+			wool.Get(ctx).In("metrics").Debug("cannot scrape metrics", wool.ErrField(err))
+		}
+		m.err = err
+		return
+	}
+	m.err = nil
+	m.previous, m.current = m.current, stats
+}
+
+func (m *metricsScraper) fetch(ctx context.Context) (*requestStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", m.url, resp.Status)
+	}
+	stats, err := parseRequestStats(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	stats.At = time.Now()
+	return stats, nil
+}
+
+// Summary describes the last scrape window, empty before the first one.
+func (m *metricsScraper) Summary() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current == nil {
+		if m.err != nil {
+			return "metrics unavailable: " + m.err.Error()
+		}
+		return ""
+	}
+	return summarizeRequests(m.previous, m.current)
+}
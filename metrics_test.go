@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exposition renders what src/metrics.py serves after requests requests,
+// errors of them 5xx, with latencies spread over the histogram buckets.
+func exposition(requests, errors int, fast, slow int) string {
+	return fmt.Sprintf(`# HELP http_requests_total HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/items",status="200"} %d.0
+http_requests_total{method="GET",route="/items/{id}",status="500"} %d.0
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.01",method="GET",route="/items"} %d.0
+http_request_duration_seconds_bucket{le="0.25",method="GET",route="/items"} %d.0
+http_request_duration_seconds_bucket{le="+Inf",method="GET",route="/items"} %d.0
+http_request_duration_seconds_count{method="GET",route="/items"} %d.0
+http_request_duration_seconds_sum{method="GET",route="/items"} %g
+# a label value with an escaped quote
+other_total{path="a\"b"} 1
+`, requests-errors, errors, fast, fast+slow, fast+slow, fast+slow, float64(fast)*0.005+float64(slow)*0.2)
+}
+
+func TestParseRequestStats(t *testing.T) {
+	stats, err := parseRequestStats(strings.NewReader(exposition(10, 2, 6, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Requests != 10 || stats.Errors != 2 || stats.LatencyHits != 10 || stats.Buckets[0.25] != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if _, err := parseRequestStats(strings.NewReader(`broken{le="1 2`)); err == nil {
+		t.Error("expected an error on a malformed sample")
+	}
+}
+
+func TestSummarizeRequests(t *testing.T) {
+	at := time.Now()
+	previous, _ := parseRequestStats(strings.NewReader(exposition(10, 2, 6, 4)))
+	previous.At = at
+	current, _ := parseRequestStats(strings.NewReader(exposition(110, 5, 96, 14)))
+	current.At = at.Add(10 * time.Second)
+
+	// 100 requests in 10s, 90 under 10ms and 10 under 250ms: p95 is ≤ 250ms.
+	got := summarizeRequests(previous, current)
+	want := "requests: 10.0 req/s, mean 24.5ms, p95 ≤ 250ms, 3 errors (5xx)"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A restarted app resets its counters: count from zero.
+	restarted, _ := parseRequestStats(strings.NewReader(exposition(4, 0, 4, 0)))
+	restarted.At = at.Add(20 * time.Second)
+	if got := summarizeRequests(current, restarted); !strings.HasPrefix(got, "requests: 4 requests") {
+		t.Errorf("after restart: %q", got)
+	}
+}
+
+// TestMetricsProxy: the metrics endpoint's port serves the app's route.
+func TestMetricsProxy(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != metricsPath {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, exposition(1, 0, 1, 0))
+	}))
+	defer app.Close()
+
+	ctx := context.Background()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := serveMetricsProxy(ctx, listener, app.URL+metricsPath)
+	defer proxy.Close()
+
+	scraper := newMetricsScraper("http://"+listener.Addr().String()+metricsPath, time.Hour)
+	scraper.scrape(ctx)
+	if summary := scraper.Summary(); !strings.HasPrefix(summary, "requests: 1 requests") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+// migrations.go — the plugins' database migrations.
+//
+// Plugin.migrations lists Alembic script directories. src/migrations.py
+// (scaffolded, like openapi.py) reads them from the plugin registry and
+// applies them. During Init, after uv sync, the Runtime lists them and
+// upgrades each directory in registry order against the database
+// dependency named by the migrations setting. Applied revisions are
+// recorded in the cache with a hash of the directory, so a directory is
+// only run again when its scripts or the database change. Deploy renders
+// the same script as a Kubernetes Job.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	migrationsScript = "src/migrations.py"
+	// migrationsResultPrefix marks the script's JSON result line.
+	migrationsResultPrefix = "codefly-migrations: "
+	// migrationsStateFile is relative to the cache location.
+	migrationsStateFile = "migrations.json"
+
+	defaultMigrationsConfiguration = "postgres"
+	defaultMigrationsKey           = "connection"
+)
+
+// Migrations points the plugins' migrations at a database dependency.
+type Migrations struct {
+	// Database is the dependency providing the database (module/service).
+	Database string `yaml:"database"`
+	// Configuration and Key name the connection string in the dependency's
+	// configuration (default postgres / connection).
+	Configuration string `yaml:"configuration,omitempty"`
+	Key           string `yaml:"key,omitempty"`
+}
+
+// Validate requires the database.
+func (m *Migrations) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.Database == "" {
+		return fmt.Errorf("migrations.database must name the database dependency (module/service)")
+	}
+	return nil
+}
+
+func (m *Migrations) configuration() string {
+	if m.Configuration == "" {
+		return defaultMigrationsConfiguration
+	}
+	return m.Configuration
+}
+
+func (m *Migrations) key() string {
+	if m.Key == "" {
+		return defaultMigrationsKey
+	}
+	return m.Key
+}
+
+// DatabaseEnvironment is where codefly puts the connection string, as a
+// secret and as a plain value: the script takes the first one set.
+func (m *Migrations) DatabaseEnvironment() []string {
+	return []string{
+		resources.ServiceSecretConfigurationKeyFromUnique(m.Database, m.configuration(), m.key()),
+		resources.ServiceConfigurationKeyFromUnique(m.Database, m.configuration(), m.key()),
+	}
+}
+
+// UpgradeArgs are the script arguments applying directories (all of them
+// when none is given).
+func (m *Migrations) UpgradeArgs(directories ...string) []string {
+	args := []string{migrationsScript, "upgrade"}
+	for _, env := range m.DatabaseEnvironment() {
+		args = append(args, "--database-env", env)
+	}
+	return append(args, directories...)
+}
+
+// migration is one directory of one plugin, as the script reports it.
+type migration struct {
+	Plugin    string `json:"plugin"`
+	Directory string `json:"directory"`
+	Revision  string `json:"revision,omitempty"`
+	// Hash is the content of the directory when it was applied.
+	Hash string `json:"hash,omitempty"`
+}
+
+// migrationsState is what was applied, per database.
+type migrationsState struct {
+	// Database is a hash of the connection string.
+	Database string      `json:"database"`
+	Applied  []migration `json:"applied"`
+}
+
+func loadMigrationsState(p string) (*migrationsState, error) {
+	state := &migrationsState{}
+	content, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		// A corrupted state only costs a re-run: upgrades are idempotent.
+		return &migrationsState{}, nil
+	}
+	return state, nil
+}
+
+func (st *migrationsState) save(p string) error {
+	content, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, content, 0o644)
+}
+
+// upToDate reports whether m was applied with the same scripts.
+func (st *migrationsState) upToDate(m migration) bool {
+	for _, applied := range st.Applied {
+		if applied.Plugin == m.Plugin && applied.Directory == m.Directory {
+			return applied.Hash == m.Hash
+		}
+	}
+	return false
+}
+
+func (st *migrationsState) record(m migration) {
+	for i, applied := range st.Applied {
+		if applied.Plugin == m.Plugin && applied.Directory == m.Directory {
+			st.Applied[i] = m
+			return
+		}
+	}
+	st.Applied = append(st.Applied, m)
+}
+
+// hashDirectory hashes the file names and contents under dir, ignoring
+// Python caches.
+func hashDirectory(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == "__pycache__" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseMigrationsResult extracts the script's JSON result from its output.
+func parseMigrationsResult(output []byte) ([]migration, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if result, ok := strings.CutPrefix(scanner.Text(), migrationsResultPrefix); ok {
+			var migrations []migration
+			if err := json.Unmarshal([]byte(result), &migrations); err != nil {
+				return nil, fmt.Errorf("invalid migrations result: %w", err)
+			}
+			return migrations, nil
+		}
+	}
+	return nil, fmt.Errorf("no migrations result in the script output")
+}
+
+// runMigrationsScript runs src/migrations.py in the runner environment,
+// with the service's environment so the connection string is there.
+func (s *Runtime) runMigrationsScript(ctx context.Context, args ...string) ([]migration, error) {
+	proc, err := s.runnerEnvironment.NewProcess("uv", append([]string{"run", "python"}, args...)...)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create migrations runner")
+	}
+	var output bytes.Buffer
+	proc.WithOutput(io.MultiWriter(s.Logger, &output))
+	proc.WithDir(s.Service.SourceLocation)
+	envs, err := s.EnvironmentVariables.All()
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "getting environment variables")
+	}
+	proc.WithEnvironmentVariables(ctx, envs...)
+	proc.WithEnvironmentVariables(ctx, s.EnvironmentVariables.Secrets()...)
+	proc.WithEnvironmentVariables(ctx, resources.Env("PYTHONPATH", s.Service.SourceLocation))
+	if err := proc.Run(ctx); err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot run %s", migrationsScript)
+	}
+	return parseMigrationsResult(output.Bytes())
+}
+
+// migrationsDatabase is the connection string of the database dependency
+// among the dependency configurations.
+func (s *Runtime) migrationsDatabase(ctx context.Context, confs []*basev0.Configuration) (string, error) {
+	settings := s.FastAPI.Settings.Migrations
+	conf, err := resources.FindServiceConfiguration(ctx, confs, s.Base.Runtime.RuntimeContext, settings.Database)
+	if err != nil {
+		return "", s.Wool.Wrapf(err, "migrations.database %s is not a dependency with a configuration", settings.Database)
+	}
+	url, err := resources.GetConfigurationValue(ctx, conf, settings.configuration(), settings.key())
+	if err != nil {
+		return "", err
+	}
+	if url == "" {
+		return "", s.Wool.NewError("%s has no %s.%s configuration value", settings.Database, settings.configuration(), settings.key())
+	}
+	return url, nil
+}
+
+// applyMigrations upgrades every plugin migration directory whose scripts
+// changed since it was last applied, in registry order. It stops at the
+// first failure: later migrations may depend on it.
+func (s *Runtime) applyMigrations(ctx context.Context, confs []*basev0.Configuration) error {
+	hasScript, err := shared.FileExists(ctx, path.Join(s.Service.SourceLocation, migrationsScript))
+	if err != nil {
+		return err
+	}
+	if !hasScript {
+		s.Wool.Debug("no migrations script, skipping plugin migrations")
+		return nil
+	}
+	migrations, err := s.runMigrationsScript(ctx, migrationsScript, "list")
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+	settings := s.FastAPI.Settings.Migrations
+	if settings == nil {
+		s.Wool.Warn("plugins declare migrations but no migrations.database is set: not applying them")
+		return nil
+	}
+
+	url, err := s.migrationsDatabase(ctx, confs)
+	if err != nil {
+		return err
+	}
+	statePath := path.Join(s.cacheLocation, migrationsStateFile)
+	state, err := loadMigrationsState(statePath)
+	if err != nil {
+		return err
+	}
+	if database := hashString(url); state.Database != database {
+		// Another database: nothing is known to be applied there.
+		state = &migrationsState{Database: database}
+	}
+
+	for _, m := range migrations {
+		if m.Hash, err = hashDirectory(path.Join(s.Service.SourceLocation, m.Directory)); err != nil {
+			return s.Wool.Wrapf(err, "cannot read migrations of plugin %s", m.Plugin)
+		}
+		if state.upToDate(m) {
+			continue
+		}
+		s.Infof("applying migrations of plugin %s (%s)", m.Plugin, m.Directory)
+		applied, err := s.runMigrationsScript(ctx, settings.UpgradeArgs(m.Directory)...)
+		if err != nil {
+			return s.Wool.Wrapf(err, "migrations of plugin %s failed", m.Plugin)
+		}
+		for _, a := range applied {
+			m.Revision = a.Revision
+		}
+		s.Infof("plugin %s at revision %s", m.Plugin, m.Revision)
+		state.record(m)
+		if err := state.save(statePath); err != nil {
+			s.Wool.Warn("cannot record applied migrations", wool.ErrField(err))
+		}
+	}
+	return nil
+}
+
+// MigrationJob is the Kubernetes Job applying the migrations on deploy.
+type MigrationJob struct {
+	// Name changes with the version: a Job is immutable, and redeploying a
+	// version keeps its completed Job.
+	Name string
+	// Command runs the script in the image (WORKDIR code/).
+	Command []string
+}
+
+// migrationJob is nil without the migrations setting.
+func migrationJob(settings *Settings, identity *resources.ServiceIdentity) *MigrationJob {
+	if settings.Migrations == nil {
+		return nil
+	}
+	version := strings.NewReplacer(".", "-", "+", "-", "_", "-").Replace(strings.ToLower(identity.Version))
+	name := fmt.Sprintf("%s-migrations-%s", shared.ToDNSCase(identity.Name), version)
+	return &MigrationJob{
+		Name:    strings.Trim(name, "-"),
+		Command: append([]string{"python"}, settings.Migrations.UpgradeArgs()...),
+	}
+}
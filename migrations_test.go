@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/resources"
+)
+
+func TestParseMigrationsResult(t *testing.T) {
+	output := []byte(strings.Join([]string{
+		"INFO  [alembic.runtime.migration] Running upgrade  -> 1a2b, create users",
+		`codefly-migrations: [{"plugin": "users", "directory": "src/plugins/users/migrations", "revision": "1a2b"}]`,
+	}, "\n"))
+	migrations, err := parseMigrationsResult(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := migration{Plugin: "users", Directory: "src/plugins/users/migrations", Revision: "1a2b"}
+	if len(migrations) != 1 || migrations[0] != want {
+		t.Errorf("got %+v", migrations)
+	}
+
+	if _, err := parseMigrationsResult([]byte("Traceback (most recent call last):\n")); err == nil {
+		t.Error("expected an error without a result line")
+	}
+}
+
+func TestMigrationsState(t *testing.T) {
+	p := filepath.Join(t.TempDir(), migrationsStateFile)
+	state, err := loadMigrationsState(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := migration{Plugin: "users", Directory: "src/plugins/users/migrations", Revision: "1a2b", Hash: "h1"}
+	if state.upToDate(m) {
+		t.Fatal("nothing applied yet")
+	}
+	state.record(m)
+	if err := state.save(p); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = loadMigrationsState(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.upToDate(m) {
+		t.Error("recorded migration is not up to date")
+	}
+	changed := m
+	changed.Hash = "h2"
+	if state.upToDate(changed) {
+		t.Error("a changed directory must run again")
+	}
+	state.record(changed)
+	if len(state.Applied) != 1 || state.Applied[0].Hash != "h2" {
+		t.Errorf("record must replace the entry, got %+v", state.Applied)
+	}
+}
+
+func TestHashDirectory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("env.py", "run()")
+	write("versions/1a2b_users.py", "revision = '1a2b'")
+	before, err := hashDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("versions/__pycache__/1a2b_users.cpython-313.pyc", "bytecode")
+	if after, _ := hashDirectory(dir); after != before {
+		t.Error("python caches changed the hash")
+	}
+	write("versions/3c4d_roles.py", "revision = '3c4d'")
+	if after, _ := hashDirectory(dir); after == before {
+		t.Error("a new revision did not change the hash")
+	}
+}
+
+func TestMigrationsSettings(t *testing.T) {
+	if err := (&Migrations{}).Validate(); err == nil {
+		t.Error("expected an error without a database")
+	}
+	m := &Migrations{Database: "store/postgres"}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	args := m.UpgradeArgs("src/plugins/users/migrations")
+	for _, want := range []string{
+		migrationsScript, "upgrade",
+		resources.ServiceSecretConfigurationKeyFromUnique("store/postgres", "postgres", "connection"),
+		resources.ServiceConfigurationKeyFromUnique("store/postgres", "postgres", "connection"),
+	} {
+		if !slices.Contains(args, want) {
+			t.Errorf("%v misses %q", args, want)
+		}
+	}
+	if args[len(args)-1] != "src/plugins/users/migrations" {
+		t.Errorf("directory must come last: %v", args)
+	}
+}
+
+func TestMigrationJob(t *testing.T) {
+	identity := &resources.ServiceIdentity{Name: "api-server", Version: "1.2.3"}
+	if job := migrationJob(&Settings{}, identity); job != nil {
+		t.Errorf("no Job without the migrations setting, got %+v", job)
+	}
+	job := migrationJob(&Settings{Migrations: &Migrations{Database: "store/postgres"}}, identity)
+	if job == nil {
+		t.Fatal("expected a Job")
+	}
+	if job.Name != "api-server-migrations-1-2-3" {
+		t.Errorf("got name %q", job.Name)
+	}
+	if job.Command[0] != "python" || job.Command[1] != migrationsScript {
+		t.Errorf("got command %v", job.Command)
+	}
+}
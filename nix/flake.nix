@@ -1,3 +1,5 @@
+# codefly-managed: rewritten when python-version changes. Remove this line
+# to keep local edits.
 {
   description = "codefly python-fastapi service: nix runtime";
 
@@ -5,14 +5,18 @@ package main
 // In nix mode the agent runs the FastAPI service through a NixEnvironment rooted
 // at the service source dir (CreateRunnerEnvironment), which materializes the
 // devShell from a flake.nix there. User projects don't ship one, so this embeds
-// a codefly flake (python3 + uv) and writes it into the source dir when absent —
-// a user-supplied flake.nix is respected (never overwritten).
+// a codefly flake (python + uv) and writes it into the source dir when absent —
+// a user-supplied flake.nix is respected (never overwritten). The interpreter
+// is the nixpkgs attribute of python-version, and the nix system-packages join
+// the devShell; a flake the agent wrote itself (it starts with nixFlakeMarker)
+// is rewritten when either changes.
 
 import (
 	_ "embed"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 //go:embed nix/flake.nix
@@ -21,17 +25,55 @@ var nixFlakeNix string
 //go:embed nix/flake.lock
 var nixFlakeLock string
 
-// ensureNixFlake writes the embedded flake (python3 + uv) into dir unless a
-// flake.nix already exists there.
-func ensureNixFlake(dir string) error {
-	if _, err := os.Stat(filepath.Join(dir, "flake.nix")); err == nil {
-		return nil // user (or a prior run) already provides one
+// nixFlakeMarker opens the embedded flake, telling it apart from a user's.
+const nixFlakeMarker = "# codefly-managed"
+
+// nixFlake is the embedded flake with python as its interpreter, and the
+// packages (nixpkgs attributes) added to the devShell.
+func nixFlake(python string, packages []string) (string, error) {
+	const interpreter = "pkgs.python3\n"
+	const last = "pkgs.ruff\n"
+	if !strings.Contains(nixFlakeNix, interpreter) || !strings.Contains(nixFlakeNix, last) {
+		return "", fmt.Errorf("embedded flake.nix has no %q to pin or %q to add packages after", strings.TrimSpace(interpreter), strings.TrimSpace(last))
+	}
+	flake := strings.Replace(nixFlakeNix, interpreter, "pkgs."+python+"\n", 1)
+	// Each package goes on its own line, indented like pkgs.ruff.
+	at := strings.Index(flake, last)
+	indent := flake[strings.LastIndex(flake[:at], "\n")+1 : at]
+	var extra strings.Builder
+	for _, p := range packages {
+		extra.WriteString(indent + "pkgs." + p + "\n")
+	}
+	at += len(last)
+	return flake[:at] + extra.String() + flake[at:], nil
+}
+
+// isManagedNixFlake reports whether the flake at path was written by the agent.
+func isManagedNixFlake(path string) bool {
+	content, err := os.ReadFile(path)
+	return err == nil && strings.HasPrefix(string(content), nixFlakeMarker)
+}
+
+// ensureNixFlake writes the embedded flake (python + uv + packages) into
+// dir unless a user's flake.nix already exists there. It reports whether
+// dir holds the agent's flake.
+func ensureNixFlake(dir, python string, packages []string) (bool, error) {
+	flake, err := nixFlake(python, packages)
+	if err != nil {
+		return false, err
+	}
+	existing, err := os.ReadFile(filepath.Join(dir, "flake.nix"))
+	if err == nil && !strings.HasPrefix(string(existing), nixFlakeMarker) {
+		return false, nil // the user's own
+	}
+	if err == nil && string(existing) == flake {
+		return true, nil // already up to date
 	}
-	if err := os.WriteFile(filepath.Join(dir, "flake.nix"), []byte(nixFlakeNix), 0o644); err != nil {
-		return fmt.Errorf("write flake.nix: %w", err)
+	if err := os.WriteFile(filepath.Join(dir, "flake.nix"), []byte(flake), 0o644); err != nil {
+		return false, fmt.Errorf("write flake.nix: %w", err)
 	}
 	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), []byte(nixFlakeLock), 0o644); err != nil {
-		return fmt.Errorf("write flake.lock: %w", err)
+		return false, fmt.Errorf("write flake.lock: %w", err)
 	}
-	return nil
+	return true, nil
 }
@@ -0,0 +1,327 @@
+package main
+
+// observability.go — OpenTelemetry tracing.
+//
+// With `observability.enabled`, CreateRunnerEnvironment injects the OTEL_*
+// variables read by the OpenTelemetry SDK, and src/telemetry.py (scaffolded
+// by Create) instruments the FastAPI app with them. Create installs the
+// OpenTelemetry packages only when tracing is on; turned on later, Init
+// warns until they are added. Spans go to
+// `observability.endpoint` when set (a real collector), else to an
+// in-process OTLP/HTTP receiver: the agent decodes them and appends one
+// JSON line per span to traces.jsonl in the cache directory, which is
+// enough to inspect a request locally without running a collector.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/wool"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// otelPackages are what src/telemetry.py imports; Create adds them when
+// tracing is on.
+var otelPackages = []string{"opentelemetry-sdk", "opentelemetry-exporter-otlp-proto-http", "opentelemetry-instrumentation-fastapi"}
+
+// tracesFile is where the local receiver writes spans, under the cache.
+const tracesFile = "traces.jsonl"
+
+// maxOTLPRequest bounds a single export request.
+const maxOTLPRequest = 16 << 20
+
+// Observability configures tracing (observability setting).
+//
+//	observability:
+//	  enabled: true
+//	  endpoint: http://otel-collector:4318   # optional, default: local receiver
+//	  sample-ratio: 0.25                     # optional, default: 1
+type Observability struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`
+	SampleRatio float64 `yaml:"sample-ratio"`
+}
+
+// Validate rejects a sample ratio outside [0, 1] and a non-HTTP endpoint.
+func (o *Observability) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.SampleRatio < 0 || o.SampleRatio > 1 {
+		return fmt.Errorf("observability.sample-ratio must be between 0 and 1, got %v", o.SampleRatio)
+	}
+	if o.Endpoint != "" && !strings.HasPrefix(o.Endpoint, "http://") && !strings.HasPrefix(o.Endpoint, "https://") {
+		return fmt.Errorf("observability.endpoint must be an http(s) URL, got %q", o.Endpoint)
+	}
+	return nil
+}
+
+// TracingEnabled is true when the observability setting turns tracing on.
+func (s *Settings) TracingEnabled() bool {
+	return s.Observability != nil && s.Observability.Enabled
+}
+
+// otelEnvironment is what the SDK reads: the service identity as resource
+// attributes, the OTLP/HTTP exporter pointed at endpoint, and the sampler.
+func otelEnvironment(identity *resources.ServiceIdentity, endpoint string, ratio float64) []*resources.EnvironmentVariable {
+	if ratio == 0 {
+		ratio = 1
+	}
+	return []*resources.EnvironmentVariable{
+		resources.Env("OTEL_SERVICE_NAME", identity.Name),
+		resources.Env("OTEL_RESOURCE_ATTRIBUTES", fmt.Sprintf("service.namespace=%s,service.version=%s,codefly.workspace=%s",
+			identity.Module, identity.Version, identity.Workspace)),
+		resources.Env("OTEL_TRACES_EXPORTER", "otlp"),
+		resources.Env("OTEL_METRICS_EXPORTER", "none"),
+		resources.Env("OTEL_LOGS_EXPORTER", "none"),
+		resources.Env("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"),
+		resources.Env("OTEL_EXPORTER_OTLP_ENDPOINT", endpoint),
+		resources.Env("OTEL_TRACES_SAMPLER", "parentbased_traceidratio"),
+		resources.Env("OTEL_TRACES_SAMPLER_ARG", fmt.Sprintf("%g", ratio)),
+	}
+}
+
+// setupTracing starts the local receiver if needed and injects the OTEL_*
+// variables into the runner environment.
+func (s *Runtime) setupTracing(ctx context.Context) error {
+	settings := s.FastAPI.Settings
+	if !settings.TracingEnabled() {
+		return nil
+	}
+	if !declaresDependency(s.Service.SourceLocation, otelPackages[0]) {
+		s.Wool.Warn("tracing is enabled but the service does not install opentelemetry: uv add " + strings.Join(otelPackages, " "))
+	}
+	endpoint := settings.Observability.Endpoint
+	if endpoint == "" {
+		if s.traces == nil {
+			// The container reaches the agent through the docker bridge.
+			host := "127.0.0.1"
+			if s.Base.Runtime.IsContainerRuntime() {
+				host = "0.0.0.0"
+			}
+			receiver, err := startOTLPReceiver(ctx, net.JoinHostPort(host, "0"), filepath.Join(s.cacheLocation, tracesFile))
+			if err != nil {
+				return s.Wool.Wrapf(err, "cannot start otlp receiver")
+			}
+			s.mu.Lock()
+			s.traces = receiver
+			s.mu.Unlock()
+		}
+		endpoint = fmt.Sprintf("http://localhost:%d", s.traces.Port())
+		if s.Base.Runtime.IsContainerRuntime() {
+			endpoint = fmt.Sprintf("http://host.docker.internal:%d", s.traces.Port())
+		}
+		s.Wool.Info("writing traces locally", wool.FileField(s.traces.path))
+	}
+	s.runnerEnvironment.WithEnvironmentVariables(ctx, otelEnvironment(s.Identity, endpoint, settings.Observability.SampleRatio)...)
+	return nil
+}
+
+// otlpReceiver is a minimal OTLP/HTTP trace endpoint (POST /v1/traces,
+// protobuf or JSON, optionally gzipped) writing spans as JSON lines.
+type otlpReceiver struct {
+	listener net.Listener
+	server   *http.Server
+	path     string
+
+	mu  sync.Mutex
+	out *os.File
+}
+
+// startOTLPReceiver listens on address and truncates path: each session
+// starts with a fresh file.
+func startOTLPReceiver(ctx context.Context, address, path string) (*otlpReceiver, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	r := &otlpReceiver{listener: listener, path: path, out: out}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", r.handle)
+	r.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := r.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			wool.Get(ctx).In("otlp").Warn("otlp receiver stopped", wool.ErrField(err))
+		}
+	}()
+	return r, nil
+}
+
+// Port is the port the receiver listens on.
+func (r *otlpReceiver) Port() int {
+	return r.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the receiver and closes the traces file.
+func (r *otlpReceiver) Close(ctx context.Context) error {
+	err := r.server.Shutdown(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cerr := r.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *otlpReceiver) handle(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxOTLPRequest)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	export := &collectortrace.ExportTraceServiceRequest{}
+	jsonBody := strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+	if jsonBody {
+		err = protojson.Unmarshal(content, export)
+	} else {
+		err = proto.Unmarshal(content, export)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := r.write(export); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := &collectortrace.ExportTraceServiceResponse{}
+	var out []byte
+	if jsonBody {
+		w.Header().Set("Content-Type", "application/json")
+		out, _ = protojson.Marshal(resp)
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ = proto.Marshal(resp)
+	}
+	_, _ = w.Write(out)
+}
+
+// spanRecord is one line of traces.jsonl.
+type spanRecord struct {
+	Service    string         `json:"service,omitempty"`
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_span_id,omitempty"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Start      time.Time      `json:"start"`
+	DurationMs float64        `json:"duration_ms"`
+	Status     string         `json:"status,omitempty"`
+	Message    string         `json:"status_message,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+func (r *otlpReceiver) write(export *collectortrace.ExportTraceServiceRequest) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, resourceSpans := range export.GetResourceSpans() {
+		service, _ := attributes(resourceSpans.GetResource().GetAttributes())["service.name"].(string)
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			for _, span := range scopeSpans.GetSpans() {
+				if err := encoder.Encode(toSpanRecord(service, span)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.out.Write(buf.Bytes())
+	return err
+}
+
+func toSpanRecord(service string, span *tracev1.Span) spanRecord {
+	record := spanRecord{
+		Service:    service,
+		TraceID:    hex.EncodeToString(span.GetTraceId()),
+		SpanID:     hex.EncodeToString(span.GetSpanId()),
+		ParentID:   hex.EncodeToString(span.GetParentSpanId()),
+		Name:       span.GetName(),
+		Kind:       strings.TrimPrefix(span.GetKind().String(), "SPAN_KIND_"),
+		Start:      time.Unix(0, int64(span.GetStartTimeUnixNano())).UTC(),
+		DurationMs: float64(span.GetEndTimeUnixNano()-span.GetStartTimeUnixNano()) / float64(time.Millisecond),
+		Attributes: attributes(span.GetAttributes()),
+	}
+	if code := span.GetStatus().GetCode(); code != tracev1.Status_STATUS_CODE_UNSET {
+		record.Status = strings.TrimPrefix(code.String(), "STATUS_CODE_")
+		record.Message = span.GetStatus().GetMessage()
+	}
+	return record
+}
+
+func attributes(kvs []*commonv1.KeyValue) map[string]any {
+	if len(kvs) == 0 {
+		return nil
+	}
+	out := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		out[kv.GetKey()] = anyValue(kv.GetValue())
+	}
+	return out
+}
+
+func anyValue(v *commonv1.AnyValue) any {
+	switch value := v.GetValue().(type) {
+	case *commonv1.AnyValue_StringValue:
+		return value.StringValue
+	case *commonv1.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonv1.AnyValue_IntValue:
+		return value.IntValue
+	case *commonv1.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonv1.AnyValue_BytesValue:
+		return hex.EncodeToString(value.BytesValue)
+	case *commonv1.AnyValue_ArrayValue:
+		var values []any
+		for _, item := range value.ArrayValue.GetValues() {
+			values = append(values, anyValue(item))
+		}
+		return values
+	case *commonv1.AnyValue_KvlistValue:
+		return attributes(value.KvlistValue.GetValues())
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/resources"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func stringAttribute(key, value string) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: value}}}
+}
+
+// TestOTLPReceiver: a gzipped protobuf export, as sent by the Python
+// OTLP/HTTP exporter, lands in traces.jsonl as one line per span.
+func TestOTLPReceiver(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), tracesFile)
+	receiver, err := startOTLPReceiver(ctx, "127.0.0.1:0", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close(ctx)
+
+	export := &collectortrace.ExportTraceServiceRequest{ResourceSpans: []*tracev1.ResourceSpans{{
+		Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{stringAttribute("service.name", "items")}},
+		ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{{
+			TraceId:           bytes.Repeat([]byte{0xab}, 16),
+			SpanId:            bytes.Repeat([]byte{0x01}, 8),
+			Name:              "GET /items",
+			Kind:              tracev1.Span_SPAN_KIND_SERVER,
+			StartTimeUnixNano: 1_000_000_000,
+			EndTimeUnixNano:   1_012_500_000,
+			Attributes:        []*commonv1.KeyValue{stringAttribute("http.route", "/items")},
+			Status:            &tracev1.Status{Code: tracev1.Status_STATUS_CODE_ERROR, Message: "boom"},
+		}}}},
+	}}}
+	content, err := proto.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	_, _ = gz.Write(content)
+	_ = gz.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/v1/traces", receiver.Port()), &body)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export status %d", resp.StatusCode)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(written)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d spans, want 1: %s", len(lines), written)
+	}
+	var span spanRecord
+	if err := json.Unmarshal([]byte(lines[0]), &span); err != nil {
+		t.Fatal(err)
+	}
+	if span.Service != "items" || span.Name != "GET /items" || span.Kind != "SERVER" || span.DurationMs != 12.5 ||
+		span.Status != "ERROR" || span.Attributes["http.route"] != "/items" || span.TraceID != strings.Repeat("ab", 16) {
+		t.Errorf("unexpected span %s", lines[0])
+	}
+}
+
+func TestOTelEnvironment(t *testing.T) {
+	identity := &resources.ServiceIdentity{Name: "items", Module: "shop", Version: "0.1.0", Workspace: "demo"}
+	envs := map[string]any{}
+	for _, env := range otelEnvironment(identity, "http://localhost:4318", 0) {
+		envs[env.Key] = env.Value
+	}
+	want := map[string]any{
+		"OTEL_SERVICE_NAME":           "items",
+		"OTEL_RESOURCE_ATTRIBUTES":    "service.namespace=shop,service.version=0.1.0,codefly.workspace=demo",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf",
+		"OTEL_TRACES_SAMPLER_ARG":     "1",
+	}
+	for key, value := range want {
+		if envs[key] != value {
+			t.Errorf("%s = %v, want %v", key, envs[key], value)
+		}
+	}
+}
+
+func TestObservabilityValidate(t *testing.T) {
+	for _, o := range []*Observability{{SampleRatio: 1.5}, {Endpoint: "collector:4317"}} {
+		if err := (&Settings{Observability: o}).Validate(); err == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+	if err := (&Settings{Observability: &Observability{Enabled: true, Endpoint: "http://collector:4318", SampleRatio: 0.5}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+// openapi.go — keeping the published REST contract in sync with the code.
+//
+// Routes live in src/main.py, src/admin/router.py and the plugin routers,
+// so any Python change under code/src may change the spec. While running
+// with hot-reload, such changes are debounced, src/openapi.py regenerates
+// openapi/api.swagger.json, and a changed contract is swapped into
+// RestEndpoint. DesiredLoad then tells codefly to reload the endpoints so
+// dependent services see the new contract.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/protobuf/proto"
+)
+
+// openapiDebounce coalesces a burst of saves (multi-file refactors, the
+// watcher's own debounce notwithstanding) into one regeneration: running
+// src/openapi.py imports the whole app.
+const openapiDebounce = 2 * time.Second
+
+// debouncer runs fn once, delay after the last Trigger.
+type debouncer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	timer *time.Timer
+	fn    func()
+}
+
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	return &debouncer{delay: delay, fn: fn}
+}
+
+// Trigger (re)arms the timer.
+func (d *debouncer) Trigger() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.AfterFunc(d.delay, d.fn)
+}
+
+// Stop drops a pending run.
+func (d *debouncer) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// runOpenAPIScript runs src/openapi.py with uv in env.
+func runOpenAPIScript(ctx context.Context, env runners.RunnerEnvironment, sourceLocation string) error {
+	proc, err := env.NewProcess("uv", "run", "python", "src/openapi.py")
+	if err != nil {
+		return fmt.Errorf("cannot create openapi runner: %w", err)
+	}
+	proc.WithDir(sourceLocation)
+	proc.WithEnvironmentVariables(ctx, resources.Env("PYTHONPATH", sourceLocation))
+
+	if err := proc.Run(ctx); err != nil {
+		return fmt.Errorf("cannot run openapi: %w", err)
+	}
+	return nil
+}
+
+// refreshOpenAPI regenerates the spec and republishes the endpoint when the
+// contract changed. A failing generation (e.g. a syntax error mid-edit)
+// keeps the last good contract.
+func (s *Runtime) refreshOpenAPI(ctx context.Context) {
+	if err := s.GenerateOpenAPI(ctx); err != nil {
+		s.Wool.Warn("cannot regenerate Open API document, keeping the previous one", wool.ErrField(err))
+		return
+	}
+	changed, err := s.reloadRestAPI(ctx)
+	if err != nil {
+		s.Wool.Warn("cannot reload REST API", wool.ErrField(err))
+		return
+	}
+	if !changed {
+		s.Wool.Debug("Open API document unchanged")
+		return
+	}
+	s.Infof("REST API changed, republishing endpoint")
+	s.Base.Runtime.DesiredLoad()
+}
+
+// reloadRestAPI reads the generated spec into RestEndpoint. It reports
+// whether the contract differs from the published one.
+func (s *Runtime) reloadRestAPI(ctx context.Context) (bool, error) {
+	rest, err := resources.LoadRestAPI(ctx, shared.Pointer(s.Local(standards.OpenAPIPath)))
+	if err != nil {
+		return false, err
+	}
+	current := s.FastAPI.RestEndpoint
+	if current == nil {
+		return false, s.Wool.NewError("no REST endpoint loaded")
+	}
+	if published := resources.EndpointRestAPI(current); published != nil && bytes.Equal(published.Openapi, rest.Openapi) {
+		return false, nil
+	}
+
+	// Publish a new endpoint value rather than mutating the one codefly
+	// may still be reading.
+	updated := proto.Clone(current).(*basev0.Endpoint)
+	updated.ApiDetails = resources.ToRestAPI(rest)
+	for i, endpoint := range s.Endpoints {
+		if endpoint == current {
+			s.Endpoints[i] = updated
+		}
+	}
+	s.FastAPI.RestEndpoint = updated
+	return true, nil
+}
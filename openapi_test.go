@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/wool"
+)
+
+func TestDebouncerCoalesces(t *testing.T) {
+	var runs atomic.Int32
+	d := newDebouncer(50*time.Millisecond, func() { runs.Add(1) })
+	for range 5 {
+		d.Trigger()
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("expected one run for a burst, got %d", got)
+	}
+
+	d.Trigger()
+	d.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("Stop did not drop the pending run: %d runs", got)
+	}
+}
+
+const specV1 = `{"openapi": "3.1.0", "info": {"title": "src", "version": "0.0.0"}, "paths": {"/version": {"get": {"responses": {"200": {"description": "ok"}}}}}}`
+const specV2 = `{"openapi": "3.1.0", "info": {"title": "src", "version": "0.0.0"}, "paths": {"/version": {"get": {"responses": {"200": {"description": "ok"}}}}, "/items": {"post": {"responses": {"200": {"description": "ok"}}}}}}`
+
+// TestReloadRestAPI: a new route is republished on a fresh endpoint value;
+// an identical spec is not.
+func TestReloadRestAPI(t *testing.T) {
+	ctx := context.Background()
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(ctx).In("openapi-test")
+	rt.Location = t.TempDir()
+
+	spec := filepath.Join(rt.Location, standards.OpenAPIPath)
+	if err := os.MkdirAll(filepath.Dir(spec), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeSpec := func(content string) {
+		if err := os.WriteFile(spec, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeSpec(specV1)
+	rest, err := resources.LoadRestAPI(ctx, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := &basev0.Endpoint{Name: "rest", Api: standards.REST, ApiDetails: resources.ToRestAPI(rest)}
+	rt.FastAPI.RestEndpoint = original
+	rt.Endpoints = []*basev0.Endpoint{original}
+
+	changed, err := rt.reloadRestAPI(ctx)
+	if err != nil || changed {
+		t.Fatalf("identical spec: changed=%v err=%v", changed, err)
+	}
+
+	writeSpec(specV2)
+	changed, err = rt.reloadRestAPI(ctx)
+	if err != nil || !changed {
+		t.Fatalf("new route: changed=%v err=%v", changed, err)
+	}
+	if rt.FastAPI.RestEndpoint == original || rt.Endpoints[0] != rt.FastAPI.RestEndpoint {
+		t.Error("endpoint not republished")
+	}
+	if got := len(resources.EndpointRestAPI(rt.FastAPI.RestEndpoint).Groups); got != 2 {
+		t.Errorf("expected 2 route groups, got %d", got)
+	}
+	if got := len(resources.EndpointRestAPI(original).Groups); got != 1 {
+		t.Errorf("published endpoint was mutated: %d groups", got)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+// osv.go — a local OSV advisory database and PEP 440 version matching.
+//
+// The database is an OSV export, as published per ecosystem at
+// osv-vulnerabilities.storage.googleapis.com/PyPI/all.zip: a zip of
+// advisories, one JSON document each. A directory of such documents, or a
+// single JSON file holding one advisory or an array of them, works too.
+// Only the PyPI entries are kept, indexed by normalized package name.
+// Matching follows the OSV schema: the enumerated affected versions, then
+// the ECOSYSTEM ranges, evaluated with PEP 440 ordering.
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+)
+
+// osvEcosystem is the ecosystem of the packages uv locks.
+const osvEcosystem = "PyPI"
+
+type osvEvent struct {
+	Introduced   string `json:"introduced"`
+	Fixed        string `json:"fixed"`
+	LastAffected string `json:"last_affected"`
+}
+
+// osvAdvisory is the part of an OSV document matching needs.
+type osvAdvisory struct {
+	ID        string   `json:"id"`
+	Aliases   []string `json:"aliases"`
+	Summary   string   `json:"summary"`
+	Details   string   `json:"details"`
+	Withdrawn string   `json:"withdrawn"`
+	Affected  []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Versions []string `json:"versions"`
+		Ranges   []struct {
+			Type   string     `json:"type"`
+			Events []osvEvent `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+	DatabaseSpecific map[string]any `json:"database_specific"`
+}
+
+// advisoryDatabase indexes the PyPI advisories by normalized package name.
+type advisoryDatabase map[string][]*osvAdvisory
+
+// loadAdvisories reads a zip export, a directory of documents, or a JSON
+// file.
+func loadAdvisories(location string) (advisoryDatabase, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	db := advisoryDatabase{}
+	switch {
+	case info.IsDir():
+		err = filepath.WalkDir(location, func(p string, entry os.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !strings.HasSuffix(p, ".json") {
+				return err
+			}
+			content, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return db.add(p, content)
+		})
+	case strings.HasSuffix(location, ".zip"):
+		err = db.addZip(location)
+	default:
+		var content []byte
+		if content, err = os.ReadFile(location); err == nil {
+			err = db.add(location, content)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, advisories := range db {
+		sort.Slice(advisories, func(i, j int) bool { return advisories[i].ID < advisories[j].ID })
+	}
+	return db, nil
+}
+
+func (db advisoryDatabase) addZip(location string) error {
+	archive, err := zip.OpenReader(location)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if err := db.add(f.Name, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add indexes a document: one advisory, or an array of them.
+func (db advisoryDatabase) add(name string, content []byte) error {
+	var advisories []*osvAdvisory
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		if err := json.Unmarshal(content, &advisories); err != nil {
+			return fmt.Errorf("invalid advisories in %s: %w", name, err)
+		}
+	} else {
+		advisory := &osvAdvisory{}
+		if err := json.Unmarshal(content, advisory); err != nil {
+			return fmt.Errorf("invalid advisory in %s: %w", name, err)
+		}
+		advisories = append(advisories, advisory)
+	}
+	for _, advisory := range advisories {
+		if advisory.ID == "" || advisory.Withdrawn != "" {
+			continue
+		}
+		var packages []string
+		for _, affected := range advisory.Affected {
+			if affected.Package.Ecosystem != osvEcosystem {
+				continue
+			}
+			if pkg := normalizePackageName(affected.Package.Name); !slices.Contains(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+		for _, pkg := range packages {
+			db[pkg] = append(db[pkg], advisory)
+		}
+	}
+	return nil
+}
+
+// Size is the number of advisories.
+func (db advisoryDatabase) Size() int {
+	ids := map[string]bool{}
+	for _, advisories := range db {
+		for _, advisory := range advisories {
+			ids[advisory.ID] = true
+		}
+	}
+	return len(ids)
+}
+
+// Findings lists the advisories affecting a package version. An advisory
+// also published under an alias is reported once.
+func (db advisoryDatabase) Findings(name string, version string) []*builderv0.AuditFinding {
+	current, err := parsePackageVersion(version)
+	if err != nil {
+		return nil
+	}
+	var findings []*builderv0.AuditFinding
+	reported := map[string]bool{}
+	for _, advisory := range db[normalizePackageName(name)] {
+		if reported[advisory.ID] {
+			continue
+		}
+		affected, fixed := advisory.affects(name, current)
+		if !affected {
+			continue
+		}
+		reported[advisory.ID] = true
+		for _, alias := range advisory.Aliases {
+			reported[alias] = true
+		}
+		findings = append(findings, &builderv0.AuditFinding{
+			Severity:       advisory.severity(),
+			Id:             advisory.ID,
+			Package:        name,
+			CurrentVersion: version,
+			FixedVersion:   fixed,
+			Summary:        advisory.summary(),
+			Url:            "https://osv.dev/vulnerability/" + advisory.ID,
+		})
+	}
+	return findings
+}
+
+// affects reports whether the advisory covers the version, with the
+// lowest version fixing it, if any.
+func (a *osvAdvisory) affects(name string, version packageVersion) (bool, string) {
+	affected := false
+	var fixed *packageVersion
+	fixedName := ""
+	for _, entry := range a.Affected {
+		if entry.Package.Ecosystem != osvEcosystem || normalizePackageName(entry.Package.Name) != normalizePackageName(name) {
+			continue
+		}
+		for _, listed := range entry.Versions {
+			if v, err := parsePackageVersion(listed); err == nil && v.Compare(version) == 0 {
+				affected = true
+			}
+		}
+		for _, r := range entry.Ranges {
+			if r.Type != "ECOSYSTEM" {
+				continue
+			}
+			if inRange(r.Events, version) {
+				affected = true
+			}
+			for _, event := range r.Events {
+				v, err := parsePackageVersion(event.Fixed)
+				if event.Fixed == "" || err != nil || v.Compare(version) <= 0 {
+					continue
+				}
+				if fixed == nil || v.Compare(*fixed) < 0 {
+					fixed, fixedName = &v, event.Fixed
+				}
+			}
+		}
+	}
+	if !affected {
+		return false, ""
+	}
+	return true, fixedName
+}
+
+// inRange evaluates the events of a range in version order: introduced
+// opens it, fixed closes it at that version, last_affected right after.
+func inRange(events []osvEvent, version packageVersion) bool {
+	type bound struct {
+		version packageVersion
+		event   osvEvent
+	}
+	var bounds []bound
+	for _, event := range events {
+		raw := event.Introduced
+		if event.Fixed != "" {
+			raw = event.Fixed
+		} else if event.LastAffected != "" {
+			raw = event.LastAffected
+		}
+		if v, err := parsePackageVersion(raw); err == nil {
+			bounds = append(bounds, bound{v, event})
+		}
+	}
+	sort.SliceStable(bounds, func(i, j int) bool { return bounds[i].version.Compare(bounds[j].version) < 0 })
+	affected := false
+	for _, b := range bounds {
+		switch {
+		case b.event.Introduced != "" && version.Compare(b.version) >= 0:
+			affected = true
+		case b.event.Fixed != "" && version.Compare(b.version) >= 0:
+			affected = false
+		case b.event.LastAffected != "" && version.Compare(b.version) > 0:
+			affected = false
+		}
+	}
+	return affected
+}
+
+// severity is the advisory's named severity (GitHub advisories carry
+// one). Without it, the finding stays HIGH: an unrated vulnerability
+// must not slip through a HIGH-or-above policy.
+func (a *osvAdvisory) severity() builderv0.AuditFinding_Severity {
+	if named, ok := a.DatabaseSpecific["severity"].(string); ok {
+		if severity, err := parseAuditSeverity(named); err == nil {
+			return severity
+		}
+	}
+	return builderv0.AuditFinding_HIGH
+}
+
+func (a *osvAdvisory) summary() string {
+	if summary := strings.TrimSpace(a.Summary); summary != "" {
+		return summary
+	}
+	details, _, _ := strings.Cut(strings.TrimSpace(a.Details), "\n\n")
+	if len(details) > 300 {
+		details = details[:300]
+	}
+	return details
+}
+
+// parseAuditSeverity reads a severity name; MODERATE is GitHub's MEDIUM.
+func parseAuditSeverity(name string) (builderv0.AuditFinding_Severity, error) {
+	switch strings.ToUpper(name) {
+	case "LOW":
+		return builderv0.AuditFinding_LOW, nil
+	case "MEDIUM", "MODERATE":
+		return builderv0.AuditFinding_MEDIUM, nil
+	case "HIGH":
+		return builderv0.AuditFinding_HIGH, nil
+	case "CRITICAL":
+		return builderv0.AuditFinding_CRITICAL, nil
+	default:
+		return builderv0.AuditFinding_UNKNOWN, fmt.Errorf("unknown severity %q (expected low, medium, high or critical)", name)
+	}
+}
+
+var packageSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePackageName is the PEP 503 name: Typing_Extensions is
+// typing-extensions.
+func normalizePackageName(name string) string {
+	return packageSeparators.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// packageVersion is a PEP 440 version; the local label is ignored.
+type packageVersion struct {
+	epoch   int
+	release []int
+	// pre is the pre-release phase (a, b, rc as 0, 1, 2) and number.
+	pre  *[2]int
+	post *int
+	dev  *int
+}
+
+var pep440 = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)` +
+	`(?:[-_.]?(alpha|a|beta|b|preview|pre|c|rc)[-_.]?(\d+)?)?` +
+	`(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d+)?)?` +
+	`(?:[-_.]?(dev)[-_.]?(\d+)?)?` +
+	`(?:\+[a-z0-9]+(?:[-_.][a-z0-9]+)*)?$`)
+
+func parsePackageVersion(raw string) (packageVersion, error) {
+	m := pep440.FindStringSubmatch(strings.ToLower(strings.TrimSpace(raw)))
+	if m == nil {
+		return packageVersion{}, fmt.Errorf("invalid version %q", raw)
+	}
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	v := packageVersion{epoch: number(m[1])}
+	for _, part := range strings.Split(m[2], ".") {
+		v.release = append(v.release, number(part))
+	}
+	if m[3] != "" {
+		phase := map[string]int{"alpha": 0, "a": 0, "beta": 1, "b": 1, "preview": 2, "pre": 2, "c": 2, "rc": 2}[m[3]]
+		v.pre = &[2]int{phase, number(m[4])}
+	}
+	if m[5] != "" || m[6] != "" {
+		post := number(m[5] + m[7])
+		v.post = &post
+	}
+	if m[8] != "" {
+		dev := number(m[9])
+		v.dev = &dev
+	}
+	return v, nil
+}
+
+// Compare orders versions as pip does; 1.0 is 1.0.0.
+func (v packageVersion) Compare(o packageVersion) int {
+	if c := cmp.Compare(v.epoch, o.epoch); c != 0 {
+		return c
+	}
+	for i := 0; i < max(len(v.release), len(o.release)); i++ {
+		if c := cmp.Compare(v.segment(i), o.segment(i)); c != 0 {
+			return c
+		}
+	}
+	if c := slices.Compare(v.preKey(), o.preKey()); c != 0 {
+		return c
+	}
+	// No post-release sorts first, no development release last.
+	if c := cmp.Compare(orDefault(v.post, -1), orDefault(o.post, -1)); c != 0 {
+		return c
+	}
+	return cmp.Compare(orDefault(v.dev, math.MaxInt), orDefault(o.dev, math.MaxInt))
+}
+
+func (v packageVersion) segment(i int) int {
+	if i < len(v.release) {
+		return v.release[i]
+	}
+	return 0
+}
+
+// preKey sorts a development release of a final before its pre-releases,
+// and the final after them.
+func (v packageVersion) preKey() []int {
+	switch {
+	case v.pre != nil:
+		return v.pre[:]
+	case v.post == nil && v.dev != nil:
+		return []int{-1}
+	default:
+		return []int{3}
+	}
+}
+
+func orDefault(n *int, none int) int {
+	if n == nil {
+		return none
+	}
+	return *n
+}
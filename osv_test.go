@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+)
+
+func TestPackageVersionCompare(t *testing.T) {
+	// Each version sorts strictly before the next one.
+	ordered := []string{"1.0.dev0", "1.0a1.dev1", "1.0a1", "1.0a2", "1.0b1", "1.0rc1", "1.0", "1.0.post1.dev0", "1.0.post1", "1.0.1", "1.1", "1!0.1"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, err := parsePackageVersion(ordered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := parsePackageVersion(ordered[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Compare(b) >= 0 || b.Compare(a) <= 0 {
+			t.Errorf("%s must sort before %s", ordered[i], ordered[i+1])
+		}
+	}
+	for a, b := range map[string]string{"1.0": "1.0.0", "1.0-1": "1.0.post1", "1.0RC1": "1.0rc1", "2.0+local.1": "2.0", "v3": "3"} {
+		va, _ := parsePackageVersion(a)
+		vb, _ := parsePackageVersion(b)
+		if va.Compare(vb) != 0 {
+			t.Errorf("%s must equal %s", a, b)
+		}
+	}
+	if _, err := parsePackageVersion("latest"); err == nil {
+		t.Error("invalid version accepted")
+	}
+}
+
+func TestInRange(t *testing.T) {
+	events := []osvEvent{{Introduced: "0"}, {Fixed: "0.109.1"}, {Introduced: "0.110.0"}, {LastAffected: "0.110.2"}}
+	for version, want := range map[string]bool{"0.100.0": true, "0.109.1": false, "0.109.5": false, "0.110.0": true, "0.110.2": true, "0.110.3": false} {
+		v, _ := parsePackageVersion(version)
+		if got := inRange(events, v); got != want {
+			t.Errorf("%s: got %v", version, got)
+		}
+	}
+}
+
+const advisoriesSample = `[
+  {
+    "id": "GHSA-qf9m-vfgh-m389",
+    "aliases": ["CVE-2024-24762", "PYSEC-2024-38"],
+    "summary": "FastAPI Content-Type Header ReDoS",
+    "affected": [{
+      "package": {"ecosystem": "PyPI", "name": "fastapi"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "0.109.1"}]}]
+    }],
+    "database_specific": {"severity": "HIGH"}
+  },
+  {
+    "id": "PYSEC-2024-38",
+    "aliases": ["CVE-2024-24762", "GHSA-qf9m-vfgh-m389"],
+    "details": "A ReDoS in the form parser.\n\nMore details.",
+    "affected": [{
+      "package": {"ecosystem": "PyPI", "name": "fastapi"},
+      "versions": ["0.108.0", "0.109.0"]
+    }]
+  },
+  {
+    "id": "GHSA-2jv5-9r88-3w3p",
+    "summary": "python-multipart: Content-Type Header ReDoS",
+    "affected": [{
+      "package": {"ecosystem": "PyPI", "name": "Python_Multipart"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "0.0.7"}]}]
+    }],
+    "database_specific": {"severity": "MODERATE"}
+  },
+  {
+    "id": "GHSA-withdrawn",
+    "withdrawn": "2024-03-01T00:00:00Z",
+    "affected": [{"package": {"ecosystem": "PyPI", "name": "pydantic"}, "versions": ["2.9.2"]}]
+  },
+  {
+    "id": "GO-2024-0001",
+    "affected": [{"package": {"ecosystem": "Go", "name": "pydantic"}, "versions": ["2.9.2"]}]
+  }
+]`
+
+func TestAdvisoryFindings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "advisories.json")
+	if err := os.WriteFile(file, []byte(advisoriesSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := loadAdvisories(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Size() != 3 {
+		t.Errorf("got %d advisories, want the PyPI ones not withdrawn", db.Size())
+	}
+
+	// Published twice, reported once.
+	findings := db.Findings("fastapi", "0.109.0")
+	if len(findings) != 1 {
+		t.Fatalf("got %v", findings)
+	}
+	f := findings[0]
+	if f.Id != "GHSA-qf9m-vfgh-m389" || f.FixedVersion != "0.109.1" || f.Severity != builderv0.AuditFinding_HIGH || f.Summary != "FastAPI Content-Type Header ReDoS" {
+		t.Errorf("got %v", f)
+	}
+	if findings := db.Findings("fastapi", "0.115.0"); len(findings) != 0 {
+		t.Errorf("got %v", findings)
+	}
+	if findings := db.Findings("python-multipart", "0.0.6"); len(findings) != 1 || findings[0].Severity != builderv0.AuditFinding_MEDIUM {
+		t.Errorf("names must be normalized, got %v", findings)
+	}
+	if findings := db.Findings("pydantic", "2.9.2"); len(findings) != 0 {
+		t.Errorf("got %v", findings)
+	}
+}
+
+// writeAdvisoriesZip writes an OSV export with one fastapi advisory.
+func writeAdvisoriesZip(t *testing.T) string {
+	archive := filepath.Join(t.TempDir(), "PyPI.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("PYSEC-2024-38.json")
+	if _, err := entry.Write([]byte(`{"id": "PYSEC-2024-38", "summary": "ReDoS", "affected": [{"package": {"ecosystem": "PyPI", "name": "fastapi"}, "versions": ["0.109.0"]}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestLoadAdvisoriesZip(t *testing.T) {
+	db, err := loadAdvisories(writeAdvisoriesZip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := db.Findings("FastAPI", "0.109.0"); len(findings) != 1 || findings[0].Severity != builderv0.AuditFinding_HIGH {
+		t.Errorf("an unrated advisory must stay HIGH, got %v", findings)
+	}
+}
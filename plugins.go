@@ -0,0 +1,342 @@
+package main
+
+// plugins.go — add-plugin / remove-plugin builder commands.
+//
+// A plugin is a package under src/plugins/<name> (router, models, and the
+// Plugin instance in __init__.py) with its tests under tests/plugins/<name>.
+// It is wired into src/plugins/registry.py by an edit driven by Python's
+// ast module: the import goes after the last top-level import and the
+// entry into the `plugins` list literal, with everything else in the file
+// left byte for byte. The edited file is parsed again before it is written.
+// Both commands regenerate the OpenAPI document afterwards.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/templates"
+)
+
+// pluginRegistry is relative to the source location.
+const pluginRegistry = "src/plugins/registry.py"
+
+// defaultPluginEntry is what a plugin package binds to its Plugin in
+// __init__.py, as the scaffolded ones do.
+const defaultPluginEntry = "plugin"
+
+// pluginFiles are the templates of a plugin and their destination,
+// relative to the source location (%[1]s is the plugin name). __init__.py
+// comes from package.py: embed skips files starting with an underscore.
+var pluginFiles = []struct{ template, target string }{
+	{"templates/plugin/package.py", "src/plugins/%[1]s/__init__.py"},
+	{"templates/plugin/models.py", "src/plugins/%[1]s/models.py"},
+	{"templates/plugin/router.py", "src/plugins/%[1]s/router.py"},
+	{"templates/plugin/test_plugin.py", "tests/plugins/%[1]s/test_%[1]s.py"},
+}
+
+var pluginNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// reservedPluginNames would shadow the registry or be invalid imports.
+var reservedPluginNames = map[string]bool{
+	"registry": true,
+	// Python keywords matching the pattern.
+	"and": true, "as": true, "assert": true, "async": true, "await": true, "break": true,
+	"class": true, "continue": true, "def": true, "del": true, "elif": true, "else": true,
+	"except": true, "finally": true, "for": true, "from": true, "global": true, "if": true,
+	"import": true, "in": true, "is": true, "lambda": true, "nonlocal": true, "not": true,
+	"or": true, "pass": true, "raise": true, "return": true, "try": true, "while": true,
+	"with": true, "yield": true,
+}
+
+// validatePluginName accepts a Python package name.
+func validatePluginName(name string) error {
+	if !pluginNamePattern.MatchString(name) {
+		return fmt.Errorf("plugin name %q must be a lowercase Python identifier (letters, digits, _)", name)
+	}
+	if reservedPluginNames[name] {
+		return fmt.Errorf("plugin name %q is reserved", name)
+	}
+	return nil
+}
+
+// PluginTemplate is the context of the plugin templates.
+type PluginTemplate struct {
+	// Name is the package name.
+	Name string
+	// Prefix is the route prefix: /<name> with dashes.
+	Prefix string
+	// Model is the CamelCase name of the plugin's models.
+	Model string
+}
+
+func newPluginTemplate(name string) PluginTemplate {
+	var model strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			model.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return PluginTemplate{
+		Name:   name,
+		Prefix: "/" + strings.ReplaceAll(name, "_", "-"),
+		Model:  model.String(),
+	}
+}
+
+// registerPluginCommands exposes add-plugin and remove-plugin.
+func (s *Builder) registerPluginCommands() {
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "add-plugin",
+		Description: "Scaffold a FastAPI plugin (router, models, tests) under src/plugins/<name>, register it in src/plugins/registry.py and regenerate the OpenAPI document.",
+		Usage:       "add-plugin orders",
+		Tags:        []string{"scaffold", "plugin"},
+	}, s.cmdAddPlugin)
+
+	s.RegisterCommand(&agentv0.CommandDefinition{
+		Name:        "remove-plugin",
+		Description: "Unregister a FastAPI plugin from src/plugins/registry.py, delete its package and tests, and regenerate the OpenAPI document.",
+		Usage:       "remove-plugin orders",
+		Tags:        []string{"scaffold", "plugin"},
+		Destructive: true,
+	}, s.cmdRemovePlugin)
+}
+
+func (s *Builder) cmdAddPlugin(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: add-plugin <name>")
+	}
+	name := args[0]
+	if err := validatePluginName(name); err != nil {
+		return "", err
+	}
+	pkg := path.Join(s.Service.SourceLocation, "src/plugins", name)
+	if _, err := os.Stat(pkg); err == nil {
+		return "", fmt.Errorf("plugin %s already exists in %s", name, pkg)
+	}
+
+	tmpl := newPluginTemplate(name)
+	var out []string
+	for _, f := range pluginFiles {
+		target := fmt.Sprintf(f.target, name)
+		content, err := templates.ApplyTemplateFrom(ctx, shared.Embed(pluginFS), f.template, tmpl)
+		if err != nil {
+			return "", s.Wool.Wrapf(err, "cannot render %s", target)
+		}
+		destination := path.Join(s.Service.SourceLocation, target)
+		if _, err := shared.CheckDirectoryOrCreate(ctx, path.Dir(destination)); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(destination, []byte(content), 0o644); err != nil {
+			return "", err
+		}
+		out = append(out, "created "+target)
+	}
+
+	changed, err := s.editPluginRegistry(ctx, "add", name, defaultPluginEntry)
+	if err != nil {
+		// An unregistered package is dead code: take it back out.
+		for _, dir := range []string{"src/plugins/" + name, "tests/plugins/" + name} {
+			_ = os.RemoveAll(path.Join(s.Service.SourceLocation, dir))
+		}
+		return "", err
+	}
+	if changed {
+		out = append(out, "registered in "+pluginRegistry)
+	}
+	out = append(out, s.regenerateOpenAPI(ctx))
+	return strings.Join(out, "\n"), nil
+}
+
+func (s *Builder) cmdRemovePlugin(ctx context.Context, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: remove-plugin <name>")
+	}
+	name := args[0]
+	if err := validatePluginName(name); err != nil {
+		return "", err
+	}
+
+	// Unregister first: a registry importing a deleted package breaks the app.
+	changed, err := s.editPluginRegistry(ctx, "remove", name, "")
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	if changed {
+		out = append(out, "unregistered from "+pluginRegistry)
+	}
+	for _, dir := range []string{"src/plugins/" + name, "tests/plugins/" + name} {
+		p := path.Join(s.Service.SourceLocation, dir)
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return strings.Join(out, "\n"), err
+		}
+		out = append(out, "removed "+dir)
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("no plugin %s", name)
+	}
+	// A contributed plugin removed by hand is no longer the agent's.
+	contributed, err := loadContributions(path.Join(s.Service.SourceLocation, contributionsFile))
+	if err != nil {
+		return strings.Join(out, "\n"), err
+	}
+	if record := contributed.find(name); record != nil {
+		contributed.delete(name)
+		if err := contributed.save(); err != nil {
+			return strings.Join(out, "\n"), err
+		}
+		out = append(out, "dropped the contribution of "+record.Agent)
+	}
+	out = append(out, s.regenerateOpenAPI(ctx))
+	return strings.Join(out, "\n"), nil
+}
+
+// editPluginRegistry adds or removes the plugin in registry.py and reports
+// whether the file changed. add imports entry from the package, replacing
+// another entry it imported; remove ignores entry. It only needs the
+// standard library, so it runs on the standalone interpreter whatever the
+// runtime mode.
+func (s *Builder) editPluginRegistry(ctx context.Context, action string, name string, entry string) (bool, error) {
+	env := runners.ResolveStandaloneEnvironment(ctx, s.Service.SourceLocation, s.FastAPI.runtimeContext())
+	proc, err := env.NewProcess("python3", "-c", registryEditScript, action, path.Join(s.Service.SourceLocation, pluginRegistry), name, pluginEntry(entry))
+	if err != nil {
+		return false, s.Wool.Wrapf(err, "cannot create registry editor")
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	if err := proc.Run(ctx); err != nil {
+		return false, fmt.Errorf("cannot edit %s: %w: %s", pluginRegistry, err, strings.TrimSpace(output.String()))
+	}
+	var result struct {
+		Changed bool `json:"changed"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(output.Bytes()), &result); err != nil {
+		return false, fmt.Errorf("unexpected registry editor output %q: %w", output.String(), err)
+	}
+	return result.Changed, nil
+}
+
+// regenerateOpenAPI runs src/openapi.py in the service's environment. The
+// plugin change stands either way: a failure is reported, not returned.
+func (s *Builder) regenerateOpenAPI(ctx context.Context) string {
+	if err := runOpenAPIScript(ctx, s.FastAPI.serviceEnvironment(ctx), s.Service.SourceLocation); err != nil {
+		return fmt.Sprintf("OpenAPI document not regenerated (%v): run the service or src/openapi.py", err)
+	}
+	return "regenerated OpenAPI document"
+}
+
+// serviceEnvironment is the runtime's environment when it is initialized
+// in this agent, else a standalone one (nix or native).
+func (s *Service) serviceEnvironment(ctx context.Context) runners.RunnerEnvironment {
+	if env := s.Service.ActiveEnv; env != nil {
+		return env
+	}
+	return runners.ResolveStandaloneEnvironment(ctx, s.Service.SourceLocation, s.runtimeContext())
+}
+
+func (s *Service) runtimeContext() *basev0.RuntimeContext {
+	if s.Base.Runtime == nil {
+		return nil
+	}
+	return s.Base.Runtime.RuntimeContext
+}
+
+// registryEditScript edits the registry: python3 -c <script> add|remove
+// <registry.py> <name> <entry>. It prints {"changed": bool}.
+const registryEditScript = `
+import ast, json, sys
+
+action, path, name, symbol = sys.argv[1:5]
+module = "src.plugins." + name
+
+with open(path, "rb") as f:
+    source = f.read()
+tree = ast.parse(source, filename=path)
+
+starts = [0]
+for line in source.splitlines(keepends=True):
+    starts.append(starts[-1] + len(line))
+
+def offset(lineno, col):
+    # ast columns are UTF-8 byte offsets, as are these.
+    return starts[lineno - 1] + col
+
+registry = None
+for node in tree.body:
+    if isinstance(node, ast.AnnAssign):
+        target = node.target
+    elif isinstance(node, ast.Assign) and len(node.targets) == 1:
+        target = node.targets[0]
+    else:
+        continue
+    if isinstance(target, ast.Name) and target.id == "plugins":
+        registry = node.value
+if not isinstance(registry, ast.List):
+    sys.exit(path + ": no plugins = [...] list literal to edit")
+
+elements = [source[offset(e.lineno, e.col_offset):offset(e.end_lineno, e.end_col_offset)].decode() for e in registry.elts]
+names = [e.id if isinstance(e, ast.Name) else None for e in registry.elts]
+imports = [n for n in tree.body if isinstance(n, ast.ImportFrom) and n.module == module]
+bound = [a.asname or a.name for n in imports for a in n.names if a.name == symbol]
+stale = [a.asname or a.name for n in imports for a in n.names if a.name != symbol]
+
+def render(items):
+    if not items:
+        return "[]"
+    return "[\n" + "".join("    " + item + ",\n" for item in items) + "]"
+
+edits = []
+list_span = (offset(registry.lineno, registry.col_offset), offset(registry.end_lineno, registry.end_col_offset))
+if action == "add":
+    entry = bound[0] if bound else name + "_plugin"
+    # Another entry of the package is replaced.
+    kept = [e for e, n in zip(elements, names) if n is None or n == entry or n not in stale]
+    for n in imports:
+        if not any(a.name == symbol for a in n.names):
+            edits.append((starts[n.lineno - 1], starts[n.end_lineno], ""))
+    if not bound:
+        top = [n for n in tree.body if isinstance(n, (ast.Import, ast.ImportFrom))]
+        at = starts[top[-1].end_lineno] if top else 0
+        line = "from " + module + " import " + symbol + " as " + entry + "\n"
+        if at == len(source) and source and not source.endswith(b"\n"):
+            line = "\n" + line
+        edits.append((at, at, line))
+    if entry not in names:
+        kept.append(entry)
+    if kept != elements:
+        edits.append(list_span + (render(kept),))
+elif action == "remove":
+    kept = [e for e, n in zip(elements, names) if n is None or n not in bound + stale]
+    if len(kept) != len(elements):
+        edits.append(list_span + (render(kept),))
+    for n in imports:
+        edits.append((starts[n.lineno - 1], starts[n.end_lineno], ""))
+else:
+    sys.exit("unknown action " + action)
+
+for start, end, text in sorted(edits, key=lambda e: e[0], reverse=True):
+    source = source[:start] + text.encode() + source[end:]
+
+if edits:
+    check = ast.parse(source, filename=path)
+    imported = any(isinstance(n, ast.ImportFrom) and n.module == module for n in check.body)
+    if imported != (action == "add"):
+        sys.exit(path + ": edit verification failed")
+    with open(path, "wb") as f:
+        f.write(source)
+print(json.dumps({"changed": bool(edits)}))
+`
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/wool"
+)
+
+func TestValidatePluginName(t *testing.T) {
+	for _, name := range []string{"orders", "order_items", "v2"} {
+		if err := validatePluginName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Orders", "order-items", "2fa", "registry", "import", "../x"} {
+		if err := validatePluginName(name); err == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+}
+
+func TestNewPluginTemplate(t *testing.T) {
+	got := newPluginTemplate("order_items")
+	want := PluginTemplate{Name: "order_items", Prefix: "/order-items", Model: "OrderItems"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+const scaffoldedRegistry = `from src.framework.plugin import Plugin
+
+# Plugin registry — module agents add plugin instances here.
+plugins: list[Plugin] = []
+`
+
+// pluginTestBuilder is a Builder on a source location holding the
+// scaffolded registry.
+func pluginTestBuilder(t *testing.T) *Builder {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+	b := NewBuilder(NewService())
+	b.Wool = wool.Get(context.Background()).In("plugins-test")
+	b.Service.SourceLocation = t.TempDir()
+	registry := filepath.Join(b.Service.SourceLocation, pluginRegistry)
+	if err := os.MkdirAll(filepath.Dir(registry), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(registry, []byte(scaffoldedRegistry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readRegistry(t *testing.T, b *Builder) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(b.Service.SourceLocation, pluginRegistry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestEditPluginRegistry(t *testing.T) {
+	b := pluginTestBuilder(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"orders", "billing"} {
+		if changed, err := b.editPluginRegistry(ctx, "add", name, defaultPluginEntry); err != nil || !changed {
+			t.Fatalf("add %s: changed=%v err=%v", name, changed, err)
+		}
+	}
+	want := `from src.framework.plugin import Plugin
+from src.plugins.orders import plugin as orders_plugin
+from src.plugins.billing import plugin as billing_plugin
+
+# Plugin registry — module agents add plugin instances here.
+plugins: list[Plugin] = [
+    orders_plugin,
+    billing_plugin,
+]
+`
+	if got := readRegistry(t, b); got != want {
+		t.Fatalf("after add:\n%s", got)
+	}
+
+	if changed, err := b.editPluginRegistry(ctx, "add", "orders", defaultPluginEntry); err != nil || changed {
+		t.Errorf("adding twice: changed=%v err=%v", changed, err)
+	}
+
+	for _, name := range []string{"orders", "billing"} {
+		if changed, err := b.editPluginRegistry(ctx, "remove", name, ""); err != nil || !changed {
+			t.Fatalf("remove %s: changed=%v err=%v", name, changed, err)
+		}
+	}
+	if got := readRegistry(t, b); got != scaffoldedRegistry {
+		t.Errorf("add then remove must restore the registry, got:\n%s", got)
+	}
+}
+
+func TestEditPluginRegistryKeepsHandWrittenEntries(t *testing.T) {
+	b := pluginTestBuilder(t)
+	registry := filepath.Join(b.Service.SourceLocation, pluginRegistry)
+	custom := `from src.framework.plugin import Plugin
+from src.auth import auth_plugin  # hand-written
+
+plugins: list[Plugin] = [auth_plugin]
+`
+	if err := os.WriteFile(registry, []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.editPluginRegistry(context.Background(), "add", "orders", defaultPluginEntry); err != nil {
+		t.Fatal(err)
+	}
+	got := readRegistry(t, b)
+	for _, want := range []string{"from src.auth import auth_plugin  # hand-written\nfrom src.plugins.orders import plugin as orders_plugin\n", "    auth_plugin,\n    orders_plugin,\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("registry misses %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestEditPluginRegistryRejectsUnknownLayout(t *testing.T) {
+	b := pluginTestBuilder(t)
+	registry := filepath.Join(b.Service.SourceLocation, pluginRegistry)
+	if err := os.WriteFile(registry, []byte("plugins = load_plugins()\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.editPluginRegistry(context.Background(), "add", "orders", defaultPluginEntry); err == nil {
+		t.Error("expected an error without a plugins list literal")
+	}
+	if got := readRegistry(t, b); got != "plugins = load_plugins()\n" {
+		t.Errorf("registry modified: %q", got)
+	}
+}
+
+func TestAddAndRemovePlugin(t *testing.T) {
+	b := pluginTestBuilder(t)
+	ctx := context.Background()
+
+	out, err := b.cmdAddPlugin(ctx, []string{"order_items"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"src/plugins/order_items/__init__.py", "src/plugins/order_items/router.py", "src/plugins/order_items/models.py", "tests/plugins/order_items/test_order_items.py"} {
+		p := filepath.Join(b.Service.SourceLocation, f)
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s not created", f)
+			continue
+		}
+		if compiled, err := exec.Command("python3", "-m", "py_compile", p).CombinedOutput(); err != nil {
+			t.Errorf("%s is not valid Python: %s", f, compiled)
+		}
+	}
+	if !strings.Contains(out, "registered in "+pluginRegistry) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if _, err := b.cmdAddPlugin(ctx, []string{"order_items"}); err == nil {
+		t.Error("adding an existing plugin must fail")
+	}
+
+	if _, err := b.cmdRemovePlugin(ctx, []string{"order_items"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(b.Service.SourceLocation, "src/plugins/order_items")); !os.IsNotExist(err) {
+		t.Error("plugin package still there")
+	}
+	if got := readRegistry(t, b); got != scaffoldedRegistry {
+		t.Errorf("registry not restored:\n%s", got)
+	}
+	if _, err := b.cmdRemovePlugin(ctx, []string{"order_items"}); err == nil {
+		t.Error("removing a missing plugin must fail")
+	}
+}
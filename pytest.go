@@ -0,0 +1,398 @@
+package main
+
+// pytest.go — Test with per-test results and coverage.
+//
+// The inherited Test runs pytest on the host and only scrapes a total
+// coverage figure from the terminal. The FastAPI runtime runs pytest in
+// the runner environment (native, nix or Docker) with a JUnit XML and a
+// Cobertura coverage report under .cache/tests, and parses both: every
+// test comes back with its duration, failure message and the deepest
+// frame of its traceback; coverage comes back per file, with line and
+// branch totals. Formula runs and structured selections stay with the
+// inherited implementation, which owns them.
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	runtimev0 "github.com/codefly-dev/core/generated/go/codefly/services/runtime/v0"
+	"github.com/codefly-dev/core/resources"
+	runners "github.com/codefly-dev/core/runners/base"
+	pythonhelpers "github.com/codefly-dev/core/runners/python"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reports are relative to the source location, and kept after the run:
+// coverage.xml is the raw artifact of the response.
+const (
+	testReportsDir = ".cache/tests"
+	junitReport    = "junit.xml"
+	coverageReport = "coverage.xml"
+	testOutput     = "output.txt"
+)
+
+// Test runs pytest with JUnit and coverage reports and returns them parsed.
+// A failing run is reported in the response, not as an error. The run is
+// set up under the lifecycle operation lock, so Destroy cannot take the
+// runner environment away meanwhile, and goes on outside it.
+func (s *Runtime) Test(ctx context.Context, req *runtimev0.TestRequest) (*runtimev0.TestResponse, error) {
+	if req.GetFormula() != nil || req.GetSelection() != nil {
+		return s.Runtime.Test(ctx, req)
+	}
+	if err := s.lifecycle.begin(opTest); err != nil {
+		return nil, err
+	}
+	if s.runnerEnvironment == nil {
+		s.lifecycle.end(opTest, false)
+		return nil, status.Errorf(codes.FailedPrecondition, "Test needs the runner environment of Init")
+	}
+	if s.Base.Service.Test != nil {
+		s.lifecycle.end(opTest, true)
+		return s.Runtime.Test(ctx, req)
+	}
+	runner := s.newPytestRunner()
+	reports := path.Join(s.Service.SourceLocation, testReportsDir)
+	s.lifecycle.end(opTest, true)
+
+	defer runner.wool.Catch()
+	ctx = runner.wool.Inject(ctx)
+	return runner.run(ctx, req, reports, true), nil
+}
+
+// pytestRunner is what a pytest run takes from the Runtime, copied when
+// the run is set up: runs go on outside the lifecycle operation lock.
+type pytestRunner struct {
+	wool *wool.Wool
+	env  runners.RunnerEnvironment
+	// dir is the source location.
+	dir  string
+	envs []*resources.EnvironmentVariable
+}
+
+func (s *Runtime) newPytestRunner() *pytestRunner {
+	r := &pytestRunner{wool: s.Wool, env: s.runnerEnvironment, dir: s.Service.SourceLocation}
+	if envs, err := s.EnvironmentVariables.All(); err == nil {
+		r.envs = envs
+	}
+	return r
+}
+
+// run runs pytest with its reports in the reports directory; coverage
+// adds the Cobertura report.
+func (r *pytestRunner) run(ctx context.Context, req *runtimev0.TestRequest, reports string, coverage bool) *runtimev0.TestResponse {
+	if err := os.MkdirAll(reports, 0o755); err != nil {
+		return testErrorResponse(err)
+	}
+	// A report left by the previous run would pass for this one's.
+	for _, report := range []string{junitReport, coverageReport} {
+		if err := os.Remove(path.Join(reports, report)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return testErrorResponse(err)
+		}
+	}
+
+	args := pytestArgs(req, reports, coverage, coverage && !declaresDependency(r.dir, "pytest-cov"))
+	r.wool.Debug("running tests", wool.Field("args", args))
+	proc, err := r.env.NewProcess("uv", args...)
+	if err != nil {
+		return testErrorResponse(r.wool.Wrapf(err, "cannot create pytest runner"))
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	proc.WithDir(r.dir)
+	proc.WithEnvironmentVariables(ctx, r.envs...)
+	proc.WithEnvironmentVariables(ctx, resources.Env("PYTHONPATH", r.dir))
+
+	started := time.Now()
+	runErr := proc.Run(ctx)
+	duration := time.Since(started)
+	if err := os.WriteFile(path.Join(reports, testOutput), output.Bytes(), 0o644); err != nil {
+		r.wool.Warn("cannot keep the test output", wool.ErrField(err))
+	}
+
+	junit, _ := os.ReadFile(path.Join(reports, junitReport))
+	run := pythonhelpers.ParsePytestJUnit(string(junit), 0)
+	run.RawOutput = output.String()
+	if runErr != nil && len(run.Suites) == 0 {
+		// No report: pytest did not get to the tests.
+		run.EnvError = pythonhelpers.ClassifyEnvError(run.RawOutput, runErr)
+	}
+	var summary *coverageSummary
+	if coverage {
+		summary, err = parseCoverageReport(path.Join(reports, coverageReport), r.dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.wool.Warn("cannot read the coverage report", wool.ErrField(err))
+		}
+	}
+	if summary != nil {
+		run.CoveragePct = summary.TotalPct()
+	}
+
+	resp := run.ToProtoResponse("pytest", req.Suite, duration)
+	locateTestCases(resp)
+	if summary != nil {
+		resp.Coverage = summary.Proto()
+		resp.Result.Message += "; coverage: " + summary.String()
+		resp.Status.Message = resp.Result.Message
+	}
+	r.wool.Forwardf("Tests: %s", resp.Result.Message)
+	return resp
+}
+
+// pytestArgs is the uv command line. withCov adds pytest-cov to the run
+// for projects that do not declare it.
+func pytestArgs(req *runtimev0.TestRequest, reports string, coverage bool, withCov bool) []string {
+	args := []string{"run"}
+	if withCov {
+		args = append(args, "--with", "pytest-cov")
+	}
+	args = append(args, "pytest", "--tb=short", "--junitxml="+path.Join(reports, junitReport))
+	if coverage {
+		args = append(args, "--cov=src", "--cov-branch", "--cov-report=xml:"+path.Join(reports, coverageReport))
+	}
+	if req.Verbose {
+		args = append(args, "-v")
+	}
+	if len(req.Filters) > 0 {
+		args = append(args, "-k", pytestExpression(req.Filters))
+	}
+	if req.Timeout != "" {
+		// pytest-timeout takes seconds.
+		if d, err := time.ParseDuration(req.Timeout); err == nil {
+			args = append(args, fmt.Sprintf("--timeout=%d", int(d.Seconds())))
+		} else {
+			args = append(args, "--timeout="+req.Timeout)
+		}
+	}
+	args = append(args, req.ExtraArgs...)
+	// Collection paths come last.
+	if req.Target != "" {
+		args = append(args, req.Target)
+	}
+	return args
+}
+
+// pytestExpression ORs the filters into a -k expression.
+func pytestExpression(filters []string) string {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	parts := make([]string, len(filters))
+	for i, filter := range filters {
+		parts[i] = "(" + filter + ")"
+	}
+	return strings.Join(parts, " or ")
+}
+
+// declaresDependency reports whether the project already depends on the
+// package (pytest-cov, a type checker), so uv does not resolve it for
+// every run: a quoted requirement in pyproject.toml, or a package of
+// uv.lock.
+func declaresDependency(sourceLocation string, name string) bool {
+	requirement := regexp.MustCompile(`"` + regexp.QuoteMeta(name) + `[\s"<>=!~;\[]`)
+	for _, f := range []string{"pyproject.toml", "uv.lock"} {
+		content, err := os.ReadFile(path.Join(sourceLocation, f))
+		if err == nil && requirement.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+func testErrorResponse(err error) *runtimev0.TestResponse {
+	return &runtimev0.TestResponse{
+		Result: &runtimev0.TestRunResult{State: runtimev0.TestRunResult_ERRORED, Message: err.Error()},
+		Status: &runtimev0.TestStatus{State: runtimev0.TestStatus_ERROR, Message: err.Error()},
+	}
+}
+
+// tracebackFrame is a frame of pytest's short traceback:
+// "src/plugins/orders/router.py:42: in create_order".
+var tracebackFrame = regexp.MustCompile(`(?m)^([^\s:]+\.py):(\d+): (?:in (\S+))?`)
+
+// locateTestCases fixes the declaration lines (pytest reports them
+// 0-based) and points each failure at the deepest frame of its traceback:
+// where the assertion fired or the exception was raised.
+func locateTestCases(resp *runtimev0.TestResponse) {
+	var visit func(suites []*runtimev0.TestSuite)
+	visit = func(suites []*runtimev0.TestSuite) {
+		for _, suite := range suites {
+			for _, c := range suite.GetCases() {
+				if c.GetLocation() != nil {
+					c.Location.Line++
+					c.Location.Function = c.GetName()
+				}
+				if c.GetFailure() != nil {
+					c.Failure.SourceLocation = tracebackLocation(c.GetFailure().GetDetail())
+				}
+			}
+			visit(suite.GetSuites())
+		}
+	}
+	visit(resp.GetSuites())
+}
+
+// tracebackLocation is the last frame of a traceback, nil without one.
+func tracebackLocation(traceback string) *runtimev0.TestLocation {
+	frames := tracebackFrame.FindAllStringSubmatch(traceback, -1)
+	if len(frames) == 0 {
+		return nil
+	}
+	frame := frames[len(frames)-1]
+	line, _ := strconv.Atoi(frame[2])
+	return &runtimev0.TestLocation{File: frame[1], Line: int32(line), Function: frame[3]}
+}
+
+// coberturaReport is the part of coverage.py's XML report we read.
+type coberturaReport struct {
+	Sources []string         `xml:"sources>source"`
+	Classes []coberturaClass `xml:"packages>package>classes>class"`
+}
+
+type coberturaClass struct {
+	Filename string          `xml:"filename,attr"`
+	Lines    []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Hits int `xml:"hits,attr"`
+	// ConditionCoverage is set on branch lines: "50% (1/2)".
+	ConditionCoverage string `xml:"condition-coverage,attr"`
+}
+
+var conditionCoverage = regexp.MustCompile(`\((\d+)/(\d+)\)`)
+
+// coverageRatio is covered out of total.
+type coverageRatio struct {
+	Covered int
+	Total   int
+}
+
+func (r coverageRatio) pct() float32 {
+	if r.Total == 0 {
+		return 100
+	}
+	return float32(r.Covered) * 100 / float32(r.Total)
+}
+
+func (r coverageRatio) String() string {
+	return fmt.Sprintf("%.1f%% (%d/%d)", r.pct(), r.Covered, r.Total)
+}
+
+func (r *coverageRatio) add(other coverageRatio) {
+	r.Covered += other.Covered
+	r.Total += other.Total
+}
+
+// fileCoverage is the coverage of one source file, relative to the source
+// location.
+type fileCoverage struct {
+	File     string
+	Lines    coverageRatio
+	Branches coverageRatio
+}
+
+// coverageSummary is a parsed coverage report.
+type coverageSummary struct {
+	Report   string
+	Lines    coverageRatio
+	Branches coverageRatio
+	Files    []fileCoverage
+}
+
+// parseCoverageReport reads coverage.py's Cobertura report. File names
+// are made relative to sourceLocation.
+func parseCoverageReport(report string, sourceLocation string) (*coverageSummary, error) {
+	content, err := os.ReadFile(report)
+	if err != nil {
+		return nil, err
+	}
+	var parsed coberturaReport
+	if err := xml.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid coverage report %s: %w", report, err)
+	}
+	prefix := ""
+	if len(parsed.Sources) > 0 {
+		prefix = coverageSourcePrefix(parsed.Sources[0], sourceLocation)
+	}
+
+	summary := &coverageSummary{Report: report}
+	for _, class := range parsed.Classes {
+		file := fileCoverage{File: path.Join(prefix, filepath.ToSlash(class.Filename))}
+		for _, line := range class.Lines {
+			file.Lines.Total++
+			if line.Hits > 0 {
+				file.Lines.Covered++
+			}
+			if m := conditionCoverage.FindStringSubmatch(line.ConditionCoverage); m != nil {
+				covered, _ := strconv.Atoi(m[1])
+				total, _ := strconv.Atoi(m[2])
+				file.Branches.add(coverageRatio{Covered: covered, Total: total})
+			}
+		}
+		summary.Lines.add(file.Lines)
+		summary.Branches.add(file.Branches)
+		summary.Files = append(summary.Files, file)
+	}
+	// Least covered first: the files worth looking at.
+	sort.SliceStable(summary.Files, func(i, j int) bool {
+		pi, pj := summary.Files[i].Lines.pct(), summary.Files[j].Lines.pct()
+		if pi != pj {
+			return pi < pj
+		}
+		return summary.Files[i].File < summary.Files[j].File
+	})
+	return summary, nil
+}
+
+// coverageSourcePrefix is the measured directory relative to the source
+// location. In Docker the report carries the container path: keep its
+// last element ("src").
+func coverageSourcePrefix(source string, sourceLocation string) string {
+	if rel, err := filepath.Rel(sourceLocation, source); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path.Base(filepath.ToSlash(source))
+}
+
+// TotalPct is coverage.py's total: lines and branches together.
+func (c *coverageSummary) TotalPct() float32 {
+	total := c.Lines
+	total.add(c.Branches)
+	return total.pct()
+}
+
+func (c *coverageSummary) String() string {
+	return fmt.Sprintf("lines %s, branches %s", c.Lines, c.Branches)
+}
+
+// Proto is the response coverage. Per-file figures are line coverage;
+// branches per file are in the raw Cobertura report.
+func (c *coverageSummary) Proto() *runtimev0.TestCoverage {
+	coverage := &runtimev0.TestCoverage{
+		TotalPct:          c.TotalPct(),
+		RawArtifactPath:   c.Report,
+		RawArtifactFormat: "cobertura",
+	}
+	for _, file := range c.Files {
+		coverage.Files = append(coverage.Files, &runtimev0.TestFileCoverage{
+			File:         file.File,
+			Pct:          file.Lines.pct(),
+			LinesCovered: int32(file.Lines.Covered),
+			LinesTotal:   int32(file.Lines.Total),
+		})
+	}
+	return coverage
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	runtimev0 "github.com/codefly-dev/core/generated/go/codefly/services/runtime/v0"
+	"github.com/codefly-dev/core/resources"
+	runners "github.com/codefly-dev/core/runners/base"
+	pythonhelpers "github.com/codefly-dev/core/runners/python"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTestNeedsInit(t *testing.T) {
+	ctx := context.Background()
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(ctx).In("pytest-test")
+	for _, state := range []LifecycleState{StateLoaded, StateInitialized} {
+		rt.lifecycle.set(state)
+		if _, err := rt.Test(ctx, &runtimev0.TestRequest{}); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: expected FailedPrecondition, got %v", state, err)
+		}
+	}
+}
+
+// TestTestAndDestroy: the run keeps the environment it was set up with,
+// and does not hold Destroy back.
+func TestTestAndDestroy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "uv"), []byte("#!/bin/sh\nsleep 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(ctx).In("pytest-test")
+	rt.Base.Service = &resources.Service{}
+	rt.Service.SourceLocation = t.TempDir()
+	env, err := runners.NewNativeEnvironment(ctx, rt.Service.SourceLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.runnerEnvironment = env
+	rt.lifecycle.set(StateInitialized)
+
+	done := make(chan *runtimev0.TestResponse)
+	go func() {
+		resp, err := rt.Test(ctx, &runtimev0.TestRequest{})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if err := rt.lifecycle.begin(opDestroy); err != nil {
+		t.Fatal(err)
+	}
+	rt.runnerEnvironment = nil
+	rt.lifecycle.end(opDestroy, true)
+	select {
+	case <-done:
+		t.Fatal("Destroy waited for the test run")
+	default:
+	}
+	if resp := <-done; resp == nil {
+		t.Error("no response")
+	}
+}
+
+func TestPytestArgs(t *testing.T) {
+	req := &runtimev0.TestRequest{Target: "tests/plugins", Filters: []string{"orders", "users"}, Timeout: "30s", ExtraArgs: []string{"-x"}}
+	args := pytestArgs(req, "/svc/code/.cache/tests", true, true)
+	for _, want := range []string{"--with", "pytest-cov", "--junitxml=/svc/code/.cache/tests/junit.xml", "--cov-branch",
+		"--cov-report=xml:/svc/code/.cache/tests/coverage.xml", "(orders) or (users)", "--timeout=30", "-x"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("%v misses %q", args, want)
+		}
+	}
+	if args[len(args)-1] != "tests/plugins" {
+		t.Errorf("target must come last: %v", args)
+	}
+	if args := pytestArgs(&runtimev0.TestRequest{}, "/r", true, false); slices.Contains(args, "--with") {
+		t.Errorf("pytest-cov added to a project declaring it: %v", args)
+	}
+	if args := pytestArgs(&runtimev0.TestRequest{}, "/r", false, false); slices.Contains(args, "--cov-branch") {
+		t.Errorf("coverage without asking for it: %v", args)
+	}
+}
+
+const junitSample = `<?xml version="1.0" encoding="utf-8"?>
+<testsuites><testsuite name="pytest" tests="2" failures="1">
+<testcase classname="tests.test_orders" name="test_list" file="tests/test_orders.py" line="4" time="0.010"/>
+<testcase classname="tests.test_orders" name="test_create" file="tests/test_orders.py" line="9" time="0.250">
+<failure message="assert 404 == 201">tests/test_orders.py:11: in test_create
+    assert create(client).status_code == 201
+src/plugins/orders/router.py:42: in create_order
+    raise HTTPException(404)
+E   assert 404 == 201</failure>
+</testcase>
+</testsuite></testsuites>`
+
+func TestLocateTestCases(t *testing.T) {
+	resp := pythonhelpers.ParsePytestJUnit(junitSample, 0).ToProtoResponse("pytest", "", 0)
+	locateTestCases(resp)
+	cases := map[string]*runtimev0.TestCase{}
+	for _, c := range resp.GetSuites()[0].GetCases() {
+		cases[c.GetName()] = c
+	}
+
+	failed := cases["test_create"]
+	if failed.GetDuration().AsDuration().Milliseconds() != 250 || failed.GetFailure().GetMessage() != "assert 404 == 201" {
+		t.Errorf("got %v", failed)
+	}
+	if loc := failed.GetLocation(); loc.GetLine() != 10 || loc.GetFunction() != "test_create" {
+		t.Errorf("declaration must be 1-based, got %v", loc)
+	}
+	loc := failed.GetFailure().GetSourceLocation()
+	if loc.GetFile() != "src/plugins/orders/router.py" || loc.GetLine() != 42 || loc.GetFunction() != "create_order" {
+		t.Errorf("failure must point at the deepest frame, got %v", loc)
+	}
+	if cases["test_list"].GetFailure() != nil {
+		t.Error("a passed test has no failure")
+	}
+}
+
+const coberturaSample = `<?xml version="1.0" ?>
+<coverage version="7.6.1" line-rate="0.75" branch-rate="0.5">
+	<sources><source>/svc/code/src</source></sources>
+	<packages><package name="."><classes>
+		<class name="main.py" filename="main.py"><lines>
+			<line number="1" hits="1"/>
+			<line number="2" hits="1" branch="true" condition-coverage="50% (1/2)" missing-branches="4"/>
+			<line number="3" hits="1"/>
+			<line number="4" hits="1"/>
+		</lines></class>
+		<class name="router.py" filename="plugins/orders/router.py"><lines>
+			<line number="1" hits="1"/>
+			<line number="2" hits="0" branch="true" condition-coverage="0% (0/2)" missing-branches="3,4"/>
+			<line number="3" hits="0"/>
+			<line number="4" hits="1"/>
+		</lines></class>
+	</classes></package></packages>
+</coverage>`
+
+func TestParseCoverageReport(t *testing.T) {
+	report := filepath.Join(t.TempDir(), coverageReport)
+	if err := os.WriteFile(report, []byte(coberturaSample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	coverage, err := parseCoverageReport(report, "/svc/code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coverage.Lines != (coverageRatio{Covered: 6, Total: 8}) || coverage.Branches != (coverageRatio{Covered: 1, Total: 4}) {
+		t.Errorf("got lines %v, branches %v", coverage.Lines, coverage.Branches)
+	}
+	if got := coverage.String(); got != "lines 75.0% (6/8), branches 25.0% (1/4)" {
+		t.Errorf("got %q", got)
+	}
+
+	proto := coverage.Proto()
+	if proto.GetTotalPct() != float32(7)*100/12 || proto.GetRawArtifactPath() != report {
+		t.Errorf("got %v", proto)
+	}
+	var files []string
+	for _, f := range proto.GetFiles() {
+		files = append(files, f.GetFile())
+	}
+	if strings.Join(files, ",") != "src/plugins/orders/router.py,src/main.py" {
+		t.Errorf("least covered first, relative to the source location: %v", files)
+	}
+
+	// In Docker the report carries the container path.
+	if coverage, _ := parseCoverageReport(report, "/elsewhere"); coverage.Files[1].File != "src/main.py" {
+		t.Errorf("got %s", coverage.Files[1].File)
+	}
+}
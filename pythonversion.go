@@ -0,0 +1,69 @@
+package main
+
+// pythonversion.go — one python-version for every backend.
+//
+// The generic python-version setting picks the interpreter in all three
+// modes: the companion image tag in Docker (and as the Dockerfile base),
+// the nixpkgs attribute in the provisioned flake, and UV_PYTHON for uv in
+// native and nix modes. Only the versions listed here are supported;
+// anything else fails Load. A version is listed once core publishes its
+// companion image (core/companions/python): Docker runs and Build pull it.
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/codefly-dev/core/resources"
+)
+
+// PythonRelease is a supported Python minor version and what each backend
+// runs for it.
+type PythonRelease struct {
+	// Version is the minor version ("3.12").
+	Version string
+	// Image is the codefly-built companion (python-alpine + codefly CLI +
+	// uv, built from core/companions/python/), pinned per minor version.
+	Image *resources.DockerImage
+	// NixAttribute is the nixpkgs interpreter package.
+	NixAttribute string
+	// UVRequest is what uv is asked for (UV_PYTHON): the version as
+	// written in python-version, so a patch pin is honoured.
+	UVRequest string
+}
+
+// defaultPythonVersion applies when python-version is unset.
+const defaultPythonVersion = "3.13"
+
+var pythonReleases = map[string]PythonRelease{
+	// python:3.13.1-alpine3.21 + codefly CLI + uv 0.5.29.
+	"3.13": {Version: "3.13", Image: &resources.DockerImage{Name: "codeflydev/python", Tag: "0.0.1"}, NixAttribute: "python313"},
+}
+
+var pythonVersionPattern = regexp.MustCompile(`^(3\.\d+)(\.\d+)?$`)
+
+// ResolvePython maps python-version ("3.12", "3.12.4", or empty for the
+// default) onto its release.
+func (s *Settings) ResolvePython() (*PythonRelease, error) {
+	requested := strings.TrimSpace(s.PythonVersion)
+	if requested == "" {
+		requested = defaultPythonVersion
+	}
+	m := pythonVersionPattern.FindStringSubmatch(requested)
+	if m == nil {
+		return nil, fmt.Errorf("python-version %q is not a Python 3 version like 3.12 or 3.12.4", s.PythonVersion)
+	}
+	release, ok := pythonReleases[m[1]]
+	if !ok {
+		return nil, fmt.Errorf("python-version %s is not supported (supported: %s)", requested, strings.Join(supportedPythonVersions(), ", "))
+	}
+	release.UVRequest = requested
+	return &release, nil
+}
+
+// supportedPythonVersions is sorted: minor versions 3.10+ sort lexically.
+func supportedPythonVersions() []string {
+	return slices.Sorted(maps.Keys(pythonReleases))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pythonservice "github.com/codefly-dev/service-python/pkg/service"
+)
+
+func TestResolvePython(t *testing.T) {
+	cases := []struct {
+		requested string
+		image     string
+		nix       string
+		uv        string
+	}{
+		{"", "codeflydev/python:0.0.1", "python313", "3.13"},
+		{"3.13.1", "codeflydev/python:0.0.1", "python313", "3.13.1"},
+	}
+	for _, c := range cases {
+		settings := &Settings{Settings: pythonservice.Settings{PythonVersion: c.requested}}
+		release, err := settings.ResolvePython()
+		if err != nil {
+			t.Errorf("%q: %v", c.requested, err)
+			continue
+		}
+		if release.Image.FullName() != c.image || release.NixAttribute != c.nix || release.UVRequest != c.uv {
+			t.Errorf("%q: got %s %s %s", c.requested, release.Image.FullName(), release.NixAttribute, release.UVRequest)
+		}
+	}
+
+	// 3.11 and 3.12 have no companion image.
+	for _, requested := range []string{"3.12", "3.11.9", "3.9", "2.7", "latest", "3"} {
+		settings := &Settings{Settings: pythonservice.Settings{PythonVersion: requested}}
+		if _, err := settings.ResolvePython(); err == nil {
+			t.Errorf("%q: expected an error", requested)
+		}
+	}
+	_, err := (&Settings{Settings: pythonservice.Settings{PythonVersion: "3.9"}}).ResolvePython()
+	if err == nil || !strings.Contains(err.Error(), "supported: 3.13") {
+		t.Errorf("unsupported version should list the supported ones: %v", err)
+	}
+}
+
+// TestEnsureNixFlake: the agent's flake follows python-version, a user's
+// flake is never touched.
+func TestEnsureNixFlake(t *testing.T) {
+	dir := t.TempDir()
+	flake := filepath.Join(dir, "flake.nix")
+	read := func() string {
+		content, err := os.ReadFile(flake)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	if _, err := ensureNixFlake(dir, "python312", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(read(), "pkgs.python312\n") {
+		t.Fatalf("flake does not pin python312:\n%s", read())
+	}
+	if _, err := ensureNixFlake(dir, "python311", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(read(), "pkgs.python311\n") || strings.Contains(read(), "python312") {
+		t.Fatalf("managed flake not rewritten:\n%s", read())
+	}
+
+	user := strings.TrimPrefix(read(), nixFlakeMarker)
+	if err := os.WriteFile(flake, []byte(user), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if managed, err := ensureNixFlake(dir, "python313", nil); err != nil || managed {
+		t.Fatalf("user flake reported as managed (%v)", err)
+	}
+	if read() != user {
+		t.Error("user flake was overwritten")
+	}
+}
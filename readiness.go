@@ -0,0 +1,122 @@
+package main
+
+// readiness.go — the Start readiness gate.
+//
+// Forking uvicorn is not the same as serving: importing src.main can take
+// seconds (or fail). Start probes the REST endpoint until the app answers,
+// so dependents never race the import. The process output is teed into an
+// outputTail so a failed gate can report what uvicorn printed.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReadinessPath is the health route scaffolded in src/admin/router.py.
+	defaultReadinessPath = "/health"
+	// defaultReadinessTimeout bounds how long Start waits for the first answer.
+	defaultReadinessTimeout = 30 * time.Second
+
+	readinessInitialBackoff = 100 * time.Millisecond
+	readinessMaxBackoff     = 2 * time.Second
+
+	// outputTailLines is how much uvicorn output is kept for error reports.
+	outputTailLines = 50
+)
+
+// ReadinessURL joins the configured readiness path onto the REST address.
+func (s *Settings) ReadinessURL(address string) string {
+	p := s.ReadinessPath
+	if p == "" {
+		p = defaultReadinessPath
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return strings.TrimSuffix(address, "/") + p
+}
+
+// ReadinessDeadline is the readiness-timeout setting, or the default.
+func (s *Settings) ReadinessDeadline() time.Duration {
+	if s.ReadinessTimeout > 0 {
+		return time.Duration(s.ReadinessTimeout) * time.Second
+	}
+	return defaultReadinessTimeout
+}
+
+// waitReady polls url with exponential backoff until the app answers with a
+// non-5xx status. Any such answer proves uvicorn imported the app and is
+// serving HTTP; a 5xx (e.g. a health route reporting 503) keeps waiting.
+// It fails early when exited fires — the process died before serving.
+func waitReady(ctx context.Context, url string, timeout time.Duration, exited <-chan error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := http.Client{Timeout: time.Second}
+	backoff := readinessInitialBackoff
+	var last error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+			last = fmt.Errorf("%s answered %d", url, resp.StatusCode)
+		} else {
+			last = err
+		}
+
+		select {
+		case err := <-exited:
+			if err == nil {
+				return fmt.Errorf("server exited before serving %s", url)
+			}
+			return fmt.Errorf("server exited before serving %s: %w", url, err)
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s: %w", timeout, last)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, readinessMaxBackoff)
+	}
+}
+
+// outputTail is an io.Writer keeping the last n lines written to it.
+// Process output is forwarded line by line, so each Write is one line.
+type outputTail struct {
+	mu    sync.Mutex
+	lines []string
+	n     int
+}
+
+func newOutputTail(n int) *outputTail {
+	return &outputTail{n: n}
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+	if over := len(t.lines) - t.n; over > 0 {
+		t.lines = t.lines[over:]
+	}
+	return len(p), nil
+}
+
+// String returns the retained lines, oldest first.
+func (t *outputTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.lines, "\n")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWaitReadyRetriesUntilServing simulates an app whose health route
+// reports 503 while booting, then 200.
+func TestWaitReadyRetriesUntilServing(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	if err := waitReady(context.Background(), srv.URL+"/health", 5*time.Second, nil); err != nil {
+		t.Fatalf("waitReady: %v", err)
+	}
+	if calls.Load() < 3 {
+		t.Errorf("expected at least 3 probes, got %d", calls.Load())
+	}
+}
+
+func TestWaitReadyTimesOut(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	err := waitReady(context.Background(), srv.URL, 300*time.Millisecond, nil)
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
+
+// TestWaitReadyFailsFastOnExit: an import error kills uvicorn; the gate must
+// not sit out the whole timeout.
+func TestWaitReadyFailsFastOnExit(t *testing.T) {
+	exited := make(chan error, 1)
+	exited <- errors.New("exit status 1")
+
+	started := time.Now()
+	err := waitReady(context.Background(), "http://127.0.0.1:1/health", 10*time.Second, exited)
+	if err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("gate did not fail fast: %s", time.Since(started))
+	}
+}
+
+func TestReadinessURL(t *testing.T) {
+	s := &Settings{}
+	if got := s.ReadinessURL("http://localhost:8080"); got != "http://localhost:8080/health" {
+		t.Errorf("default path: got %s", got)
+	}
+	s.ReadinessPath = "ready"
+	if got := s.ReadinessURL("http://localhost:8080/"); got != "http://localhost:8080/ready" {
+		t.Errorf("custom path: got %s", got)
+	}
+}
+
+func TestOutputTailKeepsLastLines(t *testing.T) {
+	tail := newOutputTail(2)
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		_, _ = tail.Write([]byte(line))
+	}
+	if got := tail.String(); got != "two\nthree" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/codefly-dev/core/agents/helpers/code"
 	"github.com/codefly-dev/core/agents/services"
@@ -25,15 +29,19 @@ import (
 //
 // Embedding:
 //
-//	*pythonruntime.Runtime — inherits Test (uv run pytest), Lint (uv run ruff),
-//	                         Build (no-op), Information, and the services.Base
+//	*pythonruntime.Runtime — inherits Lint (uv run ruff), Build (no-op),
+//	                         formula-driven Test runs, and the services.Base
 //	                         chain via *pythonservice.Service promotion.
 //	FastAPI               — fastapi-specific state (RestEndpoint, HotReload
 //	                         setting) accessed explicitly as s.FastAPI.X.
 //
-// Overridden methods: Load, Init, Start, Stop, Destroy — fastapi adds Docker
-// runner env, port binding, uvicorn, OpenAPI regeneration, watchers.
-// Inherited methods: Test, Lint, Build — the generic uv-based implementations
+// Overridden methods: Load, Init, Start, Stop, Destroy, Information — fastapi
+// adds Docker runner env, port binding, uvicorn, OpenAPI regeneration,
+// watchers, crash supervision, debugger, tracing and metrics notes.
+// They go through the lifecycle state machine (lifecycle.go), which rejects
+// out-of-order calls and serializes them with the watcher. Test is
+// overridden too: per-test results and coverage (pytest.go).
+// Inherited methods: Lint, Build — the generic uv-based implementations
 // are already what fastapi needs.
 type Runtime struct {
 	*pythonruntime.Runtime
@@ -49,11 +57,39 @@ type Runtime struct {
 	// their own cmdExec method to shadow the generic one.
 	FastAPI *Service
 
+	// lifecycle serializes Load/Init/Start/Stop/Destroy and the watcher's
+	// actions, and rejects out-of-order calls.
+	lifecycle lifecycle
+
 	// internal
+	// runnerEnvironment is set by Init and cleared by Destroy, under the
+	// lifecycle operation lock.
 	runnerEnvironment runners.RunnerEnvironment
 	runner            runners.Proc
 
-	port uint16
+	// mu guards runner and supervision, shared with the crash supervisor,
+	// and traces and metrics, read by Information outside the lifecycle
+	// lock. The lifecycle operations are their only writers.
+	mu          sync.Mutex
+	supervision supervision
+	// supervisors counts the running crash supervisors; Stop waits for
+	// them.
+	supervisors sync.WaitGroup
+
+	port    uint16
+	address string
+
+	// openapiRefresh regenerates the spec after source changes (hot-reload).
+	openapiRefresh *debouncer
+	// testWatch reruns the affected tests after changes (test-watch).
+	testWatch *testWatcher
+
+	// traces is the local OTLP receiver, when tracing has no endpoint.
+	traces *otlpReceiver
+
+	// metrics scrapes the app; metricsProxy serves the metrics endpoint.
+	metrics      *metricsScraper
+	metricsProxy *http.Server
 
 	cacheLocation string
 }
@@ -65,7 +101,13 @@ func NewRuntime(svc *Service) *Runtime {
 	}
 }
 
-func (s *Runtime) Load(ctx context.Context, req *runtimev0.LoadRequest) (*runtimev0.LoadResponse, error) {
+func (s *Runtime) Load(ctx context.Context, req *runtimev0.LoadRequest) (resp *runtimev0.LoadResponse, err error) {
+	if err := s.lifecycle.begin(opLoad); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.lifecycle.end(opLoad, err == nil && resp.GetStatus().GetState() == runtimev0.LoadStatus_READY)
+	}()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
@@ -76,7 +118,7 @@ func (s *Runtime) Load(ctx context.Context, req *runtimev0.LoadRequest) (*runtim
 	s.Base.Runtime.SetEnvironment(req.Environment)
 
 	// FastAPI layout: Python source lives under <service>/code. Push onto
-	// the generic Service.SourceLocation so the inherited Test / Lint see it.
+	// the generic Service.SourceLocation so Test and the inherited Lint see it.
 	s.Service.SourceLocation = s.Local("code")
 	s.Wool.Debug("code location", wool.DirField(s.Service.SourceLocation))
 
@@ -90,6 +132,18 @@ func (s *Runtime) Load(ctx context.Context, req *runtimev0.LoadRequest) (*runtim
 	if err != nil {
 		return s.Base.Runtime.LoadError(err)
 	}
+	s.FastAPI.MetricsEndpoint = findMetricsEndpoint(s.Endpoints)
+	if s.FastAPI.Settings.Metrics && s.FastAPI.MetricsEndpoint == nil {
+		s.Wool.Warn("metrics is set but no metrics endpoint is declared: only scraping, nothing served on a metrics port")
+	}
+
+	if err := s.FastAPI.Settings.Validate(); err != nil {
+		return s.Base.Runtime.LoadErrorf(err, "invalid settings in service.codefly.yaml")
+	}
+
+	if s.FastAPI.Python, err = s.FastAPI.Settings.ResolvePython(); err != nil {
+		return s.Base.Runtime.LoadErrorf(err, "invalid python-version in service.codefly.yaml")
+	}
 
 	// Inherit the persistent Python REPL commands (exec, repl-reset)
 	// from the generic python runtime. FastAPI adds no REPL-specific
@@ -104,23 +158,33 @@ func (s *Runtime) DockerEnvPath() string {
 	return path.Join(s.Location, ".cache/container/.venv")
 }
 
+// resolveRuntimeImage is the docker-image override (if any), else the
+// companion of python-version. Strict pinning — we reject :latest and
+// untagged refs so builds stay reproducible.
+func (s *Runtime) resolveRuntimeImage() (*resources.DockerImage, error) {
+	override := s.FastAPI.Settings.RuntimeImage
+	if override == "" {
+		return s.FastAPI.Python.Image, nil
+	}
+	parsed, err := resources.ParsePinnedImage(override)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "invalid docker-image override in service.codefly.yaml")
+	}
+	return parsed, nil
+}
+
 func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 	s.Wool.Debug("creating runner environment in", wool.DirField(s.Identity.WorkspacePath))
-	// Resolve the runtime image: settings override (if any) takes priority,
-	// else fall back to the codefly-built default. Strict pinning — we
-	// reject :latest and untagged refs so builds stay reproducible.
-	image := runtimeImage
-	if override := s.FastAPI.Settings.RuntimeImage; override != "" {
-		parsed, perr := resources.ParsePinnedImage(override)
-		if perr != nil {
-			return s.Wool.Wrapf(perr, "invalid docker-image override in service.codefly.yaml")
-		}
-		s.Wool.Info("using docker-image override (not recommended)", wool.Field("image", parsed.FullName()))
-		image = parsed
-	}
 
 	switch {
 	case s.Base.Runtime.IsContainerRuntime():
+		image, err := s.resolveRuntimeImage()
+		if err != nil {
+			return err
+		}
+		if s.FastAPI.Settings.RuntimeImage != "" {
+			s.Wool.Info("using docker-image override (not recommended)", wool.Field("image", image.FullName()))
+		}
 		dockerEnv, err := dockerrun.NewDockerEnvironment(ctx, image, s.Identity.WorkspacePath, s.UniqueWithWorkspace())
 		if err != nil {
 			return s.Wool.Wrapf(err, "cannot create docker runner")
@@ -132,6 +196,9 @@ func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 			return s.Wool.Wrapf(err, "cannot find network instance")
 		}
 		dockerEnv.WithPort(ctx, uint16(instance.Port))
+		if s.FastAPI.Settings.Debug {
+			dockerEnv.WithPort(ctx, s.FastAPI.Settings.DebugListenPort())
+		}
 
 		envPath := s.DockerEnvPath()
 		if _, err = shared.CheckDirectoryOrCreate(ctx, envPath); err != nil {
@@ -147,14 +214,29 @@ func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 		if err != nil {
 			return s.Wool.Wrapf(err, "cannot create cache location")
 		}
+		s.recordArtifacts(
+			artifact{Kind: artifactContainer, Image: image.FullName(), Root: s.Identity.WorkspacePath, Name: s.UniqueWithWorkspace()},
+			artifact{Kind: artifactDirectory, Path: envPath},
+			artifact{Kind: artifactDirectory, Path: s.cacheLocation})
 		s.runnerEnvironment = dockerEnv
 
 	case s.Base.Runtime.IsNixRuntime():
-		// Provision the devShell (python3 + uv) when the project doesn't ship a
-		// flake.nix, so NewNixEnvironment has something to materialize.
-		if err := ensureNixFlake(s.Service.SourceLocation); err != nil {
+		// Provision the devShell (python3 + uv + system-packages) when the
+		// project doesn't ship a flake.nix, so NewNixEnvironment has something
+		// to materialize.
+		packages := s.FastAPI.Settings.nixPackages()
+		written, err := ensureNixFlake(s.Service.SourceLocation, s.FastAPI.Python.NixAttribute, packages)
+		if err != nil {
 			return s.Wool.Wrapf(err, "cannot provision nix flake")
 		}
+		if !written && len(packages) > 0 {
+			s.Wool.Warn("system-packages are not added to the project's own flake.nix", wool.Field("packages", packages))
+		}
+		if written {
+			s.recordArtifacts(
+				artifact{Kind: artifactFile, Path: path.Join(s.Service.SourceLocation, "flake.nix")},
+				artifact{Kind: artifactFile, Path: path.Join(s.Service.SourceLocation, "flake.lock")})
+		}
 		nixEnv, err := runners.NewNixEnvironment(ctx, s.Service.SourceLocation)
 		if err != nil {
 			return s.Wool.Wrapf(err, "cannot create nix runner")
@@ -167,9 +249,13 @@ func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 		// and the result is persisted under the plugin's cacheLocation.
 		// Subsequent starts skip nix evaluation entirely (see nix_runner.go).
 		nixEnv.WithCacheDir(s.cacheLocation)
+		s.recordArtifacts(artifact{Kind: artifactDirectory, Path: s.cacheLocation})
 		s.runnerEnvironment = nixEnv
 
 	default:
+		if err := s.FastAPI.provisionNativePackages(ctx); err != nil {
+			return err
+		}
 		localEnv, err := runners.NewNativeEnvironment(ctx, s.Service.SourceLocation)
 		if err != nil {
 			return s.Wool.Wrapf(err, "cannot create local runner")
@@ -178,6 +264,9 @@ func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 		if err != nil {
 			return s.Wool.Wrapf(err, "cannot create cache location")
 		}
+		// code/.venv is the project's own environment (shared with the
+		// IDE), not an artifact of the agent.
+		s.recordArtifacts(artifact{Kind: artifactDirectory, Path: s.cacheLocation})
 		s.runnerEnvironment = localEnv
 	}
 
@@ -187,6 +276,14 @@ func (s *Runtime) CreateRunnerEnvironment(ctx context.Context) error {
 	}
 	s.runnerEnvironment.WithEnvironmentVariables(ctx, allEnvs...)
 	s.runnerEnvironment.WithEnvironmentVariables(ctx, resources.Env("PYTHONUNBUFFERED", 1))
+	if !s.Base.Runtime.IsContainerRuntime() {
+		// The image ships its interpreter; elsewhere uv picks (or downloads)
+		// the requested one.
+		s.runnerEnvironment.WithEnvironmentVariables(ctx, resources.Env("UV_PYTHON", s.FastAPI.Python.UVRequest))
+	}
+	if err := s.setupTracing(ctx); err != nil {
+		return err
+	}
 	// Share with Code / Tooling so AST analysis, grep, uv sync follow
 	// whatever mode the plugin is in.
 	s.FastAPI.Service.ActiveEnv = s.runnerEnvironment
@@ -198,7 +295,13 @@ func (s *Runtime) SetRuntimeContext(_ context.Context, runtimeContext *basev0.Ru
 	return nil
 }
 
-func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtimev0.InitResponse, error) {
+func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (resp *runtimev0.InitResponse, err error) {
+	if err := s.lifecycle.begin(opInit); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.lifecycle.end(opInit, err == nil && resp.GetStatus().GetState() == runtimev0.InitStatus_READY)
+	}()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
@@ -236,6 +339,9 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 	if err := s.EnvironmentVariables.AddConfigurations(ctx, confs...); err != nil {
 		return s.Base.Runtime.InitError(err)
 	}
+	if err := s.checkPluginEnvironment(); err != nil {
+		return s.Base.Runtime.InitError(err)
+	}
 
 	net, err := resources.FindNetworkInstanceInNetworkMappings(ctx, s.NetworkMappings, s.FastAPI.RestEndpoint, resources.NewNativeNetworkAccess())
 	if err != nil {
@@ -244,6 +350,7 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 
 	s.Infof("will run on %s", net.Address)
 	s.port = uint16(net.Port)
+	s.address = net.Address
 
 	hasPyProject, err := shared.FileExists(ctx, path.Join(s.Service.SourceLocation, "pyproject.toml"))
 	if err != nil {
@@ -256,35 +363,17 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 	// uv sync: one command, reads pyproject.toml + uv.lock (creates lock if
 	// missing). Cached on pyproject.toml + uv.lock so we only re-run when
 	// dependencies actually change.
-	s.Wool.Debug("computing uv dependency cache")
-	deps := builders.NewDependencies("uv",
-		builders.NewDependency(path.Join(s.Service.SourceLocation, "pyproject.toml")),
-		builders.NewDependency(path.Join(s.Service.SourceLocation, "uv.lock")),
-	).WithCache(s.cacheLocation)
-
-	depUpdate, err := deps.Updated(ctx)
-	if err != nil {
+	if _, err := s.syncDependencies(ctx); err != nil {
 		return s.Base.Runtime.InitError(err)
 	}
-
-	if depUpdate {
-		s.Infof("syncing uv environment")
-		proc, err := s.runnerEnvironment.NewProcess("uv", "sync")
-		if err != nil {
-			return s.Base.Runtime.InitErrorf(err, "cannot create uv sync process")
-		}
-		proc.WithDir(s.Service.SourceLocation)
-		if err := proc.Run(ctx); err != nil {
-			return s.Base.Runtime.InitErrorf(err, "cannot run uv sync")
-		}
-		if err := deps.UpdateCache(ctx); err != nil {
-			return s.Base.Runtime.InitErrorf(err, "cannot update cache")
-		}
+	if err := s.applyMigrations(ctx, confs); err != nil {
+		return s.Base.Runtime.InitErrorf(err, "cannot apply plugin migrations")
 	}
 	s.Wool.Debug("successful init of runner")
 
+	// Routes live in any module under src (routers, plugins), not only main.py.
 	openAPI := builders.NewDependencies("api",
-		builders.NewDependency(path.Join(s.Service.SourceLocation, "src/main.py"))).WithCache(s.cacheLocation)
+		builders.NewDependency(path.Join(s.Service.SourceLocation, "src")).WithPathSelect(shared.NewSelect("*.py"))).WithCache(s.cacheLocation)
 	openApiUpdate, err := openAPI.Updated(ctx)
 	if err != nil {
 		return s.Base.Runtime.InitError(err)
@@ -301,17 +390,46 @@ func (s *Runtime) Init(ctx context.Context, req *runtimev0.InitRequest) (*runtim
 		s.Wool.Debug("generate Open API done")
 	}
 
+	if s.FastAPI.Settings.APICheckOnInit {
+		report, err := s.FastAPI.CheckAPICompatibility(ctx)
+		if err != nil {
+			s.Wool.Warn("cannot check API compatibility", wool.ErrField(err))
+		} else if report != nil && report.Blocking() {
+			s.Infof("%d breaking API change(s) since %s without a major version bump; see %s", len(report.Breaking), report.Baseline, apiReportPath)
+		}
+	}
+
 	return s.Base.Runtime.InitResponse()
 }
 
-func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (*runtimev0.StartResponse, error) {
+func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (resp *runtimev0.StartResponse, err error) {
+	if err := s.lifecycle.begin(opStart); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.lifecycle.end(opStart, err == nil && resp.GetStatus().GetState() == runtimev0.StartStatus_STARTED)
+	}()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Base.Runtime.LogStartRequest(req)
 
-	if s.runner != nil && s.FastAPI.Settings.HotReload {
-		return s.Base.Runtime.StartResponse()
+	mode, err := s.FastAPI.Settings.Mode()
+	if err != nil {
+		return s.Base.Runtime.StartError(err)
+	}
+
+	if runner := s.currentRunner(); runner != nil {
+		// uvicorn --reload restarts itself on source changes; every other
+		// mode is restarted by replacing the process.
+		if mode == ServerModeReload {
+			return s.Base.Runtime.StartResponse()
+		}
+		report, err := s.shutdown(ctx, s.setRunner(nil))
+		if err != nil {
+			return s.Base.Runtime.StartErrorf(err, "cannot stop previous server")
+		}
+		s.Wool.Debug("previous server stopped", wool.Field("shutdown", report.Message))
 	}
 
 	if s.runnerEnvironment == nil {
@@ -320,59 +438,208 @@ func (s *Runtime) Start(ctx context.Context, req *runtimev0.StartRequest) (*runt
 		return s.Base.Runtime.StartError(s.Wool.NewError("runner environment not initialized (Init must run before Start)"))
 	}
 
-	proc, err := s.runnerEnvironment.NewProcess(
-		"uv", "run", "uvicorn", "src.main:app",
-		"--reload", "--host", "0.0.0.0", "--port", fmt.Sprintf("%d", s.port))
+	s.EnvironmentVariables.SetRunning()
+
+	if s.FastAPI.Settings.HotReload || s.FastAPI.Settings.TestWatch {
+		// A non-reload restart comes through here again: drop the previous
+		// watcher before installing a new one.
+		s.Base.StopWatcher()
+		watch := runtimeWatch
+		if s.FastAPI.Settings.HotReload && s.openapiRefresh == nil {
+			s.openapiRefresh = newDebouncer(openapiDebounce, func() {
+				// A refresh that fires after Stop has nothing to publish.
+				s.lifecycle.whileRunning(func() {
+					s.refreshOpenAPI(s.Wool.Inject(context.Background()))
+				})
+			})
+		}
+		if s.FastAPI.Settings.TestWatch {
+			watch = testWatchDependencies
+			if s.testWatch == nil {
+				s.testWatch = newTestWatcher(ctx, s)
+			}
+		}
+		conf := services.NewWatchConfiguration(watch)
+		if err := s.SetupWatcher(ctx, conf, s.EventHandler); err != nil {
+			s.Wool.Warn("error in watcher", wool.ErrField(err))
+		}
+	}
+
+	s.Infof("starting fastapi app via uv (%s)", mode)
+	if address := s.debugAddress(); address != "" {
+		s.Infof("debugpy listening on %s", address)
+	}
+	launch, err := s.newLaunch(mode)
 	if err != nil {
 		return s.Base.Runtime.StartError(err)
 	}
+	proc, tail, err := launch.launch(ctx)
+	if err != nil {
+		return s.Base.Runtime.StartErrorf(err, "fastapi app did not become ready")
+	}
+	s.supervise(launch, proc, tail)
+	s.startMetrics(ctx)
+
+	s.Wool.Debug("start done", wool.Field("ready", s.FastAPI.Settings.ReadinessURL(s.address)))
+	return s.Base.Runtime.StartResponse()
+}
 
-	proc.WithOutput(s.Logger)
-	proc.WithDir(s.Service.SourceLocation)
+// serverLaunch is what launching the server reads, taken from the Runtime
+// by the lifecycle operation that starts it. The crash supervisor relaunches
+// from the same snapshot: a Load while running rewrites the Runtime's
+// settings and loggers, and Destroy drops its environment.
+type serverLaunch struct {
+	mode   ServerMode
+	wool   *wool.Wool
+	logger *wool.Wool
+	env    runners.RunnerEnvironment
+	argv   []string
+	dir    string
+	envs   []*resources.EnvironmentVariable
+
+	readinessURL      string
+	readinessDeadline time.Duration
+	// debugAddress is set when the app waits for a debugger to attach.
+	debugAddress string
+	restartLimit int
+}
 
-	s.EnvironmentVariables.SetRunning()
+// newLaunch snapshots the launch of the server in mode.
+func (s *Runtime) newLaunch(mode ServerMode) (*serverLaunch, error) {
+	if s.runnerEnvironment == nil {
+		// Init must run before Start; without it NewProcess nil-derefs and
+		// panics the agent. Fail loudly with a clear error instead.
+		return nil, s.Wool.NewError("runner environment not initialized (Init must run before Start)")
+	}
+	envs, err := s.EnvironmentVariables.All()
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "getting environment variables")
+	}
+	settings := s.FastAPI.Settings
+	l := &serverLaunch{
+		mode:              mode,
+		wool:              s.Wool,
+		logger:            s.Logger,
+		env:               s.runnerEnvironment,
+		argv:              withPidFile(s.pidFile(), s.serverArgv(mode)),
+		dir:               s.Service.SourceLocation,
+		envs:              append(envs, s.EnvironmentVariables.Secrets()...),
+		readinessURL:      settings.ReadinessURL(s.address),
+		readinessDeadline: settings.ReadinessDeadline(),
+		restartLimit:      settings.RestartLimit(),
+	}
+	if settings.Debug && settings.DebugWait {
+		l.debugAddress = s.debugAddress()
+	}
+	return l, nil
+}
 
-	startEnvs, err := s.EnvironmentVariables.All()
+// launch starts the server process and blocks until it passes the readiness
+// gate: only then does it serve HTTP. The returned outputTail keeps the last
+// lines the process printed, and is also returned on failure.
+func (l *serverLaunch) launch(ctx context.Context) (runners.Proc, *outputTail, error) {
+	proc, err := l.env.NewProcess("sh", l.argv...)
 	if err != nil {
-		return s.Base.Runtime.StartErrorf(err, "getting environment variables")
+		return nil, nil, err
 	}
-	proc.WithEnvironmentVariables(ctx, startEnvs...)
-	proc.WithEnvironmentVariables(ctx, s.EnvironmentVariables.Secrets()...)
 
-	s.runner = proc
+	tail := newOutputTail(outputTailLines)
+	proc.WithOutput(io.MultiWriter(newLogAdapter(l.logger), tail))
+	proc.WithDir(l.dir)
+	proc.WithEnvironmentVariables(ctx, l.envs...)
 
-	if s.FastAPI.Settings.HotReload {
-		conf := services.NewWatchConfiguration(requirements)
-		if err := s.SetupWatcher(ctx, conf, s.EventHandler); err != nil {
-			s.Wool.Warn("error in watcher", wool.ErrField(err))
-		}
+	runningContext := l.wool.Inject(context.Background())
+	if err := proc.Start(runningContext); err != nil {
+		return nil, tail, err
 	}
 
-	s.Infof("starting fastapi app via uv")
-	runningContext := s.Wool.Inject(context.Background())
-	if err := s.runner.Start(runningContext); err != nil {
-		s.runner = nil // failed to start — don't leave a dead proc on the struct
-		return s.Base.Runtime.StartError(err)
+	if l.debugAddress != "" {
+		// The app only imports once an IDE attaches: gating on it would
+		// time out. Start returns as soon as the process is up.
+		_, _ = l.wool.Forward([]byte(fmt.Sprintf("waiting for a debugger to attach on %s", l.debugAddress)))
+		return proc, tail, nil
 	}
 
-	s.Wool.Debug("start done")
-	return s.Base.Runtime.StartResponse()
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+	exited := make(chan error, 1)
+	go func() { exited <- proc.Wait(waitCtx) }()
+	if err := waitReady(ctx, l.readinessURL, l.readinessDeadline, exited); err != nil {
+		// ctx may be the supervisor's, cancelled by Stop: stop anyway.
+		if stopErr := proc.Stop(context.WithoutCancel(ctx)); stopErr != nil {
+			l.wool.Warn("cannot stop unready server", wool.ErrField(stopErr))
+		}
+		return nil, tail, fmt.Errorf("%w; last output:\n%s", err, tail)
+	}
+	return proc, tail, nil
 }
 
-// Test is INHERITED from *pythonruntime.Runtime (uv run pytest).
+// Information adds notes on the running app to the generic statuses, in
+// the StartStatus message: crash supervision and the debugger address.
+func (s *Runtime) Information(ctx context.Context, req *runtimev0.InformationRequest) (*runtimev0.InformationResponse, error) {
+	resp, err := s.Runtime.Information(ctx, req)
+	if err != nil || resp.StartStatus == nil || resp.StartStatus.State != runtimev0.StartStatus_STARTED {
+		return resp, err
+	}
+	var notes []string
+	if note := s.supervisionNote(); note != "" {
+		notes = append(notes, note)
+	}
+	if address := s.debugAddress(); address != "" {
+		notes = append(notes, "debugpy listening on "+address)
+	}
+	s.mu.Lock()
+	traces, metrics := s.traces, s.metrics
+	s.mu.Unlock()
+	if traces != nil {
+		notes = append(notes, "traces in "+traces.path)
+	}
+	if metrics != nil {
+		if summary := metrics.Summary(); summary != "" {
+			notes = append(notes, summary)
+		}
+	}
+	if len(notes) == 0 {
+		return resp, nil
+	}
+	resp.StartStatus = &runtimev0.StartStatus{
+		State:   runtimev0.StartStatus_STARTED,
+		Message: strings.Join(notes, "; "),
+	}
+	return resp, nil
+}
+
+// Test is in pytest.go.
 // Lint is INHERITED from *pythonruntime.Runtime (uv run ruff check).
 // Build is INHERITED (no-op for Python).
 
-func (s *Runtime) Stop(ctx context.Context, req *runtimev0.StopRequest) (*runtimev0.StopResponse, error) {
+func (s *Runtime) Stop(ctx context.Context, req *runtimev0.StopRequest) (resp *runtimev0.StopResponse, err error) {
+	if err := s.lifecycle.begin(opStop); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.lifecycle.end(opStop, err == nil && resp.GetStatus().GetState() == runtimev0.StopStatus_SUCCESS)
+	}()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Wool.Debug("stopping service")
-	if s.runner != nil {
-		if err := s.runner.Stop(ctx); err != nil {
+	// setRunner invalidates the supervisor first: this exit is intended.
+	var report *shutdownReport
+	if runner := s.setRunner(nil); runner != nil {
+		var err error
+		if report, err = s.shutdown(ctx, runner); err != nil {
 			return s.Base.Runtime.StopError(err)
 		}
-		s.runner = nil
+		s.Infof("%s", report.Message)
+	}
+	// The crash supervisor was cancelled by setRunner: once it returns,
+	// nothing relaunches the server with the environment shut down below.
+	s.waitSupervisors()
+	// A test run uses the runner environment: end it first.
+	if s.testWatch != nil {
+		s.testWatch.Stop()
+		s.testWatch = nil
 	}
 	if s.runnerEnvironment != nil {
 		if err := s.runnerEnvironment.Shutdown(ctx); err != nil {
@@ -383,61 +650,120 @@ func (s *Runtime) Stop(ctx context.Context, req *runtimev0.StopRequest) (*runtim
 	// run exactly once — Stop must not close Events itself, or it races that
 	// goroutine into a "close of closed channel" panic.
 	s.Base.StopWatcher()
-	return s.Base.Runtime.StopResponse()
+	if s.openapiRefresh != nil {
+		s.openapiRefresh.Stop()
+	}
+	s.stopMetrics(ctx)
+
+	resp, err = s.Base.Runtime.StopResponse()
+	if report != nil && resp != nil {
+		// StopStatus has no dedicated field: the message says whether the
+		// server drained cleanly or had to be killed.
+		resp.Status.Message = report.Message
+	}
+	return resp, err
 }
 
-func (s *Runtime) Destroy(ctx context.Context, req *runtimev0.DestroyRequest) (*runtimev0.DestroyResponse, error) {
+func (s *Runtime) Destroy(ctx context.Context, req *runtimev0.DestroyRequest) (resp *runtimev0.DestroyResponse, err error) {
+	if err := s.lifecycle.begin(opDestroy); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.lifecycle.end(opDestroy, err == nil && resp.GetStatus().GetState() == runtimev0.DestroyStatus_SUCCESS)
+	}()
 	defer s.Wool.Catch()
 	ctx = s.Wool.Inject(ctx)
 
 	s.Wool.Debug("Destroying service")
 
-	s.Wool.Debug("removing cache")
-	if err := shared.EmptyDir(ctx, s.cacheLocation); err != nil {
-		// Best-effort: a failed cache wipe must NOT short-circuit Destroy and
-		// skip the container teardown below — that would leak the running
-		// container (the far more expensive resource).
-		s.Wool.Warn("cannot remove cache", wool.ErrField(err))
+	s.mu.Lock()
+	traces := s.traces
+	s.traces = nil
+	s.mu.Unlock()
+	if traces != nil {
+		if err := traces.Close(ctx); err != nil {
+			s.Wool.Warn("cannot stop otlp receiver", wool.ErrField(err))
+		}
 	}
 
-	if s.Base.Runtime.IsContainerRuntime() {
-		s.Wool.Debug("running in container")
-		dockerEnv, err := dockerrun.NewDockerEnvironment(ctx, runtimeImage, s.Service.SourceLocation, s.Base.Runtime.UniqueWithWorkspace())
-		if err != nil {
-			return s.Base.Runtime.DestroyError(err)
-		}
-		if err := dockerEnv.Shutdown(ctx); err != nil {
-			return s.Base.Runtime.DestroyError(err)
+	// Best-effort per artifact: a directory that cannot be removed must not
+	// leak the container (the far more expensive resource), and the other
+	// way round.
+	removed, err := s.destroyArtifacts(ctx)
+	for _, r := range removed {
+		s.Infof("removed %s", r)
+	}
+	if err != nil {
+		return s.Base.Runtime.DestroyError(err)
+	}
+	// Destroy → Load → Init must build the environment again: the flake,
+	// the cache directories and the manifest are gone.
+	s.cacheLocation = ""
+	s.runnerEnvironment = nil
+	s.FastAPI.Service.ActiveEnv = nil
+
+	resp, err = s.Base.Runtime.DestroyResponse()
+	if resp != nil {
+		resp.Status.Message = "nothing to remove"
+		if len(removed) > 0 {
+			resp.Status.Message = "removed " + strings.Join(removed, ", ")
 		}
 	}
-	return s.Base.Runtime.DestroyResponse()
+	return resp, err
 }
 
+// EventHandler reacts to watcher events while the service is running; an
+// event racing Stop is dropped, and Stop waits for one being handled.
 func (s *Runtime) EventHandler(event code.Change) error {
 	if strings.Contains(event.Path, "api.json") {
 		return nil
 	}
+	s.lifecycle.whileRunning(func() {
+		s.handleEvent(event)
+	})
+	return nil
+}
+
+func (s *Runtime) handleEvent(event code.Change) {
+	if s.testWatch != nil && strings.HasSuffix(event.Path, ".py") {
+		s.testWatch.Trigger()
+		if strings.HasPrefix(event.Path, "code/tests/") {
+			return
+		}
+	}
+	if !s.FastAPI.Settings.HotReload {
+		return
+	}
+	if isDependencyManifest(event.Path) {
+		s.onDependencyChange(s.Wool.Inject(context.Background()))
+		return
+	}
 	if strings.HasSuffix(event.Path, ".py") {
-		// uvicorn --reload picks these up; no action needed here.
-		return nil
+		// uvicorn --reload picks the code up; every other mode serves it
+		// once restarted. The published contract may have changed with it.
+		if mode, err := s.FastAPI.Settings.Mode(); err == nil && mode != ServerModeReload {
+			s.onSourceChange(s.Wool.Inject(context.Background()))
+		}
+		s.openapiRefresh.Trigger()
+		return
 	}
 	s.Base.Runtime.DesiredStart()
-	return nil
+}
+
+// onSourceChange restarts the server on a source change when it does not
+// reload by itself (every server-mode but dev-reload).
+func (s *Runtime) onSourceChange(ctx context.Context) {
+	if err := s.restart(ctx); err != nil {
+		s.Wool.Error("cannot restart fastapi app after a source change", wool.ErrField(err))
+		s.Base.Runtime.MarkRunnerExited(err)
+		return
+	}
+	s.Infof("restarted fastapi app with the changed code")
 }
 
 // GenerateOpenAPI runs the project's src/openapi.py under uv to regenerate
 // the OpenAPI spec. Convention: the project ships a small openapi.py that
 // imports src.main and dumps the schema. See templates/factory.
 func (s *Runtime) GenerateOpenAPI(ctx context.Context) error {
-	proc, err := s.runnerEnvironment.NewProcess("uv", "run", "python", "src/openapi.py")
-	if err != nil {
-		return s.Wool.Wrapf(err, "cannot create openapi runner")
-	}
-	proc.WithDir(s.Service.SourceLocation)
-	proc.WithEnvironmentVariables(ctx, resources.Env("PYTHONPATH", s.Service.SourceLocation))
-
-	if err := proc.Run(ctx); err != nil {
-		return s.Wool.Wrapf(err, "cannot run openapi")
-	}
-	return nil
+	return runOpenAPIScript(ctx, s.runnerEnvironment, s.Service.SourceLocation)
 }
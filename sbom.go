@@ -0,0 +1,208 @@
+package main
+
+// sbom.go — the image SBOM written by Build.
+//
+// Every image Build produces gets a CycloneDX 1.5 document, next to the
+// Dockerfile in builder/. Its subject is the image; it depends on the
+// base image, on the Python project with the packages uv.lock pins (the
+// inventory of the inherited SBOM, from uv's exporter) and on each
+// requirements.All() component copied into the image, with a digest of
+// its files. The BuildResponse message references the file and the
+// document's digest.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/codefly-dev/core/agents/services/sbom"
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	"github.com/codefly-dev/core/resources"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// sbomPath is relative to the service location.
+	sbomPath = "builder/sbom.cdx.json"
+	// sbomTool identifies the composition in the document metadata.
+	sbomTool = "codefly-fastapi-image"
+)
+
+// imageInventory is what goes into the image.
+type imageInventory struct {
+	Image *resources.DockerImage
+	Base  *resources.DockerImage
+	// Python is the uv.lock inventory.
+	Python *sbom.Result
+	// Root is the directory the Components are relative to.
+	Root       string
+	Components []string
+}
+
+// ImageSBOM inventories the locked packages and composes the image
+// document.
+func (s *Builder) ImageSBOM(ctx context.Context, image *resources.DockerImage, base *resources.DockerImage) (*sbom.Result, error) {
+	python, err := sbom.Python(ctx, s.FastAPI.Service.SourceLocation, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot inventory uv.lock for the SBOM: %w", err)
+	}
+	return composeImageSBOM(imageInventory{
+		Image:      image,
+		Base:       base,
+		Python:     python,
+		Root:       s.Location,
+		Components: requirements.All(),
+	})
+}
+
+func composeImageSBOM(inventory imageInventory) (*sbom.Result, error) {
+	project := inventory.Python.Bom.GetMetadata().GetComponent()
+	if project == nil {
+		return nil, fmt.Errorf("the uv.lock inventory has no project component")
+	}
+	root := imageComponent(inventory.Image)
+	base := imageComponent(inventory.Base)
+	components := append(slices.Clone(inventory.Python.Bom.Components), project, base)
+	dependencies := append(slices.Clone(inventory.Python.Bom.Dependencies), &agentv0.Dependency{
+		Ref:       root.BomRef,
+		DependsOn: []string{base.BomRef, project.BomRef},
+	})
+	for _, path := range inventory.Components {
+		digest, err := treeDigest(filepath.Join(inventory.Root, path))
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash image component %s: %w", path, err)
+		}
+		component := &agentv0.Component{
+			Name:    path,
+			Type:    agentv0.ComponentType_APPLICATION,
+			Version: project.Version,
+			Purl:    fmt.Sprintf("pkg:generic/%s@%s?path=%s", url.PathEscape(project.Name), url.PathEscape(project.Version), url.QueryEscape(path)),
+			Hashes:  []*agentv0.Hash{{Algorithm: "SHA-256", Content: digest}},
+		}
+		component.BomRef = component.Purl
+		components = append(components, component)
+		dependencies[len(dependencies)-1].DependsOn = append(dependencies[len(dependencies)-1].DependsOn, component.BomRef)
+	}
+	return finishSBOM(root, components, dependencies, sbomTool+"+"+inventory.Python.Tool)
+}
+
+// imageComponent is a container component with a docker package URL.
+func imageComponent(image *resources.DockerImage) *agentv0.Component {
+	name := image.Name
+	if image.Repository != "" {
+		name = image.Repository + "/" + image.Name
+	}
+	version := image.Tag
+	if image.Digest != "" {
+		version = image.Digest
+	}
+	purl := "pkg:docker/" + name
+	if version != "" {
+		purl += "@" + url.PathEscape(version)
+	}
+	return &agentv0.Component{
+		Name:    image.Name,
+		Group:   image.Repository,
+		Version: version,
+		Type:    agentv0.ComponentType_CONTAINER,
+		Purl:    purl,
+		BomRef:  purl,
+	}
+}
+
+// finishSBOM sorts the document, as core's generators do, so the serial
+// number and the digest only change with the inventory.
+func finishSBOM(root *agentv0.Component, components []*agentv0.Component, dependencies []*agentv0.Dependency, tool string) (*sbom.Result, error) {
+	sort.Slice(components, func(i, j int) bool { return components[i].BomRef < components[j].BomRef })
+	for _, dependency := range dependencies {
+		sort.Strings(dependency.DependsOn)
+	}
+	sort.Slice(dependencies, func(i, j int) bool { return dependencies[i].Ref < dependencies[j].Ref })
+
+	seed := root.BomRef
+	for _, component := range components {
+		seed += "\n" + component.BomRef + "#" + hashesOf(component)
+	}
+	for _, dependency := range dependencies {
+		seed += "\n" + dependency.Ref + "=" + strings.Join(dependency.DependsOn, ",")
+	}
+	bom := &agentv0.Bom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.NewSHA1(uuid.NameSpaceURL, []byte(seed)).String(),
+		Version:      1,
+		Components:   components,
+		Dependencies: dependencies,
+		Metadata: &agentv0.Metadata{
+			Component: root,
+			Tools:     []*agentv0.Tool{{Vendor: "codefly.dev", Name: tool, Version: "1"}},
+		},
+	}
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(bom)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(payload)
+	return &sbom.Result{Bom: bom, Tool: tool, Language: "DOCKER", SHA256: hex.EncodeToString(digest[:])}, nil
+}
+
+func hashesOf(component *agentv0.Component) string {
+	var hashes []string
+	for _, hash := range component.Hashes {
+		hashes = append(hashes, hash.Algorithm+":"+hash.Content)
+	}
+	return strings.Join(hashes, ",")
+}
+
+// treeDigest is the SHA-256 of the files under path, as COPY copies them:
+// each relative path and content, in lexical order.
+func treeDigest(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeSBOM writes the document to builder/sbom.cdx.json and returns its
+// path, relative to the service.
+func (s *Builder) writeSBOM(result *sbom.Result) (string, error) {
+	content, err := sbom.MarshalCycloneDXJSON(result.Bom)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Local(sbomPath)), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(s.Local(sbomPath), append(content, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return sbomPath, nil
+}
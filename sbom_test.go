@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	agentv0 "github.com/codefly-dev/core/generated/go/codefly/services/agent/v0"
+	builderv0 "github.com/codefly-dev/core/generated/go/codefly/services/builder/v0"
+	"github.com/codefly-dev/core/resources"
+)
+
+const uvSBOMSample = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.5",
+  "metadata": {
+    "component": {"type": "application", "bom-ref": "api-1@0.1.0", "name": "api", "version": "0.1.0"}
+  },
+  "components": [
+    {"type": "library", "bom-ref": "fastapi-2@0.115.0", "name": "fastapi", "version": "0.115.0", "purl": "pkg:pypi/fastapi@0.115.0"},
+    {"type": "library", "bom-ref": "starlette-3@0.38.6", "name": "starlette", "version": "0.38.6", "purl": "pkg:pypi/starlette@0.38.6"}
+  ],
+  "dependencies": [
+    {"ref": "api-1@0.1.0", "dependsOn": ["fastapi-2@0.115.0"]},
+    {"ref": "fastapi-2@0.115.0", "dependsOn": ["starlette-3@0.38.6"]}
+  ]
+}`
+
+// newTestSBOMBuilder is a Builder over a service with code/src.
+func newTestSBOMBuilder(t *testing.T) *Builder {
+	tooling := newTestTooling(t)
+	svc := tooling.FastAPI
+	svc.Location = t.TempDir()
+	svc.Service.SourceLocation = filepath.Join(svc.Location, "code")
+	if err := os.MkdirAll(filepath.Join(svc.Service.SourceLocation, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(svc.Service.SourceLocation, "src", "main.py"), []byte("app = None\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return NewBuilder(svc)
+}
+
+func TestImageSBOM(t *testing.T) {
+	ctx := context.Background()
+	args := fakeUV(t, uvSBOMSample, 0)
+	builder := newTestSBOMBuilder(t)
+	image := &resources.DockerImage{Repository: "codefly", Name: "api", Tag: "0.1.0"}
+	base := &resources.DockerImage{Repository: "codeflydev", Name: "python", Tag: "3.12"}
+
+	result, err := builder.ImageSBOM(ctx, image, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(args); !strings.Contains(string(content), "--format cyclonedx1.5 --frozen --no-dev") {
+		t.Errorf("ran uv %s", content)
+	}
+	bom := result.Bom
+	if root := bom.Metadata.Component; root.Purl != "pkg:docker/codefly/api@0.1.0" || root.Type != agentv0.ComponentType_CONTAINER {
+		t.Errorf("got %v", root)
+	}
+	refs := map[string]*agentv0.Component{}
+	for _, component := range bom.Components {
+		refs[component.BomRef] = component
+	}
+	for _, want := range []string{"pkg:docker/codeflydev/python@3.12", "api-1@0.1.0", "fastapi-2@0.115.0", "starlette-3@0.38.6", "pkg:generic/api@0.1.0?path=code%2Fsrc"} {
+		if refs[want] == nil {
+			t.Errorf("%s missing from %v", want, bom.Components)
+		}
+	}
+	if src := refs["pkg:generic/api@0.1.0?path=code%2Fsrc"]; src != nil && (len(src.Hashes) != 1 || len(src.Hashes[0].Content) != 64) {
+		t.Errorf("got %v", src)
+	}
+	var imageDependency *agentv0.Dependency
+	for _, dependency := range bom.Dependencies {
+		if dependency.Ref == "pkg:docker/codefly/api@0.1.0" {
+			imageDependency = dependency
+		}
+	}
+	if imageDependency == nil || !slices.Equal(imageDependency.DependsOn, []string{"api-1@0.1.0", "pkg:docker/codeflydev/python@3.12", "pkg:generic/api@0.1.0?path=code%2Fsrc"}) {
+		t.Errorf("got %v", imageDependency)
+	}
+
+	// Deterministic until a copied file changes.
+	again, _ := builder.ImageSBOM(ctx, image, base)
+	if again.SHA256 != result.SHA256 || again.Bom.SerialNumber != bom.SerialNumber {
+		t.Error("the same inventory gave another document")
+	}
+	if err := os.WriteFile(filepath.Join(builder.Location, "code", "src", "main.py"), []byte("app = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := builder.ImageSBOM(ctx, image, base); changed.SHA256 == result.SHA256 || changed.Bom.SerialNumber == bom.SerialNumber {
+		t.Error("a changed component kept the document")
+	}
+
+	file, err := builder.writeSBOM(result)
+	if err != nil || file != sbomPath {
+		t.Fatalf("got %s, %v", file, err)
+	}
+	content, err := os.ReadFile(filepath.Join(builder.Location, sbomPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		BomFormat   string `json:"bomFormat"`
+		SpecVersion string `json:"specVersion"`
+		Components  []struct {
+			Type string `json:"type"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(content, &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.BomFormat != "CycloneDX" || document.SpecVersion != "1.5" || len(document.Components) != 5 {
+		t.Errorf("got %s", content)
+	}
+}
+
+func TestImageSBOMWithoutLock(t *testing.T) {
+	fakeUV(t, "error: Unable to find lockfile at `uv.lock`\n", 2)
+	builder := newTestSBOMBuilder(t)
+	_, err := builder.ImageSBOM(context.Background(), &resources.DockerImage{Name: "api"}, &resources.DockerImage{Name: "python"})
+	if err == nil || !strings.HasPrefix(err.Error(), "cannot inventory uv.lock for the SBOM") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestNoteSBOM(t *testing.T) {
+	resp := &builderv0.BuildResponse{State: &builderv0.BuildStatus{State: builderv0.BuildStatus_SUCCESS, Message: "built api:0.1.0"}}
+	noteSBOM(resp, "/tmp/sbom.json", "abc")
+	if want := "built api:0.1.0; sbom: /tmp/sbom.json (sha256 abc)"; resp.State.Message != want {
+		t.Fatalf("message = %q, want %q", resp.State.Message, want)
+	}
+
+	resp = &builderv0.BuildResponse{State: &builderv0.BuildStatus{State: builderv0.BuildStatus_SUCCESS}}
+	noteSBOM(resp, "/tmp/sbom.json", "abc")
+	if want := "sbom: /tmp/sbom.json (sha256 abc)"; resp.State.Message != want {
+		t.Fatalf("message = %q, want %q", resp.State.Message, want)
+	}
+
+	resp = &builderv0.BuildResponse{State: &builderv0.BuildStatus{State: builderv0.BuildStatus_ERROR, Message: "docker failed"}}
+	noteSBOM(resp, "/tmp/sbom.json", "abc")
+	if resp.State.Message != "docker failed" {
+		t.Fatalf("failed build message changed to %q", resp.State.Message)
+	}
+}
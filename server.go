@@ -0,0 +1,167 @@
+package main
+
+// server.go — the uvicorn process model.
+//
+// Runtime.Start and the Docker image CMD both derive their server command from
+// ServerCommand, so `codefly run` exercises the same process model the image
+// ships with. Only the dev-reload mode is local-only: the image always runs a
+// production mode (see ImageMode). The image listens on ContainerPort, the
+// port codefly gives the REST endpoint in containers and Kubernetes, which
+// the Deployment and the Service use as well.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codefly-dev/core/standards"
+)
+
+// ServerMode selects how the FastAPI app is served.
+type ServerMode string
+
+const (
+	// ServerModeReload is `uvicorn --reload`: one process, restarted by
+	// uvicorn itself on source changes. Local development only.
+	ServerModeReload ServerMode = "dev-reload"
+	// ServerModeSingle is a single uvicorn process without reload.
+	ServerModeSingle ServerMode = "single"
+	// ServerModeWorkers is uvicorn's own multi-process supervisor (--workers).
+	ServerModeWorkers ServerMode = "workers"
+	// ServerModeGunicorn is gunicorn managing uvicorn workers. Requires
+	// gunicorn in the project dependencies (`uv add gunicorn`).
+	ServerModeGunicorn ServerMode = "gunicorn"
+)
+
+// defaultWorkers is used by the multi-process modes when Workers is unset.
+const defaultWorkers = 2
+
+// Mode resolves the effective server mode. An explicit server-mode wins;
+// otherwise hot-reload keeps its historical meaning (reload vs. a plain
+// single process).
+func (s *Settings) Mode() (ServerMode, error) {
+	switch ServerMode(s.ServerMode) {
+	case "":
+		if s.HotReload {
+			return ServerModeReload, nil
+		}
+		return ServerModeSingle, nil
+	case ServerModeReload, ServerModeSingle, ServerModeWorkers, ServerModeGunicorn:
+		return ServerMode(s.ServerMode), nil
+	default:
+		return "", fmt.Errorf("unknown server-mode %q (expected %s, %s, %s or %s)",
+			s.ServerMode, ServerModeReload, ServerModeSingle, ServerModeWorkers, ServerModeGunicorn)
+	}
+}
+
+// ImageMode is the mode baked into the Docker image: the configured mode,
+// except that dev-reload is replaced by a single process.
+func (s *Settings) ImageMode() (ServerMode, error) {
+	mode, err := s.Mode()
+	if err != nil {
+		return "", err
+	}
+	if mode == ServerModeReload {
+		return ServerModeSingle, nil
+	}
+	return mode, nil
+}
+
+// ValidateServer rejects option combinations uvicorn would refuse or
+// silently ignore.
+func (s *Settings) ValidateServer() error {
+	mode, err := s.Mode()
+	if err != nil {
+		return err
+	}
+	if s.Workers < 0 || s.KeepAlive < 0 || s.GracefulTimeout < 0 || s.DrainTimeout < 0 {
+		return fmt.Errorf("workers, keep-alive, graceful-timeout and drain-timeout must not be negative")
+	}
+	if s.DebugPort < 0 || s.DebugPort > 65535 {
+		return fmt.Errorf("debug-port %d is not a valid port", s.DebugPort)
+	}
+	if s.DrainTimeout > 0 && s.GracefulTimeout >= s.DrainTimeout {
+		return fmt.Errorf("graceful-timeout (%ds) must be shorter than drain-timeout (%ds)", s.GracefulTimeout, s.DrainTimeout)
+	}
+	if s.Workers > 1 && (mode == ServerModeReload || mode == ServerModeSingle) {
+		return fmt.Errorf("workers=%d requires server-mode %s or %s (got %s)", s.Workers, ServerModeWorkers, ServerModeGunicorn, mode)
+	}
+	if s.RootPath != "" {
+		if !strings.HasPrefix(s.RootPath, "/") || strings.ContainsAny(s.RootPath, " \t\n") {
+			return fmt.Errorf("root-path %q must be a path starting with /", s.RootPath)
+		}
+		// Gunicorn's uvicorn workers have no root path option.
+		if mode == ServerModeGunicorn {
+			return fmt.Errorf("root-path is not supported with server-mode %s", ServerModeGunicorn)
+		}
+	}
+	if s.ForwardedAllowIPs != "" && !s.ProxyHeaders {
+		return fmt.Errorf("forwarded-allow-ips requires proxy-headers")
+	}
+	return nil
+}
+
+// forwardedAllowIPs are the proxies trusted with proxy-headers.
+func (s *Settings) forwardedAllowIPs() string {
+	if s.ForwardedAllowIPs == "" {
+		return "*"
+	}
+	return s.ForwardedAllowIPs
+}
+
+// ContainerPort is the port the app listens on in the image: the port of
+// the REST endpoint's API in container and Kubernetes network mappings.
+func (s *Service) ContainerPort() uint16 {
+	if s.RestEndpoint != nil && s.RestEndpoint.Api != "" {
+		return standards.Port(s.RestEndpoint.Api)
+	}
+	return standards.Port(standards.REST)
+}
+
+// ServerCommand returns the argv serving src.main:app on host:port in the
+// given mode. It carries no `uv run` prefix: the Runtime adds it, the image
+// runs the venv binaries directly.
+func (s *Settings) ServerCommand(mode ServerMode, host string, port uint16) []string {
+	workers := s.Workers
+	if workers == 0 {
+		workers = defaultWorkers
+	}
+	if mode == ServerModeGunicorn {
+		args := []string{"gunicorn", "src.main:app",
+			"--worker-class", "uvicorn.workers.UvicornWorker",
+			"--bind", fmt.Sprintf("%s:%d", host, port),
+			"--workers", fmt.Sprintf("%d", workers)}
+		if s.KeepAlive > 0 {
+			args = append(args, "--keep-alive", fmt.Sprintf("%d", s.KeepAlive))
+		}
+		if s.GracefulTimeout > 0 {
+			args = append(args, "--graceful-timeout", fmt.Sprintf("%d", s.GracefulTimeout))
+		}
+		if s.ProxyHeaders {
+			// The uvicorn workers read the headers already; gunicorn
+			// passes them its trusted proxies.
+			args = append(args, "--forwarded-allow-ips", s.forwardedAllowIPs())
+		}
+		return args
+	}
+
+	args := []string{"uvicorn", "src.main:app", "--host", host, "--port", fmt.Sprintf("%d", port)}
+	switch mode {
+	case ServerModeReload:
+		args = append(args, "--reload")
+	case ServerModeWorkers:
+		args = append(args, "--workers", fmt.Sprintf("%d", workers))
+	}
+	if s.KeepAlive > 0 {
+		args = append(args, "--timeout-keep-alive", fmt.Sprintf("%d", s.KeepAlive))
+	}
+	if s.GracefulTimeout > 0 {
+		args = append(args, "--timeout-graceful-shutdown", fmt.Sprintf("%d", s.GracefulTimeout))
+	}
+	if s.RootPath != "" {
+		args = append(args, "--root-path", s.RootPath)
+	}
+	if s.ProxyHeaders {
+		args = append(args, "--proxy-headers", "--forwarded-allow-ips", s.forwardedAllowIPs())
+	}
+	return args
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/standards"
+	"github.com/codefly-dev/core/templates"
+	"gopkg.in/yaml.v3"
+)
+
+// TestServerModeDefaultsFromHotReload keeps the historical meaning of
+// hot-reload when no explicit server-mode is set.
+func TestServerModeDefaultsFromHotReload(t *testing.T) {
+	for _, tc := range []struct {
+		hotReload bool
+		want      ServerMode
+	}{
+		{true, ServerModeReload},
+		{false, ServerModeSingle},
+	} {
+		s := &Settings{HotReload: tc.hotReload}
+		got, err := s.Mode()
+		if err != nil {
+			t.Fatalf("Mode: %v", err)
+		}
+		if got != tc.want {
+			t.Errorf("hot-reload=%v: got %s want %s", tc.hotReload, got, tc.want)
+		}
+	}
+}
+
+func TestServerCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		settings Settings
+		want     []string
+	}{
+		{
+			name:     "reload",
+			settings: Settings{ServerMode: "dev-reload"},
+			want:     []string{"uvicorn", "src.main:app", "--host", "0.0.0.0", "--port", "9000", "--reload"},
+		},
+		{
+			name:     "single with timeouts",
+			settings: Settings{ServerMode: "single", KeepAlive: 5, GracefulTimeout: 30},
+			want: []string{"uvicorn", "src.main:app", "--host", "0.0.0.0", "--port", "9000",
+				"--timeout-keep-alive", "5", "--timeout-graceful-shutdown", "30"},
+		},
+		{
+			name:     "workers",
+			settings: Settings{ServerMode: "workers", Workers: 4},
+			want:     []string{"uvicorn", "src.main:app", "--host", "0.0.0.0", "--port", "9000", "--workers", "4"},
+		},
+		{
+			name:     "gunicorn default workers",
+			settings: Settings{ServerMode: "gunicorn", GracefulTimeout: 10},
+			want: []string{"gunicorn", "src.main:app", "--worker-class", "uvicorn.workers.UvicornWorker",
+				"--bind", "0.0.0.0:9000", "--workers", "2", "--graceful-timeout", "10"},
+		},
+		{
+			name:     "behind a proxy",
+			settings: Settings{ServerMode: "single", RootPath: "/api", ProxyHeaders: true},
+			want: []string{"uvicorn", "src.main:app", "--host", "0.0.0.0", "--port", "9000",
+				"--root-path", "/api", "--proxy-headers", "--forwarded-allow-ips", "*"},
+		},
+		{
+			name:     "gunicorn behind a proxy",
+			settings: Settings{ServerMode: "gunicorn", ProxyHeaders: true, ForwardedAllowIPs: "10.0.0.1"},
+			want: []string{"gunicorn", "src.main:app", "--worker-class", "uvicorn.workers.UvicornWorker",
+				"--bind", "0.0.0.0:9000", "--workers", "2", "--forwarded-allow-ips", "10.0.0.1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mode, err := tc.settings.Mode()
+			if err != nil {
+				t.Fatalf("Mode: %v", err)
+			}
+			got := tc.settings.ServerCommand(mode, "0.0.0.0", 9000)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v\nwant %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestImageModeNeverReloads proves the Docker image never ships --reload.
+func TestImageModeNeverReloads(t *testing.T) {
+	s := &Settings{HotReload: true}
+	mode, err := s.ImageMode()
+	if err != nil {
+		t.Fatalf("ImageMode: %v", err)
+	}
+	if mode != ServerModeSingle {
+		t.Errorf("got %s want %s", mode, ServerModeSingle)
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	src := []byte(`
+server-mode: workers
+workers: 3
+keep-alive: 5
+graceful-timeout: 20
+`)
+	var s Settings
+	if err := yaml.Unmarshal(src, &s); err != nil {
+		t.Fatalf("yaml unmarshal: %v", err)
+	}
+	if err := s.ValidateServer(); err != nil {
+		t.Errorf("valid settings rejected: %v", err)
+	}
+	if s.Workers != 3 || s.KeepAlive != 5 || s.GracefulTimeout != 20 {
+		t.Errorf("server settings not populated: %+v", s)
+	}
+
+	if err := (&Settings{ServerMode: "threads"}).ValidateServer(); err == nil {
+		t.Error("unknown server-mode accepted")
+	}
+	if err := (&Settings{ServerMode: "dev-reload", Workers: 2}).ValidateServer(); err == nil {
+		t.Error("workers with dev-reload accepted")
+	}
+	for _, invalid := range []Settings{
+		{RootPath: "api"},
+		{RootPath: "/my api"},
+		{ServerMode: "gunicorn", RootPath: "/api"},
+		{ForwardedAllowIPs: "10.0.0.1"},
+	} {
+		if err := invalid.ValidateServer(); err == nil {
+			t.Errorf("%+v accepted", invalid)
+		}
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	if err := (&Settings{}).Validate(); err != nil {
+		t.Errorf("default settings rejected: %v", err)
+	}
+	for name, invalid := range map[string]*Settings{
+		"server":          {ServerMode: "threads"},
+		"observability":   {Observability: &Observability{Enabled: true, SampleRatio: 2}},
+		"migrations":      {Migrations: &Migrations{}},
+		"typecheck":       {Typecheck: &Typecheck{Checker: "pyre"}},
+		"audit":           {Audit: &Audit{FailOn: "severe"}},
+		"licenses":        {Licenses: &Licenses{Allow: []string{"[MIT"}}},
+		"system packages": {SystemPackages: []SystemPackage{{}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%s: invalid settings accepted", name)
+		}
+	}
+}
+
+func TestContainerPort(t *testing.T) {
+	svc := &Service{}
+	if got := svc.ContainerPort(); got != 8080 {
+		t.Errorf("without endpoint: got %d", got)
+	}
+	svc.RestEndpoint = &basev0.Endpoint{Name: "api", Api: standards.REST}
+	if got := svc.ContainerPort(); got != standards.Port(standards.REST) {
+		t.Errorf("got %d", got)
+	}
+
+	rendered, err := templates.ApplyTemplateFrom(context.Background(), shared.Embed(builderFS), "templates/builder/Dockerfile", DockerTemplating{Command: `["uvicorn"]`, Port: 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered, "EXPOSE 8080\nCMD [\"uvicorn\"]") {
+		t.Errorf("got:\n%s", rendered)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+// shutdown.go — graceful shutdown of the server process.
+//
+// runners.Proc.Stop escalates from SIGTERM to SIGKILL after a fixed grace
+// of a few seconds, which cuts off in-flight requests and the FastAPI
+// lifespan shutdown (including the plugins' shutdown hooks). Instead, the
+// server is launched through a tiny shell wrapper that records its pid:
+// Stop sends SIGTERM itself, waits up to drain-timeout for uvicorn to
+// finish, and only then kills it; Proc.Stop reaps whatever is left.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	// defaultDrainTimeout bounds how long Stop waits after SIGTERM.
+	defaultDrainTimeout = 10 * time.Second
+	// drainHeadroom is left for the lifespan shutdown after graceful-timeout.
+	drainHeadroom = 5 * time.Second
+
+	// containerPidFile lives inside the container: the pid is only
+	// meaningful in its namespace, and kill runs there too.
+	containerPidFile = "/tmp/codefly-server.pid"
+)
+
+// DrainDeadline is the drain-timeout setting. Unset, it is the default, or
+// graceful-timeout plus drainHeadroom when that is longer: uvicorn only
+// runs the lifespan shutdown once its graceful timeout is over.
+func (s *Settings) DrainDeadline() time.Duration {
+	if s.DrainTimeout > 0 {
+		return time.Duration(s.DrainTimeout) * time.Second
+	}
+	return max(defaultDrainTimeout, time.Duration(s.GracefulTimeout)*time.Second+drainHeadroom)
+}
+
+// withPidFile wraps argv so the process writes its pid to pidFile, then
+// execs argv in place: the recorded pid is the server's own (uv, which
+// forwards signals to uvicorn).
+func withPidFile(pidFile string, argv []string) []string {
+	return append([]string{"-c", `echo $$ > "$0" && exec "$@"`, pidFile}, argv...)
+}
+
+// pidFile is where the running server records its pid.
+func (s *Runtime) pidFile() string {
+	if s.Base.Runtime.IsContainerRuntime() {
+		return containerPidFile
+	}
+	return path.Join(s.cacheLocation, "server.pid")
+}
+
+// shutdownReport tells the caller of Stop how the server went down.
+type shutdownReport struct {
+	Clean   bool
+	Message string
+}
+
+// shutdown drains proc: SIGTERM, wait up to the drain timeout, then kill.
+// Shutdown is clean when the server exited by itself, with status 0, inside
+// the window. The returned error is only set when the process could not be
+// stopped at all.
+func (s *Runtime) shutdown(ctx context.Context, proc runners.Proc) (*shutdownReport, error) {
+	drain := s.FastAPI.Settings.DrainDeadline()
+
+	if running, err := proc.IsRunning(ctx); err == nil && !running {
+		return &shutdownReport{Message: "server had already exited"}, s.reap(ctx, proc)
+	}
+
+	started := time.Now()
+	if err := s.signal(ctx, syscall.SIGTERM); err != nil {
+		s.Wool.Warn("cannot send SIGTERM, stopping through the runner", wool.ErrField(err))
+		return &shutdownReport{Message: fmt.Sprintf("cannot signal server (%v); stopped by the runner", err)}, s.reap(ctx, proc)
+	}
+	s.Wool.Debug("draining server", wool.Field("timeout", drain))
+
+	waitCtx, cancel := context.WithTimeout(ctx, drain)
+	defer cancel()
+	err := proc.Wait(waitCtx)
+	elapsed := time.Since(started).Round(100 * time.Millisecond)
+
+	var report *shutdownReport
+	switch {
+	case err != nil && errors.Is(err, context.DeadlineExceeded) && waitCtx.Err() != nil:
+		s.Wool.Warn("server did not drain in time, killing it", wool.Field("timeout", drain))
+		if err := s.signal(ctx, syscall.SIGKILL); err != nil {
+			s.Wool.Warn("cannot send SIGKILL, stopping through the runner", wool.ErrField(err))
+		}
+		report = &shutdownReport{Message: fmt.Sprintf("server did not exit within drain-timeout (%s); killed", drain)}
+	case err != nil:
+		report = &shutdownReport{Message: fmt.Sprintf("server exited with %s after %s", &processExit{Code: exitCode(err), Err: err}, elapsed)}
+	default:
+		report = &shutdownReport{Clean: true, Message: fmt.Sprintf("server drained cleanly in %s", elapsed)}
+	}
+	return report, s.reap(ctx, proc)
+}
+
+// signal sends sig to the pid recorded by withPidFile.
+func (s *Runtime) signal(ctx context.Context, sig syscall.Signal) error {
+	if s.Base.Runtime.IsContainerRuntime() {
+		kill, err := s.runnerEnvironment.NewProcess("sh", "-c", fmt.Sprintf(`kill -%d "$(cat "$0")"`, sig), containerPidFile)
+		if err != nil {
+			return err
+		}
+		return kill.Run(ctx)
+	}
+	content, err := os.ReadFile(s.pidFile())
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %w", s.pidFile(), err)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// reap hands proc to the runner's Stop, which kills whatever survived (the
+// whole process group) and releases the backend's bookkeeping. It returns
+// immediately for a process that already exited.
+func (s *Runtime) reap(ctx context.Context, proc runners.Proc) error {
+	if !s.Base.Runtime.IsContainerRuntime() {
+		_ = os.Remove(s.pidFile())
+	}
+	return proc.Stop(ctx)
+}
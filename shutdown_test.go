@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+)
+
+// startShutdownTarget runs script through the same pid-file wrapper as the
+// server, on the native backend.
+func startShutdownTarget(t *testing.T, script string, drain int) (*Runtime, runners.Proc) {
+	t.Helper()
+	ctx := context.Background()
+
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(ctx).In("shutdown-test")
+	rt.FastAPI.Settings.DrainTimeout = drain
+	rt.cacheLocation = t.TempDir()
+
+	env, err := runners.NewNativeEnvironment(ctx, rt.cacheLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.runnerEnvironment = env
+	proc, err := env.NewProcess("sh", withPidFile(rt.pidFile(), []string{"sh", "-c", script})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond) // let the wrapper write its pid and install the trap
+	return rt, proc
+}
+
+// TestShutdownDrains: a server that finishes its shutdown work after
+// SIGTERM is given the time to do it, and reported clean.
+func TestShutdownDrains(t *testing.T) {
+	rt, proc := startShutdownTarget(t, `trap 'sleep 1; exit 0' TERM; while true; do sleep 0.1; done`, 5)
+
+	report, err := rt.shutdown(context.Background(), proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean || !strings.Contains(report.Message, "drained cleanly") {
+		t.Errorf("expected a clean drain, got %+v", report)
+	}
+}
+
+// TestShutdownKillsAfterDrain: a server ignoring SIGTERM is killed once
+// the drain window is over, and the shutdown is reported unclean.
+func TestShutdownKillsAfterDrain(t *testing.T) {
+	rt, proc := startShutdownTarget(t, `trap '' TERM; while true; do sleep 0.1; done`, 1)
+
+	started := time.Now()
+	report, err := rt.shutdown(context.Background(), proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Clean || !strings.Contains(report.Message, "drain-timeout") {
+		t.Errorf("expected a killed server, got %+v", report)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("killed before the drain window: %s", elapsed)
+	}
+	if running, _ := proc.IsRunning(context.Background()); running {
+		t.Error("server still running after shutdown")
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	cases := []struct {
+		settings Settings
+		want     time.Duration
+	}{
+		{Settings{}, defaultDrainTimeout},
+		{Settings{GracefulTimeout: 20}, 25 * time.Second},
+		{Settings{GracefulTimeout: 2}, defaultDrainTimeout},
+		{Settings{GracefulTimeout: 20, DrainTimeout: 30}, 30 * time.Second},
+	}
+	for _, c := range cases {
+		if got := c.settings.DrainDeadline(); got != c.want {
+			t.Errorf("%+v: got %s, want %s", c.settings, got, c.want)
+		}
+	}
+	if err := (&Settings{GracefulTimeout: 10, DrainTimeout: 10}).ValidateServer(); err == nil {
+		t.Error("graceful-timeout not shorter than drain-timeout was accepted")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+// supervisor.go — crash supervision for the uvicorn process.
+//
+// Start hands the ready process to supervise. A goroutine waits on it; an
+// exit nobody asked for is recorded (exit code + last output lines) and the
+// server is relaunched with exponential backoff. A process that keeps dying
+// exhausts the restart budget and is reported as a crash loop: StartStatus
+// goes to ERROR through MarkRunnerExited, and Information says why.
+//
+// Intentional stops bump the generation under s.mu and cancel the watcher
+// of the previous one, which returns without restarting anything; Stop
+// waits for it. The watcher relaunches from the serverLaunch of the Start
+// that supervised the process, never from the Runtime's fields, which a
+// Load rewrites while the app runs.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"time"
+
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+)
+
+const (
+	// defaultMaxRestarts is how many consecutive crashes are restarted
+	// before the service is declared crash-looping.
+	defaultMaxRestarts = 5
+
+	restartInitialBackoff = time.Second
+	restartMaxBackoff     = 30 * time.Second
+
+	// stableUptime resets the restart budget: a process that served this
+	// long before dying is a fresh crash, not part of a loop.
+	stableUptime = time.Minute
+)
+
+// RestartLimit is the max-restarts setting, or the default. Negative
+// disables automatic restarts: the first crash is reported as is.
+func (s *Settings) RestartLimit() int {
+	switch {
+	case s.MaxRestarts < 0:
+		return 0
+	case s.MaxRestarts == 0:
+		return defaultMaxRestarts
+	}
+	return s.MaxRestarts
+}
+
+// restartBackoff is the delay before restart number attempt (1-based).
+func restartBackoff(attempt int) time.Duration {
+	delay := restartInitialBackoff
+	for i := 1; i < attempt && delay < restartMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, restartMaxBackoff)
+}
+
+// processExit records one unexpected exit of the server.
+type processExit struct {
+	Code   int
+	Err    error
+	Output string
+	At     time.Time
+}
+
+var dockerExitCode = regexp.MustCompile(`exited with code (\d+)`)
+
+// exitCode extracts the exit status from a Proc.Wait error: *exec.ExitError
+// for native and nix processes, a formatted error for docker. -1 when the
+// status is unknown.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if m := dockerExitCode.FindStringSubmatch(err.Error()); m != nil {
+		var code int
+		_, _ = fmt.Sscanf(m[1], "%d", &code)
+		return code
+	}
+	return -1
+}
+
+func (e *processExit) String() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return fmt.Sprintf("exit code %d (%v)", e.Code, e.Err)
+}
+
+// supervision is the crash bookkeeping shown through Information.
+type supervision struct {
+	generation int
+	restarts   int
+	limit      int
+	lastExit   *processExit
+	restarting bool
+	// cancel ends the watcher of the current generation.
+	cancel context.CancelFunc
+}
+
+// setRunner swaps the supervised process and invalidates any watcher of the
+// previous one. It returns the previous process so the caller can stop it.
+func (s *Runtime) setRunner(proc runners.Proc) runners.Proc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.runner
+	s.runner = proc
+	s.supervision.generation++
+	if s.supervision.cancel != nil {
+		s.supervision.cancel()
+		s.supervision.cancel = nil
+	}
+	return previous
+}
+
+// currentRunner returns the supervised process, nil when none is running.
+func (s *Runtime) currentRunner() runners.Proc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runner
+}
+
+// supervise installs proc, launched from launch, as the runner, resets the
+// crash bookkeeping and starts watching it.
+func (s *Runtime) supervise(launch *serverLaunch, proc runners.Proc, tail *outputTail) {
+	s.setRunner(proc)
+	ctx, cancel := context.WithCancel(launch.wool.Inject(context.Background()))
+	s.mu.Lock()
+	generation := s.supervision.generation
+	s.supervision.restarts = 0
+	s.supervision.limit = launch.restartLimit
+	s.supervision.lastExit = nil
+	s.supervision.restarting = false
+	s.supervision.cancel = cancel
+	s.mu.Unlock()
+	s.supervisors.Add(1)
+	go func() {
+		defer s.supervisors.Done()
+		s.watchProcess(ctx, launch, generation, proc, tail)
+	}()
+}
+
+// waitSupervisors returns once the watchers of superseded generations have
+// returned: nothing relaunches the server anymore.
+func (s *Runtime) waitSupervisors() {
+	s.supervisors.Wait()
+}
+
+// watchProcess waits for proc to exit and relaunches it until the restart
+// budget is spent. It returns as soon as its generation is superseded,
+// which cancels ctx.
+func (s *Runtime) watchProcess(ctx context.Context, launch *serverLaunch, generation int, proc runners.Proc, tail *outputTail) {
+	w := launch.wool
+	for {
+		started := time.Now()
+		err := proc.Wait(ctx)
+		exit := &processExit{Code: exitCode(err), Err: err, Output: tail.String(), At: time.Now()}
+
+		attempt, ok := s.recordExit(generation, exit, time.Since(started))
+		if !ok {
+			return
+		}
+		if attempt > launch.restartLimit {
+			s.crashLooping(w, generation, exit, attempt-1)
+			return
+		}
+
+		delay := restartBackoff(attempt)
+		w.Warn("fastapi app exited, restarting",
+			wool.Field("exit", exit.String()),
+			wool.Field("attempt", fmt.Sprintf("%d/%d", attempt, launch.restartLimit)),
+			wool.Field("backoff", delay))
+		select {
+		case <-ctx.Done():
+			// Stopped or restarted during the backoff.
+			return
+		case <-time.After(delay):
+		}
+
+		next, nextTail, err := launch.launch(ctx)
+		if err != nil {
+			// A relaunch that never becomes ready is one more crash; loop
+			// on a process that has already exited.
+			w.Warn("restart failed", wool.ErrField(err))
+			next = exitedProc{err: err}
+			if nextTail == nil {
+				nextTail = tail
+			}
+		}
+		if !s.replaceRunner(generation, next, err == nil) {
+			if err == nil {
+				_ = next.Stop(context.WithoutCancel(ctx))
+			}
+			return
+		}
+		proc, tail = next, nextTail
+	}
+}
+
+// recordExit stores an unexpected exit and returns the restart attempt it
+// leads to. ok is false when the exit was an intentional stop.
+func (s *Runtime) recordExit(generation int, exit *processExit, uptime time.Duration) (attempt int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation != s.supervision.generation {
+		return 0, false
+	}
+	if uptime >= stableUptime {
+		s.supervision.restarts = 0
+	}
+	s.supervision.restarts++
+	s.supervision.lastExit = exit
+	s.supervision.restarting = true
+	return s.supervision.restarts, true
+}
+
+// replaceRunner installs a relaunched process unless the generation was
+// superseded by Stop or Start while it was booting.
+func (s *Runtime) replaceRunner(generation int, proc runners.Proc, ready bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if generation != s.supervision.generation {
+		return false
+	}
+	s.runner = proc
+	s.supervision.restarting = !ready
+	return true
+}
+
+// crashLooping gives up on the process and reports the failure: the runner
+// is cleared so the next Start launches from scratch.
+func (s *Runtime) crashLooping(w *wool.Wool, generation int, exit *processExit, restarts int) {
+	s.mu.Lock()
+	if generation != s.supervision.generation {
+		s.mu.Unlock()
+		return
+	}
+	s.runner = nil
+	s.supervision.restarting = false
+	s.mu.Unlock()
+
+	err := fmt.Errorf("fastapi app crash loop: %s after %d restart(s); last output:\n%s", exit, restarts, exit.Output)
+	w.Error("fastapi app is crash-looping", wool.ErrField(err))
+	s.Base.Runtime.MarkRunnerExited(err)
+}
+
+// supervisionNote describes past crashes for Information: a service that
+// is restarting, or recovered from crashes, says so. A crash loop is
+// already an ERROR StartStatus.
+func (s *Runtime) supervisionNote() string {
+	s.mu.Lock()
+	restarts, limit, last, restarting := s.supervision.restarts, s.supervision.limit, s.supervision.lastExit, s.supervision.restarting
+	s.mu.Unlock()
+	switch {
+	case last == nil:
+		return ""
+	case restarting:
+		return fmt.Sprintf("restarting (attempt %d/%d) after %s", restarts, limit, last)
+	default:
+		return fmt.Sprintf("restarted %d time(s); last crash at %s: %s", restarts, last.At.Format(time.RFC3339), last)
+	}
+}
+
+// exitedProc stands in for a relaunch that failed before becoming ready.
+type exitedProc struct {
+	runners.Proc
+	err error
+}
+
+func (p exitedProc) Wait(context.Context) error              { return p.err }
+func (p exitedProc) Stop(context.Context) error              { return nil }
+func (p exitedProc) IsRunning(context.Context) (bool, error) { return false, nil }
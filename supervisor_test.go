@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		6:  restartMaxBackoff,
+		40: restartMaxBackoff,
+	}
+	for attempt, want := range cases {
+		if got := restartBackoff(attempt); got != want {
+			t.Errorf("attempt %d: got %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRestartLimit(t *testing.T) {
+	cases := map[int]int{0: defaultMaxRestarts, 3: 3, -1: 0}
+	for setting, want := range cases {
+		s := &Settings{MaxRestarts: setting}
+		if got := s.RestartLimit(); got != want {
+			t.Errorf("max-restarts %d: got %d, want %d", setting, got, want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := exitCode(nil); got != 0 {
+		t.Errorf("nil: got %d", got)
+	}
+	// The docker backend formats the status into the error.
+	if got := exitCode(fmt.Errorf("wait: %w", errors.New("process exited with code 137"))); got != 137 {
+		t.Errorf("docker: got %d", got)
+	}
+	if got := exitCode(errors.New("cannot inspect process")); got != -1 {
+		t.Errorf("unknown: got %d", got)
+	}
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	if got := exitCode(fmt.Errorf("server exited: %w", err)); got != 3 {
+		t.Errorf("native: got %d", got)
+	}
+}
+
+// TestRecordExitIgnoresIntentionalStop: Stop swaps the runner out before
+// stopping it, so the exit its watcher then sees must not count as a crash.
+func TestRecordExitIgnoresIntentionalStop(t *testing.T) {
+	s := &Runtime{}
+	s.setRunner(exitedProc{})
+	generation := s.supervision.generation
+
+	s.setRunner(nil) // Stop
+	if _, ok := s.recordExit(generation, &processExit{Code: 0}, time.Second); ok {
+		t.Fatal("exit after Stop was recorded as a crash")
+	}
+	if s.supervision.lastExit != nil {
+		t.Error("lastExit set by an intentional stop")
+	}
+}
+
+func TestRecordExitCountsConsecutiveCrashes(t *testing.T) {
+	s := &Runtime{}
+	s.setRunner(exitedProc{})
+	generation := s.supervision.generation
+
+	for want := 1; want <= 3; want++ {
+		attempt, ok := s.recordExit(generation, &processExit{Code: 1}, time.Second)
+		if !ok || attempt != want {
+			t.Fatalf("crash %d: got attempt %d (ok=%v)", want, attempt, ok)
+		}
+	}
+	// A process that served long enough starts a fresh budget.
+	if attempt, _ := s.recordExit(generation, &processExit{Code: 1}, stableUptime); attempt != 1 {
+		t.Errorf("stable crash: got attempt %d, want 1", attempt)
+	}
+	if !s.supervision.restarting {
+		t.Error("expected the restarting flag after a crash")
+	}
+	if !s.replaceRunner(generation, exitedProc{}, true) || s.supervision.restarting {
+		t.Error("a ready relaunch must clear the restarting flag")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+// systempackages.go — OS packages and fixed environment of the service.
+//
+// system-packages names the system libraries the service needs (libpq,
+// libffi, …), per backend when the names differ. Build installs the apk
+// names in both stages of the image (the images are Alpine), next to the
+// build-env variables; the nix backend adds the nix names to the devShell
+// of the agent's flake; the native backend checks the native names with
+// the host package manager and installs the missing ones when it can
+// (without root, it reports the command to run). Host packages are not
+// artifacts: Destroy leaves them.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/codefly-dev/core/wool"
+	"gopkg.in/yaml.v3"
+)
+
+// SystemPackage is an OS package, named per backend; an empty name skips
+// the backend. The plain form uses the same name everywhere.
+//
+//	system-packages:
+//	  - libffi
+//	  - apk: libpq             # image (Alpine)
+//	    nix: postgresql.lib    # nix devShell (nixpkgs attribute)
+//	    native: libpq-dev      # host package manager
+type SystemPackage struct {
+	APK    string `yaml:"apk"`
+	Nix    string `yaml:"nix"`
+	Native string `yaml:"native"`
+}
+
+// UnmarshalYAML accepts the plain and the per-backend forms.
+func (p *SystemPackage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = SystemPackage{APK: node.Value, Nix: node.Value, Native: node.Value}
+		return nil
+	}
+	type plain SystemPackage
+	return node.Decode((*plain)(p))
+}
+
+// MarshalYAML writes the plain form back when it applies.
+func (p SystemPackage) MarshalYAML() (any, error) {
+	if p.APK == p.Nix && p.Nix == p.Native {
+		return p.APK, nil
+	}
+	type plain SystemPackage
+	return plain(p), nil
+}
+
+var (
+	// systemPackageName excludes anything the shell would interpret in
+	// RUN apk add: a name, with an apk version constraint (=, ~).
+	systemPackageName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+=~-]*$`)
+	envKey            = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// validateSystemPackages rejects names and variables that do not fit in
+// a Dockerfile line.
+func (s *Settings) validateSystemPackages() error {
+	for _, p := range s.SystemPackages {
+		if p.APK == "" && p.Nix == "" && p.Native == "" {
+			return fmt.Errorf("system-packages: an entry names no package")
+		}
+		for _, name := range []string{p.APK, p.Nix, p.Native} {
+			if name != "" && !systemPackageName.MatchString(name) {
+				return fmt.Errorf("system-packages: invalid package name %q", name)
+			}
+		}
+	}
+	for key, value := range s.BuildEnv {
+		if !envKey.MatchString(key) {
+			return fmt.Errorf("build-env: invalid variable name %q", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("build-env: %s spans several lines", key)
+		}
+	}
+	return nil
+}
+
+// systemPackages are the names for one backend, in order, without
+// duplicates.
+func (s *Settings) systemPackages(backend func(SystemPackage) string) []string {
+	var names []string
+	for _, p := range s.SystemPackages {
+		if name := backend(p); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *Settings) apkPackages() []string {
+	return s.systemPackages(func(p SystemPackage) string { return p.APK })
+}
+
+func (s *Settings) nixPackages() []string {
+	return s.systemPackages(func(p SystemPackage) string { return p.Nix })
+}
+
+func (s *Settings) nativePackages() []string {
+	return s.systemPackages(func(p SystemPackage) string { return p.Native })
+}
+
+// buildEnvs are the build-env variables, sorted for a stable Dockerfile.
+func (s *Settings) buildEnvs() []Env {
+	envs := make([]Env, 0, len(s.BuildEnv))
+	for key, value := range s.BuildEnv {
+		envs = append(envs, Env{Key: key, Value: value})
+	}
+	sort.Slice(envs, func(i, j int) bool { return envs[i].Key < envs[j].Key })
+	return envs
+}
+
+// packageManager is a host package manager: how to tell an installed
+// package, and how to install the missing ones.
+type packageManager struct {
+	Name    string
+	Check   []string
+	Install []string
+	// Root managers install as root only.
+	Root bool
+}
+
+// packageManagers are tried in order; the first on the PATH is used.
+var packageManagers = []packageManager{
+	{Name: "apt-get", Check: []string{"dpkg", "-s"}, Install: []string{"apt-get", "install", "-y"}, Root: true},
+	{Name: "dnf", Check: []string{"rpm", "-q"}, Install: []string{"dnf", "install", "-y"}, Root: true},
+	{Name: "apk", Check: []string{"apk", "info", "-e"}, Install: []string{"apk", "add", "--no-cache"}, Root: true},
+	{Name: "brew", Check: []string{"brew", "list", "--versions"}, Install: []string{"brew", "install"}},
+}
+
+// hostPackageManager is the first package manager found on the PATH.
+func hostPackageManager() (*packageManager, bool) {
+	for i := range packageManagers {
+		if _, err := exec.LookPath(packageManagers[i].Name); err == nil {
+			return &packageManagers[i], true
+		}
+	}
+	return nil, false
+}
+
+// provisionNativePackages installs the missing native packages on the host.
+func (s *Service) provisionNativePackages(ctx context.Context) error {
+	packages := s.Settings.nativePackages()
+	if len(packages) == 0 {
+		return nil
+	}
+	manager, ok := hostPackageManager()
+	if !ok {
+		return fmt.Errorf("system-packages: no supported package manager on this host; install %s", strings.Join(packages, ", "))
+	}
+	var missing []string
+	for _, name := range packages {
+		check := exec.CommandContext(ctx, manager.Check[0], slices.Concat(manager.Check[1:], []string{name})...)
+		if check.Run() != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	install := slices.Concat(manager.Install, missing)
+	if manager.Root && os.Geteuid() != 0 {
+		return fmt.Errorf("system-packages: %s missing; install them with: sudo %s", strings.Join(missing, ", "), strings.Join(install, " "))
+	}
+	s.Wool.Info("installing system packages", wool.Field("manager", manager.Name), wool.Field("packages", missing))
+	cmd := exec.CommandContext(ctx, install[0], install[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("system-packages: %s: %w: %s", strings.Join(install, " "), err, lastLines(output.String(), 20))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/templates"
+	"gopkg.in/yaml.v3"
+)
+
+const systemPackagesSample = `system-packages:
+  - libffi
+  - apk: libpq
+    nix: postgresql.lib
+    native: libpq-dev
+  - nix: pkg-config
+build-env:
+  PIP_NO_CACHE_DIR: "1"
+  LANG: C.UTF-8
+`
+
+func TestSystemPackagesSettings(t *testing.T) {
+	var settings Settings
+	if err := yaml.Unmarshal([]byte(systemPackagesSample), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.validateSystemPackages(); err != nil {
+		t.Fatal(err)
+	}
+	if got := settings.apkPackages(); !slices.Equal(got, []string{"libffi", "libpq"}) {
+		t.Errorf("apk: got %v", got)
+	}
+	if got := settings.nixPackages(); !slices.Equal(got, []string{"libffi", "postgresql.lib", "pkg-config"}) {
+		t.Errorf("nix: got %v", got)
+	}
+	if got := settings.nativePackages(); !slices.Equal(got, []string{"libffi", "libpq-dev"}) {
+		t.Errorf("native: got %v", got)
+	}
+	if got := settings.buildEnvs(); len(got) != 2 || got[0] != (Env{Key: "LANG", Value: "C.UTF-8"}) {
+		t.Errorf("got %v", got)
+	}
+
+	out, err := yaml.Marshal(settings.SystemPackages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "- libffi\n- apk: libpq\n") {
+		t.Errorf("got %s", out)
+	}
+
+	for _, invalid := range []Settings{
+		{SystemPackages: []SystemPackage{{APK: "libpq && curl evil"}}},
+		{SystemPackages: []SystemPackage{{}}},
+		{BuildEnv: map[string]string{"1FOO": "bar"}},
+		{BuildEnv: map[string]string{"FOO": "bar\nRUN evil"}},
+	} {
+		if err := invalid.validateSystemPackages(); err == nil {
+			t.Errorf("%v accepted", invalid)
+		}
+	}
+}
+
+func TestDockerfileSystemPackages(t *testing.T) {
+	docker := DockerTemplating{
+		Builder:         "codeflydev/python:3.12",
+		Components:      []string{"code/src"},
+		RuntimePackages: []string{"libffi", "libpq"},
+		Envs:            []Env{{Key: "LANG", Value: "C.UTF-8"}},
+		Command:         `["uvicorn"]`,
+	}
+	rendered, err := templates.ApplyTemplateFrom(context.Background(), shared.Embed(builderFS), "templates/builder/Dockerfile", docker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(rendered, "RUN apk add --no-cache libffi libpq\n"); n != 2 {
+		t.Errorf("packages installed in %d stage(s):\n%s", n, rendered)
+	}
+	if n := strings.Count(rendered, "ENV LANG=\"C.UTF-8\"\n"); n != 2 {
+		t.Errorf("environment set in %d stage(s):\n%s", n, rendered)
+	}
+	runtime := rendered[strings.Index(rendered, "as runtime"):]
+	if strings.Index(runtime, "RUN apk add") > strings.Index(runtime, "USER appuser") {
+		t.Errorf("packages installed as appuser:\n%s", rendered)
+	}
+
+	plain, err := templates.ApplyTemplateFrom(context.Background(), shared.Embed(builderFS), "templates/builder/Dockerfile", DockerTemplating{Command: `["uvicorn"]`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain, "apk add") || strings.Contains(plain, "ENV LANG") {
+		t.Errorf("got:\n%s", plain)
+	}
+}
+
+func TestDockerfileBuildEnvQuoting(t *testing.T) {
+	docker := DockerTemplating{
+		Envs: []Env{
+			{Key: "EMPTY", Value: ""},
+			{Key: "QUOTED", Value: `say "hi" \ $HOME ${PATH}`},
+		},
+		Command: `["uvicorn"]`,
+	}
+	rendered, err := templates.ApplyTemplateFrom(context.Background(), shared.Embed(builderFS), "templates/builder/Dockerfile", docker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"ENV EMPTY=\"\"\n",
+		`ENV QUOTED="say \"hi\" \\ \$HOME \${PATH}"` + "\n",
+	} {
+		if n := strings.Count(rendered, line); n != 2 {
+			t.Errorf("%q in %d stage(s):\n%s", line, n, rendered)
+		}
+	}
+}
+
+func TestNixFlakeSystemPackages(t *testing.T) {
+	flake, err := nixFlake("python312", []string{"postgresql.lib", "pkg-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(flake, "              pkgs.ruff\n              pkgs.postgresql.lib\n              pkgs.pkg-config\n") {
+		t.Errorf("got:\n%s", flake)
+	}
+
+	// Adding a package rewrites the agent's flake.
+	dir := t.TempDir()
+	if _, err := ensureNixFlake(dir, "python312", nil); err != nil {
+		t.Fatal(err)
+	}
+	if managed, err := ensureNixFlake(dir, "python312", []string{"libffi"}); err != nil || !managed {
+		t.Fatalf("got %v, %v", managed, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "flake.nix")); !strings.Contains(string(content), "pkgs.libffi\n") {
+		t.Errorf("got:\n%s", content)
+	}
+}
+
+func TestProvisionNativePackages(t *testing.T) {
+	// A brew knowing libffi only, alone on the PATH.
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\nif [ \"$1\" = list ]; then [ \"$3\" = libffi ]; exit $?; fi\necho \"$@\" > %q\n", args)
+	if err := os.WriteFile(filepath.Join(dir, "brew"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	svc := newTestTooling(t).FastAPI
+	svc.Settings.SystemPackages = []SystemPackage{{Native: "libffi"}, {Native: "libpq"}, {APK: "postgresql-dev"}}
+	if err := svc.provisionNativePackages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(args); string(content) != "install libpq\n" {
+		t.Errorf("ran brew %q", content)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if err := svc.provisionNativePackages(context.Background()); err == nil || !strings.Contains(err.Error(), "install libffi, libpq") {
+		t.Errorf("got %v", err)
+	}
+}
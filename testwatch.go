@@ -0,0 +1,364 @@
+package main
+
+// testwatch.go — rerunning the tests affected by a change.
+//
+// With test-watch, Start installs the watcher on code/src and code/tests
+// (with or without hot-reload). A change to a Python file schedules a run:
+// the files modified since the last completed run are mapped, through the
+// call graph of src and tests, to the test files calling into them
+// directly or not, and only those run. Results go to the logger, one line per test.
+// The watcher's debounce only hands over the last event of a burst, hence
+// the scan by modification time: it sees every file of the burst.
+//
+// Which file depends on which comes from the generic Code layer's AST
+// analysis, Code.ComputePythonCallGraph: a file depends on the files
+// defining the functions and classes it calls, matched by name, so a
+// shared name can run a few tests too many, never too few. The analysis
+// has no import edges: a change it cannot place — a file defining nothing
+// it follows (constants, a package __init__, a syntax error) — runs the
+// whole suite. The watcher copies what it needs from the Runtime when it
+// is created, as its runs go on outside the lifecycle operation lock.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codefly-dev/core/builders"
+	runtimev0 "github.com/codefly-dev/core/generated/go/codefly/services/runtime/v0"
+	"github.com/codefly-dev/core/shared"
+	"github.com/codefly-dev/core/wool"
+	pythoncode "github.com/codefly-dev/service-python/pkg/code"
+	pythonservice "github.com/codefly-dev/service-python/pkg/service"
+)
+
+const (
+	// testWatchDebounce lets a burst of saves settle into one run.
+	testWatchDebounce = 300 * time.Millisecond
+	// testWatchReportsDir keeps watch runs off the reports of Test.
+	testWatchReportsDir = ".cache/tests/watch"
+	// callGraphLimit is where ComputePythonCallGraph truncates its calls.
+	callGraphLimit = 10000
+)
+
+// testWatchDependencies adds the tests to what the runtime watches.
+var testWatchDependencies = builders.NewDependencies(agent.Name,
+	append(slices.Clone(runtimeWatch.Components),
+		builders.NewDependency("code/tests").WithPathSelect(shared.NewSelect("*.py")))...)
+
+// testWatcher reruns the affected tests. Runs never overlap: a change
+// during a run cancels it, and the next run covers its changes too.
+type testWatcher struct {
+	wool *wool.Wool
+	// dir is the source location.
+	dir     string
+	code    *pythoncode.Code
+	pytest  *pytestRunner
+	trigger *debouncer
+
+	// serial is held by the run in progress.
+	serial sync.Mutex
+
+	mu sync.Mutex
+	// since is when the last completed run started: files modified before
+	// were tested.
+	since   time.Time
+	cancel  context.CancelFunc
+	stopped bool
+	running sync.WaitGroup
+}
+
+func newTestWatcher(ctx context.Context, s *Runtime) *testWatcher {
+	w := &testWatcher{
+		wool: s.Wool,
+		dir:  s.Service.SourceLocation,
+		// The analysis runs in the service's environment, as it is now.
+		code: pythoncode.New(&pythonservice.Service{
+			SourceLocation: s.Service.SourceLocation,
+			ActiveEnv:      s.FastAPI.serviceEnvironment(ctx),
+		}),
+		pytest: s.newPytestRunner(),
+		since:  time.Now(),
+	}
+	w.trigger = newDebouncer(testWatchDebounce, w.run)
+	return w
+}
+
+// Trigger schedules a run.
+func (w *testWatcher) Trigger() {
+	w.trigger.Trigger()
+}
+
+// Stop cancels the run in progress and waits for it.
+func (w *testWatcher) Stop() {
+	w.trigger.Stop()
+	w.mu.Lock()
+	w.stopped = true
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.mu.Unlock()
+	w.running.Wait()
+}
+
+func (w *testWatcher) run() {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	if w.cancel != nil {
+		w.cancel()
+	}
+	ctx, cancel := context.WithCancel(w.wool.Inject(context.Background()))
+	w.cancel = cancel
+	w.running.Add(1)
+	w.mu.Unlock()
+	defer w.running.Done()
+	defer cancel()
+
+	w.serial.Lock()
+	defer w.serial.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	w.mu.Lock()
+	since := w.since
+	w.mu.Unlock()
+
+	started := time.Now()
+	completed, err := w.runAffectedTests(ctx, since)
+	if err != nil && ctx.Err() == nil {
+		w.wool.Warn("cannot run the affected tests", wool.ErrField(err))
+	}
+	if !completed || ctx.Err() != nil {
+		// Superseded or failed to start: the next run covers these changes.
+		return
+	}
+	w.mu.Lock()
+	if started.After(w.since) {
+		w.since = started
+	}
+	w.mu.Unlock()
+}
+
+// runAffectedTests runs the tests affected by the sources modified since
+// the given time. completed is false when no run happened.
+func (w *testWatcher) runAffectedTests(ctx context.Context, since time.Time) (completed bool, err error) {
+	changed, err := modifiedSources(w.dir, since)
+	if err != nil {
+		return false, err
+	}
+	if len(changed) == 0 {
+		return true, nil
+	}
+	graph, err := w.dependencyGraph(ctx)
+	if err != nil {
+		return false, err
+	}
+	tests, all := graph.affectedTests(changed)
+	switch {
+	case all:
+		w.wool.Forwardf("running every test: %s changed", strings.Join(changed, ", "))
+		tests = nil
+	case len(tests) == 0:
+		w.wool.Forwardf("no tests affected by %s", strings.Join(changed, ", "))
+		return true, nil
+	default:
+		w.wool.Forwardf("running %d test file(s) affected by %s", len(tests), strings.Join(changed, ", "))
+	}
+	resp := w.pytest.run(ctx, &runtimev0.TestRequest{ExtraArgs: tests}, path.Join(w.dir, testWatchReportsDir), false)
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	logTestCases(w.wool, resp)
+	return true, nil
+}
+
+// logTestCases forwards one line per test; the run forwarded the summary.
+func logTestCases(w *wool.Wool, resp *runtimev0.TestResponse) {
+	for _, suite := range resp.GetSuites() {
+		for _, c := range suite.GetCases() {
+			name := suite.GetFile() + "::" + c.GetName()
+			switch c.GetState() {
+			case runtimev0.TestCaseState_TEST_CASE_STATE_PASSED:
+				w.Forwardf("PASS %s (%s)", name, c.GetDuration().AsDuration().Round(time.Millisecond))
+			case runtimev0.TestCaseState_TEST_CASE_STATE_SKIPPED:
+				w.Forwardf("SKIP %s: %s", name, c.GetFailure().GetSkipReason())
+			case runtimev0.TestCaseState_TEST_CASE_STATE_FAILED, runtimev0.TestCaseState_TEST_CASE_STATE_ERRORED:
+				line := fmt.Sprintf("FAIL %s: %s", name, c.GetFailure().GetMessage())
+				if loc := c.GetFailure().GetSourceLocation(); loc != nil {
+					line += fmt.Sprintf(" (%s:%d)", loc.GetFile(), loc.GetLine())
+				}
+				w.Forwardf("%s", line)
+			}
+		}
+	}
+}
+
+// modifiedSources lists the Python files of src and tests modified after
+// since, relative to the source location.
+func modifiedSources(sourceLocation string, since time.Time) ([]string, error) {
+	var changed []string
+	for _, dir := range []string{"src", "tests"} {
+		err := filepath.WalkDir(filepath.Join(sourceLocation, dir), func(p string, entry os.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				if entry.Name() == "__pycache__" || strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(p, ".py") {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().After(since) {
+				return nil
+			}
+			rel, err := filepath.Rel(sourceLocation, p)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// dependencyGraph is the file-level view of the call graph of src and
+// tests, paths relative to the source location.
+type dependencyGraph struct {
+	// calls maps a file to the files defining what it calls.
+	calls map[string][]string
+	// defining are the files defining a function or class.
+	defining map[string]bool
+	// complete is false when the call graph was truncated.
+	complete bool
+}
+
+// dependencyGraph runs the Code layer's call graph analysis.
+func (w *testWatcher) dependencyGraph(ctx context.Context) (*dependencyGraph, error) {
+	result := w.code.ComputePythonCallGraph(ctx, w.dir)
+	if result.Error != "" {
+		return nil, fmt.Errorf("call graph analysis: %s", result.Error)
+	}
+	return newDependencyGraph(result), nil
+}
+
+// newDependencyGraph maps calls to the files defining the callee, by its
+// last name: client.get and get both match a get defined anywhere.
+func newDependencyGraph(result pythoncode.CallGraphResult) *dependencyGraph {
+	inScope := func(file string) bool {
+		return strings.HasPrefix(file, "src/") || strings.HasPrefix(file, "tests/")
+	}
+	g := &dependencyGraph{calls: map[string][]string{}, defining: map[string]bool{}, complete: len(result.Calls) < callGraphLimit}
+	definers := map[string][]string{}
+	define := func(id string) {
+		file, qualified, ok := strings.Cut(id, ":")
+		if !ok || !inScope(file) {
+			return
+		}
+		g.defining[file] = true
+		for _, name := range strings.Split(qualified, ".") {
+			if !slices.Contains(definers[name], file) {
+				definers[name] = append(definers[name], file)
+			}
+		}
+	}
+	for _, call := range result.Calls {
+		define(call.CallerID)
+	}
+	for _, edge := range result.Implements {
+		define(edge.TypeID)
+	}
+	for _, call := range result.Calls {
+		if !inScope(call.File) {
+			continue
+		}
+		name := call.CalleeName[strings.LastIndex(call.CalleeName, ".")+1:]
+		for _, file := range definers[name] {
+			if file != call.File && !slices.Contains(g.calls[call.File], file) {
+				g.calls[call.File] = append(g.calls[call.File], file)
+			}
+		}
+	}
+	return g
+}
+
+// isTestFile follows pytest's default discovery.
+func isTestFile(file string) bool {
+	base := path.Base(file)
+	return strings.HasPrefix(file, "tests/") && strings.HasSuffix(base, ".py") &&
+		(strings.HasPrefix(base, "test_") || strings.HasSuffix(base, "_test.py"))
+}
+
+// affectedTests lists the test files calling into a changed file, directly
+// or through other files; a conftest.py affects every test below it. all
+// is set when a change cannot be placed: the whole suite runs.
+func (g *dependencyGraph) affectedTests(changed []string) (tests []string, all bool) {
+	if !g.complete {
+		return nil, true
+	}
+	callers := map[string][]string{}
+	known := map[string]bool{}
+	for file, callees := range g.calls {
+		known[file] = true
+		for _, callee := range callees {
+			callers[callee] = append(callers[callee], file)
+		}
+	}
+	for file := range g.defining {
+		known[file] = true
+	}
+
+	affected := map[string]bool{}
+	visited := map[string]bool{}
+	queue := slices.Clone(changed)
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if visited[file] {
+			continue
+		}
+		visited[file] = true
+		switch {
+		case isTestFile(file):
+			affected[file] = true
+		case path.Base(file) == "conftest.py":
+			dir := path.Dir(file) + "/"
+			for candidate := range known {
+				if isTestFile(candidate) && strings.HasPrefix(candidate, dir) {
+					affected[candidate] = true
+				}
+			}
+		case !g.defining[file]:
+			return nil, true
+		}
+		queue = append(queue, callers[file]...)
+	}
+
+	tests = make([]string, 0, len(affected))
+	for file := range affected {
+		tests = append(tests, file)
+	}
+	sort.Strings(tests)
+	return tests, false
+}
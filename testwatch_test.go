@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codefly-dev/core/wool"
+	pythoncode "github.com/codefly-dev/service-python/pkg/code"
+)
+
+// orderCalls is the call graph of a service with an orders plugin.
+var orderCalls = pythoncode.CallGraphResult{
+	Calls: []pythoncode.CallEdge{
+		{CallerID: "src/main.py:create_app", CalleeName: "register_plugins", File: "src/main.py"},
+		{CallerID: "src/plugins/registry.py:register_plugins", CalleeName: "include_router", File: "src/plugins/registry.py"},
+		{CallerID: "src/plugins/orders/router.py:create_order", CalleeName: "Order", File: "src/plugins/orders/router.py"},
+		{CallerID: "src/admin/router.py:health", CalleeName: "dict", File: "src/admin/router.py"},
+		{CallerID: "tests/conftest.py:client", CalleeName: "create_app", File: "tests/conftest.py"},
+		{CallerID: "tests/test_main.py:test_app", CalleeName: "client.get", File: "tests/test_main.py"},
+		{CallerID: "tests/test_admin.py:test_health", CalleeName: "router.health", File: "tests/test_admin.py"},
+		{CallerID: "tests/plugins/orders/test_orders.py:test_create", CalleeName: "create_order", File: "tests/plugins/orders/test_orders.py"},
+		{CallerID: "tests/plugins/orders/helpers.py:make_order", CalleeName: "models.Order", File: "tests/plugins/orders/helpers.py"},
+	},
+	Implements: []pythoncode.ImplementsEdge{
+		{TypeID: "src/plugins/orders/models.py:Order", TypeName: "Order", InterfaceID: "BaseModel"},
+		{TypeID: "src/plugins/orders/router.py:OrderRouter", TypeName: "OrderRouter", InterfaceID: "APIRouter"},
+	},
+}
+
+func TestAffectedTests(t *testing.T) {
+	graph := newDependencyGraph(orderCalls)
+	for _, tc := range []struct {
+		changed []string
+		want    []string
+		all     bool
+	}{
+		{changed: []string{"src/admin/router.py"}, want: []string{"tests/test_admin.py"}},
+		// models → router → test_orders, helpers is not a test file.
+		{changed: []string{"src/plugins/orders/models.py"}, want: []string{"tests/plugins/orders/test_orders.py"}},
+		// registry → main → conftest → every test.
+		{changed: []string{"src/plugins/registry.py"}, want: []string{"tests/plugins/orders/test_orders.py", "tests/test_admin.py", "tests/test_main.py"}},
+		{changed: []string{"tests/test_admin.py"}, want: []string{"tests/test_admin.py"}},
+		{changed: []string{"tests/plugins/orders/helpers.py"}, want: []string{}},
+		// Nothing the analysis follows is defined there.
+		{changed: []string{"src/plugins/orders/__init__.py"}, all: true},
+	} {
+		got, all := graph.affectedTests(tc.changed)
+		if all != tc.all || (!all && !slices.Equal(got, tc.want)) {
+			t.Errorf("%v: got %v (all %v), want %v (all %v)", tc.changed, got, all, tc.want, tc.all)
+		}
+	}
+
+	truncated := orderCalls
+	truncated.Calls = make([]pythoncode.CallEdge, callGraphLimit)
+	if _, all := newDependencyGraph(truncated).affectedTests([]string{"src/admin/router.py"}); !all {
+		t.Error("a truncated call graph must run every test")
+	}
+}
+
+func TestModifiedSources(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	for _, f := range []string{"src/main.py", "src/admin/router.py", "tests/test_main.py", "src/__pycache__/main.cpython-313.py", "src/notes.md"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if f == "src/main.py" {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	got, err := modifiedSources(dir, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src/admin/router.py", "tests/test_main.py"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDependencyGraph(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+	ctx := context.Background()
+	rt := NewRuntime(NewService())
+	rt.Wool = wool.Get(ctx).In("testwatch-test")
+	// Not initialized: the graph is computed in the standalone environment.
+	rt.Service.SourceLocation = t.TempDir()
+	for f, content := range map[string]string{
+		"src/__init__.py":                "",
+		"src/plugins/orders/__init__.py": "from .router import router\n",
+		"src/plugins/orders/router.py":   "from .models import Order\n\ndef create_order():\n    return Order()\n",
+		"src/plugins/orders/models.py":   "class Order(BaseModel):\n    pass\n",
+		"src/broken.py":                  "def (:\n",
+		"tests/test_orders.py":           "from src.plugins.orders import router\n\ndef test_create():\n    assert router.create_order()\n",
+	} {
+		p := filepath.Join(rt.Service.SourceLocation, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := newTestWatcher(ctx, rt)
+	// The watcher keeps the source location it was created with.
+	rt.Service.SourceLocation = ""
+	graph, err := w.dependencyGraph(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, all := graph.affectedTests([]string{"src/plugins/orders/models.py"}); all || strings.Join(got, ",") != "tests/test_orders.py" {
+		t.Errorf("got %v (all %v)", got, all)
+	}
+	if _, all := graph.affectedTests([]string{"src/broken.py"}); !all {
+		t.Error("a file the analysis cannot parse must run every test")
+	}
+}
+
+func TestTestWatchDependencies(t *testing.T) {
+	watched := testWatchDependencies.All()
+	for _, want := range []string{"code/src", "code/tests", "code/pyproject.toml"} {
+		if !slices.Contains(watched, want) {
+			t.Errorf("test-watch does not cover %s: %v", want, watched)
+		}
+	}
+}
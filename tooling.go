@@ -0,0 +1,157 @@
+package main
+
+// tooling.go — the FastAPI Tooling and Toolbox.
+//
+// Both wrap the generic ones to add type checking (typecheck.go). The
+// Tooling proto has no type checking RPC: Tooling.Typecheck takes and
+// returns the Lint messages, whose diagnostics carry the structured
+// results, and the Toolbox serves it as lang.typecheck next to the
+// conventional lang.* tools of the bridge. The Toolbox also serves the
+// plugin contributions of other agents (contributions.go).
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/codefly-dev/core/failures"
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	toolboxv0 "github.com/codefly-dev/core/generated/go/codefly/services/toolbox/v0"
+	toolingv0 "github.com/codefly-dev/core/generated/go/codefly/services/tooling/v0"
+	coretoolbox "github.com/codefly-dev/core/toolbox"
+	"github.com/codefly-dev/core/toolbox/lang"
+	"github.com/codefly-dev/core/toolbox/registry"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pythontooling "github.com/codefly-dev/service-python/pkg/tooling"
+)
+
+// ToolTypecheck is the lang.* name of Tooling.Typecheck.
+const ToolTypecheck = "lang.typecheck"
+
+// Tooling is the generic Python Tooling with type checking. Overridden:
+// Build (gated on type errors with typecheck.gate-build).
+type Tooling struct {
+	*pythontooling.Tooling
+
+	FastAPI *Service
+}
+
+// NewTooling wraps the generic Tooling.
+func NewTooling(svc *Service, generic *pythontooling.Tooling) *Tooling {
+	return &Tooling{Tooling: generic, FastAPI: svc}
+}
+
+// Typecheck runs the type checker on the file of the request, or on src.
+// Type errors are reported in the response, not as an error.
+func (t *Tooling) Typecheck(ctx context.Context, req *toolingv0.LintRequest) (*toolingv0.LintResponse, error) {
+	checker, err := t.FastAPI.Settings.Typecheck.checker()
+	if err != nil {
+		return &toolingv0.LintResponse{Output: err.Error(), Failure: failures.New(basev0.FailureCode_FAILURE_CODE_INVALID_CONFIGURATION, "tooling.typecheck", err.Error())}, nil
+	}
+	report, err := t.FastAPI.Typecheck(ctx, t.FastAPI.serviceEnvironment(ctx), checker, req.GetFile())
+	if err != nil {
+		return &toolingv0.LintResponse{Output: err.Error(), Failure: failures.New(basev0.FailureCode_FAILURE_CODE_TOOLCHAIN_UNAVAILABLE, "tooling.typecheck", err.Error())}, nil
+	}
+	success := report.Errors() == 0
+	return &toolingv0.LintResponse{
+		Success:     success,
+		Output:      report.Details(),
+		Diagnostics: report.Diagnostics,
+		Failure:     failures.ForOutcome(success, nil, basev0.FailureCode_FAILURE_CODE_VALIDATION_FAILED, "tooling.typecheck", report.String()),
+	}, nil
+}
+
+// Build has nothing to build for Python; with typecheck.gate-build it
+// fails on type errors.
+func (t *Tooling) Build(ctx context.Context, req *toolingv0.BuildRequest) (*toolingv0.BuildResponse, error) {
+	if !t.FastAPI.Settings.Typecheck.gated() {
+		return t.Tooling.Build(ctx, req)
+	}
+	resp, err := t.Typecheck(ctx, &toolingv0.LintRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return &toolingv0.BuildResponse{
+		Success:     resp.Success,
+		Output:      resp.Output,
+		Diagnostics: resp.Diagnostics,
+		Failure:     resp.Failure,
+	}, nil
+}
+
+// Toolbox is the bridged Toolbox of the Tooling plus lang.typecheck,
+// plugin.contribute and plugin.withdraw.
+type Toolbox struct {
+	*lang.ToolboxFromTooling
+
+	tooling *Tooling
+	builder *Builder
+}
+
+// NewToolbox bridges the Tooling and declares the tools of this agent.
+func NewToolbox(t *Tooling, builder *Builder) *Toolbox {
+	b := &Toolbox{ToolboxFromTooling: lang.NewToolboxFromTooling(agent.Name, agent.Version, t), tooling: t, builder: builder}
+	b.SetTools(slices.Concat(b.Tools(), []*registry.ToolDefinition{typecheckTool()}, contributionTools())...)
+	return b
+}
+
+func typecheckTool() *registry.ToolDefinition {
+	description := "Type check the project (pyright or mypy); returns diagnostics with file, line and severity."
+	schema, _ := structpb.NewStruct(map[string]any{
+		"type": "object", "additionalProperties": false,
+		"properties": map[string]any{
+			"file": map[string]any{"type": "string", "description": "Source-root-relative file path; default: src."},
+		},
+	})
+	return &registry.ToolDefinition{
+		Name:               ToolTypecheck,
+		SummaryDescription: description,
+		LongDescription:    description + " Type errors come back as an unsuccessful response, not as a tool error.",
+		InputSchema:        schema,
+		Tags:               []string{"lang", "language", "read-only", "dev"},
+		Idempotency:        "idempotent",
+		ErrorModes:         "The failure is INVALID_CONFIGURATION for an unknown typecheck.checker, TOOLCHAIN_UNAVAILABLE when the checker could not run, VALIDATION_FAILED on type errors.",
+	}
+}
+
+// CallTool serves the tools of this agent and hands the other tools to the
+// bridge.
+func (b *Toolbox) CallTool(ctx context.Context, req *toolboxv0.CallToolRequest) (*toolboxv0.CallToolResponse, error) {
+	switch req.GetName() {
+	case ToolTypecheck:
+	case ToolContributePlugin, ToolWithdrawPlugin:
+		return b.callContributionTool(ctx, req), nil
+	default:
+		return b.ToolboxFromTooling.CallTool(ctx, req)
+	}
+	typecheck := &toolingv0.LintRequest{}
+	if req.GetArguments() != nil {
+		if err := coretoolbox.ValidateArguments(typecheckTool().InputSchema, req.GetArguments()); err != nil {
+			return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("invalid arguments for %q: %s", ToolTypecheck, err)}, nil
+		}
+		raw, err := protojson.Marshal(req.GetArguments())
+		if err == nil {
+			err = protojson.Unmarshal(raw, typecheck)
+		}
+		if err != nil {
+			return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("cannot decode arguments: %v", err)}, nil
+		}
+	}
+	resp, err := b.tooling.Typecheck(ctx, typecheck)
+	if err != nil {
+		return &toolboxv0.CallToolResponse{Error: err.Error()}, nil
+	}
+	raw, err := protojson.Marshal(resp)
+	if err != nil {
+		return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("cannot encode response: %v", err)}, nil
+	}
+	out := &structpb.Struct{}
+	if err := out.UnmarshalJSON(raw); err != nil {
+		return &toolboxv0.CallToolResponse{Error: fmt.Sprintf("cannot encode response: %v", err)}, nil
+	}
+	return &toolboxv0.CallToolResponse{
+		Content: []*toolboxv0.Content{{Body: &toolboxv0.Content_Structured{Structured: out}}},
+	}, nil
+}
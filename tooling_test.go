@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	basev0 "github.com/codefly-dev/core/generated/go/codefly/base/v0"
+	toolboxv0 "github.com/codefly-dev/core/generated/go/codefly/services/toolbox/v0"
+	toolingv0 "github.com/codefly-dev/core/generated/go/codefly/services/tooling/v0"
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	pythontooling "github.com/codefly-dev/service-python/pkg/tooling"
+)
+
+// fakeUV puts first on the PATH a uv printing the output and exiting
+// with the code; its arguments go to a file, returned.
+func fakeUV(t *testing.T, output string, code int) string {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "output")
+	if err := os.WriteFile(report, []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %q\ncat %q\nexit %d\n", args, report, code)
+	if err := os.WriteFile(filepath.Join(dir, "uv"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return args
+}
+
+func newTestTooling(t *testing.T) *Tooling {
+	ctx := context.Background()
+	svc := NewService()
+	svc.Wool = wool.Get(ctx).In("tooling-test")
+	svc.Service.SourceLocation = t.TempDir()
+	env, err := runners.NewNativeEnvironment(ctx, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Service.ActiveEnv = env
+	return NewTooling(svc, pythontooling.New(nil, nil))
+}
+
+func TestToolingTypecheck(t *testing.T) {
+	ctx := context.Background()
+	tooling := newTestTooling(t)
+	args := fakeUV(t, strings.ReplaceAll(pyrightSample, "/svc/code", tooling.FastAPI.Service.SourceLocation), 1)
+
+	resp, err := tooling.Typecheck(ctx, &toolingv0.LintRequest{File: "src/plugins/orders/router.py"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || len(resp.Diagnostics) != 2 || resp.Failure.GetCode() != basev0.FailureCode_FAILURE_CODE_VALIDATION_FAILED {
+		t.Errorf("got %v", resp)
+	}
+	if !strings.HasPrefix(resp.Output, "pyright: 1 error(s), 1 warning(s)\nsrc/main.py:3:8: warning:") {
+		t.Errorf("got %q", resp.Output)
+	}
+	if content, _ := os.ReadFile(args); !strings.HasSuffix(strings.TrimSpace(string(content)), "pyright --outputjson src/plugins/orders/router.py") {
+		t.Errorf("ran uv %s", content)
+	}
+
+	// Not gated: there is nothing to build.
+	build, err := tooling.Build(ctx, &toolingv0.BuildRequest{})
+	if err != nil || !build.Success {
+		t.Errorf("got %v, %v", build, err)
+	}
+	tooling.FastAPI.Settings.Typecheck = &Typecheck{GateBuild: true}
+	build, err = tooling.Build(ctx, &toolingv0.BuildRequest{})
+	if err != nil || build.Success || len(build.Diagnostics) != 2 {
+		t.Errorf("got %v, %v", build, err)
+	}
+	builder := NewBuilder(tooling.FastAPI)
+	if err := builder.checkTypes(ctx); err == nil || !strings.Contains(err.Error(), "1 type error(s)") {
+		t.Errorf("got %v", err)
+	}
+
+	// No report: pyright did not run.
+	fakeUV(t, "error: Failed to spawn: `pyright`\n", 2)
+	resp, _ = tooling.Typecheck(ctx, &toolingv0.LintRequest{})
+	if resp.Success || resp.Failure.GetCode() != basev0.FailureCode_FAILURE_CODE_TOOLCHAIN_UNAVAILABLE || !strings.Contains(resp.Output, "Failed to spawn") {
+		t.Errorf("got %v", resp)
+	}
+}
+
+func TestToolboxTypecheck(t *testing.T) {
+	ctx := context.Background()
+	fakeUV(t, mypySample, 1)
+	tooling := newTestTooling(t)
+	tooling.FastAPI.Settings.Typecheck = &Typecheck{Checker: CheckerMypy}
+	toolbox := NewToolbox(tooling, NewBuilder(tooling.FastAPI))
+
+	listed, err := toolbox.ListTools(ctx, &toolboxv0.ListToolsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tool := range listed.Tools {
+		names = append(names, tool.Name)
+	}
+	for _, want := range []string{ToolTypecheck, "lang.lint", "lang.build"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("%s not listed: %v", want, names)
+		}
+	}
+
+	args, _ := structpb.NewStruct(map[string]any{"file": "src/main.py"})
+	resp, err := toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: ToolTypecheck, Arguments: args})
+	if err != nil || resp.Error != "" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	out := resp.Content[0].GetStructured().AsMap()
+	if diagnostics, _ := out["diagnostics"].([]any); len(diagnostics) != 2 || out["success"] == true {
+		t.Errorf("got %v", out)
+	}
+
+	args, _ = structpb.NewStruct(map[string]any{"target": "src"})
+	if resp, _ := toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: ToolTypecheck, Arguments: args}); resp.Error == "" {
+		t.Error("unknown argument accepted")
+	}
+
+	tooling.FastAPI.Settings.Typecheck.Checker = "pyre"
+	resp, _ = toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: ToolTypecheck})
+	failure, _ := resp.Content[0].GetStructured().AsMap()["failure"].(map[string]any)
+	if failure["code"] != basev0.FailureCode_FAILURE_CODE_INVALID_CONFIGURATION.String() {
+		t.Errorf("got %v", failure)
+	}
+
+	// The bridged tools are still served.
+	resp, _ = toolbox.CallTool(ctx, &toolboxv0.CallToolRequest{Name: "lang.build"})
+	if resp.Error != "" || resp.Content[0].GetStructured().AsMap()["success"] != true {
+		t.Errorf("got %v", resp)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+// typecheck.go — static type checking with pyright or mypy.
+//
+// Ruff, inherited from the generic layer, does not check types, so with
+// pydantic models most type errors only surface at runtime. The checker
+// (typecheck.checker: pyright by default, or mypy) runs through uv in the
+// service's environment, added to the run unless the project declares it,
+// and its machine-readable output is parsed into Tooling diagnostics:
+// file relative to the source location, 1-based line and column,
+// severity, rule. It is served as Tooling.Typecheck and the
+// lang.typecheck tool (tooling.go); with typecheck.gate-build, Build (the
+// image build and Tooling.Build) fails on any type error.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	toolingv0 "github.com/codefly-dev/core/generated/go/codefly/services/tooling/v0"
+	runners "github.com/codefly-dev/core/runners/base"
+	"github.com/codefly-dev/core/wool"
+)
+
+// Type checkers (typecheck.checker setting).
+const (
+	CheckerPyright = "pyright"
+	CheckerMypy    = "mypy"
+)
+
+// Typecheck configures static type checking (typecheck setting).
+//
+//	typecheck:
+//	  checker: mypy      # optional, default: pyright
+//	  gate-build: true   # Build fails on type errors
+type Typecheck struct {
+	Checker   string `yaml:"checker"`
+	GateBuild bool   `yaml:"gate-build"`
+}
+
+// Validate rejects an unknown checker.
+func (t *Typecheck) Validate() error {
+	_, err := t.checker()
+	return err
+}
+
+// checker is the configured checker; unset, it is pyright.
+func (t *Typecheck) checker() (string, error) {
+	if t == nil || t.Checker == "" {
+		return CheckerPyright, nil
+	}
+	switch t.Checker {
+	case CheckerPyright, CheckerMypy:
+		return t.Checker, nil
+	default:
+		return "", fmt.Errorf("unknown typecheck.checker %q (expected %s or %s)", t.Checker, CheckerPyright, CheckerMypy)
+	}
+}
+
+// gated is true when type errors fail Build.
+func (t *Typecheck) gated() bool {
+	return t != nil && t.GateBuild
+}
+
+// typecheckReport is the result of a checker run.
+type typecheckReport struct {
+	Checker     string
+	Diagnostics []*toolingv0.Diagnostic
+	// Output is what the checker printed.
+	Output string
+}
+
+func (r *typecheckReport) count(severity toolingv0.DiagnosticSeverity) int {
+	n := 0
+	for _, d := range r.Diagnostics {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Errors is the number of type errors.
+func (r *typecheckReport) Errors() int {
+	return r.count(toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_ERROR)
+}
+
+func (r *typecheckReport) String() string {
+	return fmt.Sprintf("%s: %d error(s), %d warning(s)", r.Checker, r.Errors(),
+		r.count(toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_WARNING))
+}
+
+// Details is the summary followed by one line per diagnostic.
+func (r *typecheckReport) Details() string {
+	lines := []string{r.String()}
+	for _, d := range r.Diagnostics {
+		lines = append(lines, formatDiagnostic(d))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatDiagnostic follows the compilers: file:line:column: severity: message [code].
+func formatDiagnostic(d *toolingv0.Diagnostic) string {
+	severity := strings.ToLower(strings.TrimPrefix(d.Severity.String(), "DIAGNOSTIC_SEVERITY_"))
+	line := fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, severity, d.Message)
+	if d.Code != "" {
+		line += " [" + d.Code + "]"
+	}
+	return line
+}
+
+// Typecheck runs the checker on a file, or on src, in the environment.
+func (s *Service) Typecheck(ctx context.Context, env runners.RunnerEnvironment, checker string, file string) (*typecheckReport, error) {
+	target := "src"
+	if file != "" {
+		target = file
+	}
+	args := typecheckArgs(checker, target, !declaresDependency(s.Service.SourceLocation, checker))
+	s.Wool.Debug("type checking", wool.Field("args", args))
+	proc, err := env.NewProcess("uv", args...)
+	if err != nil {
+		return nil, s.Wool.Wrapf(err, "cannot create %s process", checker)
+	}
+	var output bytes.Buffer
+	proc.WithOutput(&output)
+	proc.WithDir(s.Service.SourceLocation)
+	// Both checkers exit non-zero when they report errors: the output
+	// tells whether they ran.
+	runErr := proc.Run(ctx)
+
+	report := &typecheckReport{Checker: checker, Output: output.String()}
+	if checker == CheckerMypy {
+		report.Diagnostics = parseMypyOutput(output.Bytes(), s.Service.SourceLocation)
+		if len(report.Diagnostics) == 0 {
+			err = runErr
+		}
+	} else if report.Diagnostics, err = parsePyrightOutput(output.Bytes(), s.Service.SourceLocation); err != nil && runErr != nil {
+		err = runErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s did not run: %w: %s", checker, err, lastLines(output.String(), 20))
+	}
+	return report, nil
+}
+
+// typecheckArgs is the uv command line. with adds the checker to the run
+// for projects that do not declare it.
+func typecheckArgs(checker string, target string, with bool) []string {
+	args := []string{"run"}
+	if with {
+		args = append(args, "--with", checker)
+	}
+	if checker == CheckerMypy {
+		// JSON output needs mypy 1.11.
+		args = append(args, "mypy", "--output", "json", "--no-error-summary", "--no-pretty")
+	} else {
+		args = append(args, "pyright", "--outputjson")
+	}
+	return append(args, target)
+}
+
+type pyrightPosition struct {
+	Line      int32 `json:"line"`
+	Character int32 `json:"character"`
+}
+
+// pyrightOutput is the part of pyright --outputjson read here. Positions
+// are 0-based.
+type pyrightOutput struct {
+	GeneralDiagnostics []struct {
+		File     string `json:"file"`
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+		Rule     string `json:"rule"`
+		Range    struct {
+			Start pyrightPosition `json:"start"`
+			End   pyrightPosition `json:"end"`
+		} `json:"range"`
+	} `json:"generalDiagnostics"`
+}
+
+// parsePyrightOutput reads the JSON document, which follows what uv
+// printed while resolving the environment.
+func parsePyrightOutput(output []byte, sourceLocation string) ([]*toolingv0.Diagnostic, error) {
+	start := 0
+	if !bytes.HasPrefix(output, []byte("{")) {
+		if start = bytes.Index(output, []byte("\n{")) + 1; start == 0 {
+			return nil, fmt.Errorf("no JSON report in the output")
+		}
+	}
+	var report pyrightOutput
+	if err := json.NewDecoder(bytes.NewReader(output[start:])).Decode(&report); err != nil {
+		return nil, fmt.Errorf("unexpected pyright report: %w", err)
+	}
+	diagnostics := []*toolingv0.Diagnostic{}
+	for _, d := range report.GeneralDiagnostics {
+		diagnostics = append(diagnostics, &toolingv0.Diagnostic{
+			File:      typecheckFile(d.File, sourceLocation),
+			Line:      d.Range.Start.Line + 1,
+			Column:    d.Range.Start.Character + 1,
+			EndLine:   d.Range.End.Line + 1,
+			EndColumn: d.Range.End.Character + 1,
+			Message:   d.Message,
+			Severity:  typecheckSeverity(d.Severity),
+			Source:    CheckerPyright,
+			Code:      d.Rule,
+		})
+	}
+	sortDiagnostics(diagnostics)
+	return diagnostics, nil
+}
+
+// mypyError is a line of mypy --output json. The column is 0-based, -1
+// when unknown; notes attached to an error come as its hint.
+type mypyError struct {
+	File     string  `json:"file"`
+	Line     int32   `json:"line"`
+	Column   int32   `json:"column"`
+	Message  string  `json:"message"`
+	Hint     *string `json:"hint"`
+	Code     *string `json:"code"`
+	Severity string  `json:"severity"`
+}
+
+// parseMypyOutput reads the JSON lines and skips everything else.
+func parseMypyOutput(output []byte, sourceLocation string) []*toolingv0.Diagnostic {
+	diagnostics := []*toolingv0.Diagnostic{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte("{")) {
+			continue
+		}
+		var e mypyError
+		if err := json.Unmarshal(line, &e); err != nil || e.File == "" {
+			continue
+		}
+		d := &toolingv0.Diagnostic{
+			File:     typecheckFile(e.File, sourceLocation),
+			Line:     e.Line,
+			Message:  e.Message,
+			Severity: typecheckSeverity(e.Severity),
+			Source:   CheckerMypy,
+		}
+		if e.Column >= 0 {
+			d.Column = e.Column + 1
+		}
+		if e.Hint != nil && *e.Hint != "" {
+			d.Message += "\n" + *e.Hint
+		}
+		if e.Code != nil {
+			d.Code = *e.Code
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	sortDiagnostics(diagnostics)
+	return diagnostics
+}
+
+func typecheckSeverity(severity string) toolingv0.DiagnosticSeverity {
+	switch severity {
+	case "error":
+		return toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_ERROR
+	case "warning":
+		return toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_WARNING
+	case "information", "note":
+		return toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_INFORMATION
+	default:
+		return toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_UNKNOWN
+	}
+}
+
+// typecheckFile makes pyright's absolute paths relative to the source
+// location; mypy's already are.
+func typecheckFile(file string, sourceLocation string) string {
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(sourceLocation, file); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(file)
+}
+
+func sortDiagnostics(diagnostics []*toolingv0.Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// lastLines keeps the end of an output, where the error is.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkTypes is the Build gate (typecheck.gate-build): it logs the type
+// errors and fails when there are any.
+func (s *Builder) checkTypes(ctx context.Context) error {
+	settings := s.FastAPI.Settings.Typecheck
+	if !settings.gated() {
+		return nil
+	}
+	checker, err := settings.checker()
+	if err != nil {
+		return err
+	}
+	report, err := s.FastAPI.Typecheck(ctx, s.FastAPI.serviceEnvironment(ctx), checker, "")
+	if err != nil {
+		return err
+	}
+	for _, d := range report.Diagnostics {
+		if d.Severity == toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_ERROR {
+			s.Wool.Warn("type error", wool.Field("diagnostic", formatDiagnostic(d)))
+		}
+	}
+	if errors := report.Errors(); errors > 0 {
+		return fmt.Errorf("%d type error(s) reported by %s (typecheck.gate-build)", errors, checker)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	toolingv0 "github.com/codefly-dev/core/generated/go/codefly/services/tooling/v0"
+)
+
+func TestTypecheckChecker(t *testing.T) {
+	var unset *Typecheck
+	if checker, err := unset.checker(); err != nil || checker != CheckerPyright {
+		t.Errorf("got %q, %v", checker, err)
+	}
+	if checker, err := (&Typecheck{Checker: CheckerMypy}).checker(); err != nil || checker != CheckerMypy {
+		t.Errorf("got %q, %v", checker, err)
+	}
+	if err := (&Settings{Typecheck: &Typecheck{Checker: "pyre"}}).Validate(); err == nil {
+		t.Error("unknown checker accepted")
+	}
+	if unset.gated() {
+		t.Error("gated by default")
+	}
+}
+
+func TestTypecheckArgs(t *testing.T) {
+	want := []string{"run", "--with", "pyright", "pyright", "--outputjson", "src"}
+	if args := typecheckArgs(CheckerPyright, "src", true); !slices.Equal(args, want) {
+		t.Errorf("got %v", args)
+	}
+	want = []string{"run", "mypy", "--output", "json", "--no-error-summary", "--no-pretty", "src/main.py"}
+	if args := typecheckArgs(CheckerMypy, "src/main.py", false); !slices.Equal(args, want) {
+		t.Errorf("got %v", args)
+	}
+}
+
+func TestDeclaresDependency(t *testing.T) {
+	dir := t.TempDir()
+	pyproject := "[dependency-groups]\ndev = [\n    \"mypy-extensions>=1.0\",\n    \"pytest-cov>=5.0.0\",\n    \"pyright\",\n]\n"
+	if err := os.WriteFile(filepath.Join(dir, "pyproject.toml"), []byte(pyproject), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"pytest-cov": true, "pyright": true, "mypy": false, "pytest": false} {
+		if got := declaresDependency(dir, name); got != want {
+			t.Errorf("%s: got %v", name, got)
+		}
+	}
+}
+
+const pyrightSample = `Installed 2 packages in 4ms
+{
+    "version": "1.1.390",
+    "time": "1730000000000",
+    "generalDiagnostics": [
+        {
+            "file": "/svc/code/src/plugins/orders/router.py",
+            "severity": "error",
+            "message": "Argument of type \"str\" cannot be assigned to parameter \"quantity\" of type \"int\"",
+            "range": {"start": {"line": 41, "character": 8}, "end": {"line": 41, "character": 20}},
+            "rule": "reportArgumentType"
+        },
+        {
+            "file": "/svc/code/src/main.py",
+            "severity": "warning",
+            "message": "Import \"uvloop\" could not be resolved from source",
+            "range": {"start": {"line": 2, "character": 7}, "end": {"line": 2, "character": 13}},
+            "rule": "reportMissingModuleSource"
+        }
+    ],
+    "summary": {"filesAnalyzed": 12, "errorCount": 1, "warningCount": 1, "informationCount": 0, "timeInSec": 0.8}
+}
+`
+
+func TestParsePyrightOutput(t *testing.T) {
+	diagnostics, err := parsePyrightOutput([]byte(pyrightSample), "/svc/code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 2 {
+		t.Fatalf("got %v", diagnostics)
+	}
+	d := diagnostics[1]
+	if d.File != "src/plugins/orders/router.py" || d.Line != 42 || d.Column != 9 || d.EndColumn != 21 ||
+		d.Severity != toolingv0.DiagnosticSeverity_DIAGNOSTIC_SEVERITY_ERROR || d.Code != "reportArgumentType" || d.Source != CheckerPyright {
+		t.Errorf("got %v", d)
+	}
+	report := &typecheckReport{Checker: CheckerPyright, Diagnostics: diagnostics}
+	if report.Errors() != 1 || report.String() != "pyright: 1 error(s), 1 warning(s)" {
+		t.Errorf("got %s", report)
+	}
+	if got := formatDiagnostic(diagnostics[0]); got != `src/main.py:3:8: warning: Import "uvloop" could not be resolved from source [reportMissingModuleSource]` {
+		t.Errorf("got %s", got)
+	}
+
+	if _, err := parsePyrightOutput([]byte("error: Failed to spawn: `pyright`\n"), "/svc/code"); err == nil {
+		t.Error("output without a report accepted")
+	}
+}
+
+const mypySample = `{"file": "src/plugins/orders/router.py", "line": 42, "column": 8, "message": "Argument \"quantity\" to \"Order\" has incompatible type \"str\"; expected \"int\"", "hint": null, "code": "arg-type", "severity": "error"}
+{"file": "src/main.py", "line": 3, "column": -1, "message": "Library stubs not installed for \"yaml\"", "hint": "Hint: \"python3 -m pip install types-PyYAML\"", "code": "import-untyped", "severity": "error"}
+not json
+`
+
+func TestParseMypyOutput(t *testing.T) {
+	diagnostics := parseMypyOutput([]byte(mypySample), "/svc/code")
+	if len(diagnostics) != 2 {
+		t.Fatalf("got %v", diagnostics)
+	}
+	if d := diagnostics[0]; d.File != "src/main.py" || d.Column != 0 || d.Message != "Library stubs not installed for \"yaml\"\nHint: \"python3 -m pip install types-PyYAML\"" {
+		t.Errorf("got %v", d)
+	}
+	if d := diagnostics[1]; d.Line != 42 || d.Column != 9 || d.Code != "arg-type" || d.Source != CheckerMypy {
+		t.Errorf("got %v", d)
+	}
+}